@@ -0,0 +1,379 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/nuclio/errors"
+	"gopkg.in/alecthomas/kingpin.v2"
+	"gopkg.in/yaml.v3"
+)
+
+// the config files, from the lowest precedence to the highest
+const (
+	userConfigFileName  = "kibini/config.yaml"
+	localConfigFileName = ".kibini.yaml"
+)
+
+var (
+	configCommand = app.Command("config", "Print the settings applied from config files, and where each came from")
+)
+
+// configSettings are values of flags by name, which become the flags' defaults. Values of flags which may be
+// repeated may be lists. Flags of commands are given per command
+type configSettings struct {
+	Flags    map[string]interface{}            `yaml:"flags"`
+	Commands map[string]map[string]interface{} `yaml:"commands"`
+}
+
+// config is the contents of a config file: settings which always apply, and named profiles of settings which
+// apply when selected (by --profile, or by the file's default profile)
+type config struct {
+	configSettings `yaml:",inline"`
+	Profile        string                     `yaml:"profile"`
+	Profiles       map[string]*configSettings `yaml:"profiles"`
+
+	path string
+}
+
+// appliedSetting is a flag value applied from a config file
+type appliedSetting struct {
+	flag   *kingpin.FlagClause
+	name   string
+	values []string
+	source string
+}
+
+// applyConfig loads the config files and sets the values of the selected profile as the defaults of the flags.
+// Precedence, from lowest to highest: built in defaults, the user config (~/.config/kibini/config.yaml), the
+// local config (./.kibini.yaml), the selected profile (from the user config, then the local one), environment
+// variables (KIBINI_<FLAG>) and finally the command line. Returns the applied settings, for the config command
+func applyConfig(args []string) ([]*appliedSetting, error) {
+	var configs []*config
+
+	for _, configPath := range getConfigPaths() {
+		loadedConfig, err := loadConfig(configPath)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to load config %s", configPath)
+		}
+
+		if loadedConfig != nil {
+			configs = append(configs, loadedConfig)
+		}
+	}
+
+	profileName := getProfileName(args, configs)
+
+	// gather settings by flag, later ones overriding earlier ones
+	settingsByFlag := map[*kingpin.FlagClause]*appliedSetting{}
+	var flagOrder []*kingpin.FlagClause
+
+	addSettings := func(settings *configSettings, source string) error {
+		return forEachConfigFlag(settings, func(command string, flagName string, value interface{}) error {
+			flag, name, err := getConfigFlag(command, flagName)
+			if err != nil {
+				return errors.Wrapf(err, "Invalid setting in %s", source)
+			}
+
+			values, err := getConfigFlagValues(flag, value)
+			if err != nil {
+				return errors.Wrapf(err, "Invalid value of %s in %s", name, source)
+			}
+
+			if _, found := settingsByFlag[flag]; !found {
+				flagOrder = append(flagOrder, flag)
+			}
+
+			settingsByFlag[flag] = &appliedSetting{
+				flag:   flag,
+				name:   name,
+				values: values,
+				source: source,
+			}
+
+			return nil
+		})
+	}
+
+	for _, loadedConfig := range configs {
+		if err := addSettings(&loadedConfig.configSettings, loadedConfig.path); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(profileName) != 0 {
+		profileFound := false
+
+		for _, loadedConfig := range configs {
+			if profile, found := loadedConfig.Profiles[profileName]; found && profile != nil {
+				if err := addSettings(profile, fmt.Sprintf("profile %s of %s", profileName, loadedConfig.path)); err != nil {
+					return nil, err
+				}
+
+				profileFound = true
+			}
+		}
+
+		if !profileFound {
+			return nil, errors.New(fmt.Sprintf("Unknown profile %q (defined profiles: %s)",
+				profileName,
+				strings.Join(getProfileNames(configs), ", ")))
+		}
+	}
+
+	var appliedSettings []*appliedSetting
+
+	for _, flag := range flagOrder {
+		setting := settingsByFlag[flag]
+		flag.Default(setting.values...)
+
+		appliedSettings = append(appliedSettings, setting)
+	}
+
+	return appliedSettings, nil
+}
+
+// getConfigPaths returns the paths of the config files, from the lowest precedence to the highest
+func getConfigPaths() []string {
+	var configPaths []string
+
+	configDirectory := os.Getenv("XDG_CONFIG_HOME")
+	if len(configDirectory) == 0 {
+		if homeDirectory, err := os.UserHomeDir(); err == nil {
+			configDirectory = filepath.Join(homeDirectory, ".config")
+		}
+	}
+
+	if len(configDirectory) != 0 {
+		configPaths = append(configPaths, filepath.Join(configDirectory, userConfigFileName))
+	}
+
+	return append(configPaths, localConfigFileName)
+}
+
+// loadConfig reads a config file. Returns nil if it doesn't exist
+func loadConfig(path string) (*config, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, errors.Wrap(err, "Failed to read config")
+	}
+
+	loadedConfig := config{path: path}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(contents))
+	decoder.KnownFields(true)
+
+	if err := decoder.Decode(&loadedConfig); err != nil && err != io.EOF {
+		return nil, errors.Wrap(err, "Failed to parse config")
+	}
+
+	return &loadedConfig, nil
+}
+
+// getProfileName returns the profile given on the command line, in the environment or as the default profile
+// of a config (the local one first)
+func getProfileName(args []string, configs []*config) string {
+	for argIndex, arg := range args {
+		if arg == "--" {
+			break
+		}
+
+		if arg == "--profile" && argIndex+1 < len(args) {
+			return args[argIndex+1]
+		}
+
+		if strings.HasPrefix(arg, "--profile=") {
+			return strings.TrimPrefix(arg, "--profile=")
+		}
+	}
+
+	if profileName := os.Getenv("KIBINI_PROFILE"); len(profileName) != 0 {
+		return profileName
+	}
+
+	for configIndex := len(configs) - 1; configIndex >= 0; configIndex-- {
+		if len(configs[configIndex].Profile) != 0 {
+			return configs[configIndex].Profile
+		}
+	}
+
+	return ""
+}
+
+func getProfileNames(configs []*config) []string {
+	profileNames := map[string]interface{}{}
+
+	for _, loadedConfig := range configs {
+		for profileName := range loadedConfig.Profiles {
+			profileNames[profileName] = nil
+		}
+	}
+
+	if len(profileNames) == 0 {
+		return []string{"none"}
+	}
+
+	var sortedProfileNames []string
+	for profileName := range profileNames {
+		sortedProfileNames = append(sortedProfileNames, profileName)
+	}
+
+	sort.Strings(sortedProfileNames)
+
+	return sortedProfileNames
+}
+
+// forEachConfigFlag calls visit for each flag of the settings (with an empty command for app flags), sorted so
+// that errors are reported consistently
+func forEachConfigFlag(settings *configSettings,
+	visit func(command string, flagName string, value interface{}) error) error {
+
+	var commands []string
+	for command := range settings.Commands {
+		commands = append(commands, command)
+	}
+
+	sort.Strings(commands)
+
+	for _, command := range append([]string{""}, commands...) {
+		flags := settings.Flags
+		if len(command) != 0 {
+			flags = settings.Commands[command]
+		}
+
+		for _, flagName := range getSortedSettingNames(flags) {
+			if err := visit(command, flagName, flags[flagName]); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func getSortedSettingNames(settings map[string]interface{}) []string {
+	var names []string
+	for name := range settings {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// getConfigFlag returns the flag of the app (or of the command, if given) by name
+func getConfigFlag(command string, flagName string) (*kingpin.FlagClause, string, error) {
+	if len(command) == 0 {
+		if flagName == "help" || flagName == "version" || flagName == "profile" {
+			return nil, "", errors.New(fmt.Sprintf("Flag %s can't be set in a config", flagName))
+		}
+
+		flag := app.GetFlag(flagName)
+		if flag == nil {
+			return nil, "", errors.New(fmt.Sprintf("Unknown flag: %s", flagName))
+		}
+
+		return flag, "--" + flagName, nil
+	}
+
+	commandClause := app.GetCommand(command)
+	if commandClause == nil {
+		return nil, "", errors.New(fmt.Sprintf("Unknown command: %s", command))
+	}
+
+	flag := commandClause.GetFlag(flagName)
+	if flag == nil {
+		return nil, "", errors.New(fmt.Sprintf("Unknown flag of command %s: %s", command, flagName))
+	}
+
+	return flag, command + " --" + flagName, nil
+}
+
+// getConfigFlagValues returns the values a config gives a flag as strings, and validates them by setting them
+// (they're set again, as defaults, when parsing). Only flags which may be repeated accept lists
+func getConfigFlagValues(flag *kingpin.FlagClause, value interface{}) ([]string, error) {
+	var values []string
+
+	cumulativeFlag, isCumulative := flag.Model().Value.(interface{ IsCumulative() bool })
+	isCumulative = isCumulative && cumulativeFlag.IsCumulative()
+
+	switch typedValue := value.(type) {
+	case []interface{}:
+		if !isCumulative {
+			return nil, errors.New("A list was given, but the flag may not be repeated")
+		}
+
+		for _, item := range typedValue {
+			formattedItem, err := formatConfigValue(item)
+			if err != nil {
+				return nil, err
+			}
+
+			values = append(values, formattedItem)
+		}
+
+	default:
+		formattedValue, err := formatConfigValue(typedValue)
+		if err != nil {
+			return nil, err
+		}
+
+		values = append(values, formattedValue)
+	}
+
+	// setting repeated flags appends to them, and they accept anything anyway
+	if !isCumulative {
+		if err := flag.Model().Value.Set(values[0]); err != nil {
+			return nil, errors.Wrap(err, "Invalid value")
+		}
+	}
+
+	return values, nil
+}
+
+func formatConfigValue(value interface{}) (string, error) {
+	switch value.(type) {
+	case nil:
+		return "", nil
+	case map[string]interface{}, []interface{}:
+		return "", errors.New("Expected a string, number or boolean")
+	}
+
+	return fmt.Sprint(value), nil
+}
+
+func runConfig(rc *runContext) error {
+	fmt.Println("Config files (lowest precedence first; then environment variables and flags):")
+
+	for _, configPath := range getConfigPaths() {
+		status := "not found"
+		if _, err := os.Stat(configPath); err == nil {
+			status = "loaded"
+		}
+
+		fmt.Printf("  %s (%s)\n", configPath, status)
+	}
+
+	if len(rc.appliedSettings) == 0 {
+		fmt.Println("\nNo settings applied")
+		return nil
+	}
+
+	fmt.Println("\nSettings (as defaults, unless overridden by environment variables or flags):")
+
+	for _, setting := range rc.appliedSettings {
+		fmt.Printf("  %s=%s (%s)\n", setting.name, strings.Join(setting.values, ","), setting.source)
+	}
+
+	return nil
+}
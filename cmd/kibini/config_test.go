@@ -0,0 +1,186 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const configTestLog = `{"when":"2023-01-02T10:00:01.000","who":"api","severity":"info","what":"hello world","ctx":"c1"}` + "\n"
+
+// runKibiniCSV runs kibini on the test log, writing csv to stdout, and returns the output
+func runKibiniCSV(t *testing.T, workDirectory string, environment []string, args ...string) *kibiniRun {
+	t.Helper()
+
+	return runKibini(t, workDirectory, environment, append([]string{
+		"--input-path", "logs",
+		"--output-mode", "single",
+		"--stdout",
+		"--output-format", "csv",
+	}, args...)...)
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	for _, testCase := range []struct {
+		name            string
+		userConfig      string
+		localConfig     string
+		environment     []string
+		args            []string
+		expectedColumns string
+	}{
+		{
+			name:            "user",
+			userConfig:      "flags:\n  columns: who\n",
+			expectedColumns: "who",
+		},
+		{
+			name:            "local over user",
+			userConfig:      "flags:\n  columns: who\n",
+			localConfig:     "flags:\n  columns: severity\n",
+			expectedColumns: "severity",
+		},
+		{
+			name:            "profile of the user config over local",
+			userConfig:      "flags:\n  columns: who\nprofiles:\n  brief:\n    flags:\n      columns: what\n",
+			localConfig:     "flags:\n  columns: severity\nprofile: brief\n",
+			expectedColumns: "what",
+		},
+		{
+			name:            "profile from the command line",
+			localConfig:     "flags:\n  columns: severity\nprofiles:\n  brief:\n    flags:\n      columns: what\n",
+			args:            []string{"--profile", "brief"},
+			expectedColumns: "what",
+		},
+		{
+			name:            "profile from the environment",
+			localConfig:     "flags:\n  columns: severity\nprofiles:\n  brief:\n    flags:\n      columns: what\n",
+			environment:     []string{"KIBINI_PROFILE=brief"},
+			expectedColumns: "what",
+		},
+		{
+			name:            "environment over profile",
+			userConfig:      "flags:\n  columns: who\n",
+			localConfig:     "profile: brief\nprofiles:\n  brief:\n    flags:\n      columns: what\n",
+			environment:     []string{"KIBINI_COLUMNS=ctx"},
+			expectedColumns: "ctx",
+		},
+		{
+			name:            "command line over environment",
+			userConfig:      "flags:\n  columns: who\n",
+			localConfig:     "profile: brief\nprofiles:\n  brief:\n    flags:\n      columns: what\n",
+			environment:     []string{"KIBINI_COLUMNS=ctx"},
+			args:            []string{"--columns", "when"},
+			expectedColumns: "when",
+		},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			workDirectory := t.TempDir()
+			writeTestFile(t, workDirectory, "logs/api.log", configTestLog)
+
+			if len(testCase.userConfig) != 0 {
+				writeTestFile(t, workDirectory, ".config/"+userConfigFileName, testCase.userConfig)
+			}
+
+			if len(testCase.localConfig) != 0 {
+				writeTestFile(t, workDirectory, localConfigFileName, testCase.localConfig)
+			}
+
+			run := runKibiniCSV(t, workDirectory, testCase.environment, testCase.args...)
+			if run.exitCode != 0 {
+				t.Fatalf("Expected success, got %d (stderr: %s)", run.exitCode, run.stderr)
+			}
+
+			if header := strings.SplitN(run.stdout, "\n", 2)[0]; header != testCase.expectedColumns {
+				t.Fatalf("Expected columns %q, got %q", testCase.expectedColumns, header)
+			}
+		})
+	}
+}
+
+func TestConfigRepeatedFlags(t *testing.T) {
+	workDirectory := t.TempDir()
+	writeTestFile(t, workDirectory, "logs/api.log", configTestLog)
+	writeTestFile(t, workDirectory, localConfigFileName, strings.Join([]string{
+		"flags:",
+		"  columns: more.greeting,more.subject",
+		"  extract:",
+		"    - '^(?P<greeting>\\w+)'",
+		"    - '(?P<subject>\\w+)$'",
+	}, "\n")+"\n")
+
+	run := runKibiniCSV(t, workDirectory, nil)
+	if run.exitCode != 0 {
+		t.Fatalf("Expected success, got %d (stderr: %s)", run.exitCode, run.stderr)
+	}
+
+	if expectedStdout := "more.greeting,more.subject\nhello,world\n"; run.stdout != expectedStdout {
+		t.Fatalf("Expected both expressions to be extracted (%q), got %q", expectedStdout, run.stdout)
+	}
+
+	// the config command lists the repeated values together
+	run = runKibini(t, workDirectory, nil, "config")
+	if !strings.Contains(run.stdout, `--extract=^(?P<greeting>\w+),(?P<subject>\w+)$ (.kibini.yaml)`) {
+		t.Fatalf("Expected the config command to show both values, got %q", run.stdout)
+	}
+}
+
+func TestConfigInvalid(t *testing.T) {
+	for _, testCase := range []struct {
+		name          string
+		localConfig   string
+		args          []string
+		expectedError string
+	}{
+		{
+			name:          "list of a flag which may not be repeated",
+			localConfig:   "flags:\n  columns:\n    - who\n    - what\n",
+			expectedError: "A list was given, but the flag may not be repeated",
+		},
+		{
+			name:          "unknown flag",
+			localConfig:   "flags:\n  colums: who\n",
+			expectedError: "Unknown flag: colums",
+		},
+		{
+			name:          "unknown flag of a command",
+			localConfig:   "commands:\n  grep:\n    bogus: true\n",
+			expectedError: "Unknown flag of command grep: bogus",
+		},
+		{
+			name:          "unknown command",
+			localConfig:   "commands:\n  grap:\n    context: 1\n",
+			expectedError: "Unknown command: grap",
+		},
+		{
+			name:          "unknown field",
+			localConfig:   "flag:\n  columns: who\n",
+			expectedError: "field flag not found",
+		},
+		{
+			name:          "invalid value",
+			localConfig:   "flags:\n  who-width: wide\n",
+			expectedError: "Invalid value of --who-width",
+		},
+		{
+			name:          "unknown profile",
+			localConfig:   "profiles:\n  brief:\n    flags:\n      columns: what\n",
+			args:          []string{"--profile", "verbose"},
+			expectedError: `Unknown profile "verbose" (defined profiles: brief)`,
+		},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			workDirectory := t.TempDir()
+			writeTestFile(t, workDirectory, "logs/api.log", configTestLog)
+			writeTestFile(t, workDirectory, localConfigFileName, testCase.localConfig)
+
+			run := runKibiniCSV(t, workDirectory, nil, testCase.args...)
+			if run.exitCode == 0 {
+				t.Fatalf("Expected failure, got %q", run.stdout)
+			}
+
+			if !strings.Contains(run.stderr, testCase.expectedError) {
+				t.Fatalf("Expected %q, got %q", testCase.expectedError, run.stderr)
+			}
+		})
+	}
+}
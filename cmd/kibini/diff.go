@@ -0,0 +1,38 @@
+package main
+
+import (
+	"github.com/v3io/kibini/pkg/kibini"
+
+	"github.com/nuclio/errors"
+)
+
+var (
+	diffCommand  = app.Command("diff", "Compare the message templates of two runs per who and severity: new, gone and changed ones")
+	diffPathA    = diffCommand.Arg("dirA", "The directory of the baseline run").Required().String()
+	diffPathB    = diffCommand.Arg("dirB", "The directory of the run to compare").Required().String()
+	diffFormat   = diffCommand.Flag("format", "table or json").Default("table").Enum("table", "json")
+	diffRatio    = diffCommand.Flag("ratio", "Report templates whose count changed by this factor").Default("2").Float64()
+	diffMinCount = diffCommand.Flag("min-count", "Report changed templates only if they appear this many times in one of the runs").Default("5").Int()
+	diffExamples = diffCommand.Flag("examples", "The number of example records to print per difference, from each run").Default("1").Int()
+)
+
+func runDiff(rc *runContext) error {
+	format := kibini.DiffFormatTable
+	if *diffFormat == "json" {
+		format = kibini.DiffFormatJSON
+	}
+
+	if err := rc.kibini.Diff(&kibini.DiffOptions{
+		InputOptions: rc.getInputOptions(""),
+		InputPathA:   *diffPathA,
+		InputPathB:   *diffPathB,
+		Format:       format,
+		Ratio:        *diffRatio,
+		MinCount:     *diffMinCount,
+		Examples:     *diffExamples,
+	}); err != nil {
+		return errors.Wrap(err, "Failed to diff")
+	}
+
+	return nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"github.com/v3io/kibini/pkg/kibini"
+
+	"github.com/nuclio/errors"
+)
+
+var (
+	grepCommand    = app.Command("grep", "Print records whose what or more match a pattern, merged (exits with 1 if nothing matched)")
+	grepPattern    = grepCommand.Arg("pattern", "The pattern to search for").Required().String()
+	grepRegex      = grepCommand.Flag("extended-regexp", "The pattern is a regular expression").Short('E').Bool()
+	grepIgnoreCase = grepCommand.Flag("ignore-case", "Match regardless of case").Short('i').Bool()
+	grepWord       = grepCommand.Flag("word-regexp", "Match only whole words").Short('w').Bool()
+	grepAfter      = grepCommand.Flag("after", "Print this many records after each match").Short('A').Int()
+	grepBefore     = grepCommand.Flag("before", "Print this many records before each match").Short('B').Int()
+	grepContext    = grepCommand.Flag("context", "Print this many records before and after each match").Short('C').Int()
+)
+
+// errNoMatch is returned by runGrep if nothing matched, so that main exits with 1 (like grep) once everything
+// deferred is done
+var errNoMatch = errors.New("No records matched")
+
+func runGrep(rc *runContext) error {
+	before, after := *grepBefore, *grepAfter
+
+	// like grep, -A and -B take precedence over -C
+	if before == 0 {
+		before = *grepContext
+	}
+
+	if after == 0 {
+		after = *grepContext
+	}
+
+	matchCount, err := rc.kibini.Grep(&kibini.GrepOptions{
+		ProcessLogsOptions: kibini.ProcessLogsOptions{
+			InputOptions:   rc.getInputOptions(""),
+			OutputFormat:   getOutputFormat(*appOutputFormat),
+			Columns:        kibini.ParseColumns(*appColumns),
+			ColorSetting:   *appColorSetting,
+			WhoWidth:       *appWhoWidth,
+			FormatTemplate: *appFormatTpl,
+			Dedup:          getDedupMode(*appDedup),
+			DedupMoreKeys:  kibini.ParseColumns(*appDedupKeys),
+			SpanConfig:     rc.spanConfig,
+		},
+		Pattern:    *grepPattern,
+		Regex:      *grepRegex,
+		IgnoreCase: *grepIgnoreCase,
+		Word:       *grepWord,
+		Before:     before,
+		After:      after,
+	})
+
+	if err != nil {
+		return errors.Wrap(err, "Failed to grep")
+	}
+
+	if matchCount == 0 {
+		return errNoMatch
+	}
+
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGrepExitStatus(t *testing.T) {
+	workDirectory := t.TempDir()
+	writeTestFile(t, workDirectory, "logs/api.log", strings.Join([]string{
+		`{"when":"2023-01-02T10:00:01.000","who":"api","severity":"info","what":"login from 10.0.0.1"}`,
+		`{"when":"2023-01-02T10:00:02.000","who":"api","severity":"info","what":"request handled"}`,
+		`{"when":"2023-01-02T10:00:03.000","who":"api","severity":"info","what":"request handled"}`,
+		`{"when":"2023-01-02T10:00:04.000","who":"api","severity":"info","what":"request handled"}`,
+		`{"when":"2023-01-02T10:00:05.000","who":"api","severity":"error","what":"request failed"}`,
+	}, "\n")+"\n")
+
+	for _, testCase := range []struct {
+		args             []string
+		expectedExitCode int
+		expectedStdout   []string
+	}{
+		{
+			args:             []string{"grep", "failed"},
+			expectedExitCode: 0,
+			expectedStdout:   []string{"request failed"},
+		},
+		{
+			args:             []string{"grep", "-C", "1", "-E", "login|failed"},
+			expectedExitCode: 0,
+			expectedStdout:   []string{"login from", "request handled", "--", "request handled", "request failed"},
+		},
+
+		// no match isn't an error, but exits with 1 like grep, once the redaction summary was written
+		{
+			args:             []string{"--redact", "grep", "timed out"},
+			expectedExitCode: 1,
+		},
+	} {
+		args := append([]string{"--input-path", "logs", "--color", "off"}, testCase.args...)
+		run := runKibini(t, workDirectory, nil, args...)
+
+		if run.exitCode != testCase.expectedExitCode {
+			t.Fatalf("Expected %v to exit with %d, got %d (stderr: %s)",
+				testCase.args,
+				testCase.expectedExitCode,
+				run.exitCode,
+				run.stderr)
+		}
+
+		var stdoutLines []string
+		for _, line := range strings.Split(strings.TrimSpace(run.stdout), "\n") {
+			if len(line) != 0 {
+				stdoutLines = append(stdoutLines, line)
+			}
+		}
+
+		if len(stdoutLines) != len(testCase.expectedStdout) {
+			t.Fatalf("Expected %v to print %d lines, got %q", testCase.args, len(testCase.expectedStdout), run.stdout)
+		}
+
+		for lineIndex, expectedText := range testCase.expectedStdout {
+			if !strings.Contains(stdoutLines[lineIndex], expectedText) {
+				t.Fatalf("Expected line %d of %v to contain %q, got %q", lineIndex, testCase.args, expectedText, stdoutLines[lineIndex])
+			}
+		}
+
+		if testCase.expectedExitCode == 1 &&
+			(strings.Contains(run.stderr, "No records matched") || !strings.Contains(run.stderr, "Redacted")) {
+			t.Fatalf("Expected only the redaction summary on stderr, got %q", run.stderr)
+		}
+	}
+}
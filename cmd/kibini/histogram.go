@@ -0,0 +1,45 @@
+package main
+
+import (
+	"github.com/v3io/kibini/pkg/kibini"
+
+	"github.com/nuclio/errors"
+)
+
+var (
+	histogramCommand  = app.Command("histogram", "Print the number of records over time, by severity or who (redrawn while following with -f)")
+	histogramInterval = histogramCommand.Flag("interval", "Time span of each bucket").Default("1m").Duration()
+	histogramBy       = histogramCommand.Flag("by", "Break down buckets by this field (severity, who, more.<key>, ...)").Default("severity").String()
+	histogramChart    = histogramCommand.Flag("chart", "sparklines: a line per value; bars: a stacked bar per bucket").Default("sparklines").Enum("sparklines", "bars")
+	histogramFormat   = histogramCommand.Flag("format", "chart, json or csv").Default("chart").Enum("chart", "json", "csv")
+	histogramWidth    = histogramCommand.Flag("width", "Maximum width of sparklines and bars").Default("80").Int()
+	histogramRefresh  = histogramCommand.Flag("refresh", "How often to redraw while following").Default("1s").Duration()
+)
+
+func runHistogram(rc *runContext) error {
+	chart := kibini.HistogramChartSparklines
+	if *histogramChart == "bars" {
+		chart = kibini.HistogramChartBars
+	}
+
+	format := map[string]kibini.HistogramFormat{
+		"chart": kibini.HistogramFormatChart,
+		"json":  kibini.HistogramFormatJSON,
+		"csv":   kibini.HistogramFormatCSV,
+	}[*histogramFormat]
+
+	if err := rc.kibini.Histogram(&kibini.HistogramOptions{
+		InputOptions:    rc.getInputOptions(""),
+		Interval:        *histogramInterval,
+		By:              *histogramBy,
+		Chart:           chart,
+		Format:          format,
+		ColorSetting:    *appColorSetting,
+		Width:           *histogramWidth,
+		RefreshInterval: *histogramRefresh,
+	}); err != nil {
+		return errors.Wrap(err, "Failed to get histogram")
+	}
+
+	return nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/v3io/kibini/pkg/kibini"
+
+	"github.com/nuclio/errors"
+)
+
+var (
+	indexCommand          = app.Command("index", "Build an index next to each log file, used to skip records when filtering")
+	indexBucketInterval   = indexCommand.Flag("bucket-interval", "Time span of the index buckets").Default("1m").Duration()
+	indexBucketMaxRecords = indexCommand.Flag("bucket-max-records", "Maximum records in an index bucket").Default("1024").Int()
+	indexTokens           = indexCommand.Flag("tokens", "Index words, so that text filters can skip records (use --no-tokens for smaller indexes)").Default("true").Bool()
+	indexForce            = indexCommand.Flag("force", "Rebuild indexes which are fresh").Bool()
+)
+
+func runIndex(rc *runContext) error {
+	inputOptions := rc.getInputOptions("")
+
+	startTime := time.Now()
+
+	summaries, err := rc.kibini.IndexLogs(&inputOptions, &kibini.IndexOptions{
+		BucketInterval:   *indexBucketInterval,
+		BucketMaxRecords: *indexBucketMaxRecords,
+		Tokens:           *indexTokens,
+		Force:            *indexForce,
+	})
+
+	if err != nil {
+		return errors.Wrap(err, "Failed to index logs")
+	}
+
+	for _, summary := range summaries {
+		status := "indexed"
+		if !summary.Rebuilt {
+			status = "fresh"
+		}
+
+		fmt.Printf("%s: %s (%d lines, %d records, %d buckets, %d tokens)\n",
+			summary.FileName,
+			status,
+			summary.Lines,
+			summary.Records,
+			summary.Buckets,
+			summary.Tokens)
+	}
+
+	fmt.Printf("Done in %s\n", time.Since(startTime).Round(time.Millisecond))
+
+	return nil
+}
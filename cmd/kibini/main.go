@@ -3,6 +3,7 @@ package main
 import (
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/v3io/kibini/pkg/kibini"
 	"github.com/v3io/kibini/pkg/loggerus"
@@ -14,43 +15,205 @@ import (
 
 var (
 	app             = kingpin.New("kibini", "Like a really bad Kibana if Kibana were any good").DefaultEnvars()
+	appProfile      = app.Flag("profile", "A profile of settings defined in ~/.config/kibini/config.yaml or ./.kibini.yaml").String()
 	appQuiet        = app.Flag("quiet", "Don't log to stdout").Short('q').Bool()
 	appInputPath    = app.Flag("input-path", "Where to look for platform logs").Default(".").String()
 	appInputFollow  = app.Flag("follow", "Tail -f the log files").Short('f').Bool()
 	appOutputPath   = app.Flag("output-path", "Where to output formatted log files").String()
 	appOutputMode   = app.Flag("output-mode", "single: merge all logs; per: one formatted per input").Default("per").Enum("single", "per")
 	appOutputStdout = app.Flag("stdout", "Output to stdout (output-mode must be 'single')").Bool()
-	appSingleFile   = app.Arg("filename", "Format only the given filename").Default("\000").String()
 	appColorSetting = app.Flag("color", "on: use colors when outputting to tty; off: don't use colors; always: always use color").Default("on").Enum("on", "off", "always")
 	appWhoWidth     = app.Flag("who-width", "Set truncate width for 'who' field, default is 45").Default("45").Int()
 	appRegex        = app.Flag("regex", "Process only log files that match the given regex").String()
 	appNoRegex      = app.Flag("no-regex", "Process all log files expect those who match the given regex").String()
+	appFilter       = app.Flag("filter", "Process only records matching a filter expression (e.g. 'severity=error who~adapter \"timed out\"')").String()
+	appDedup        = app.Flag("dedup", "Collapse consecutive alike records into one with a repeat count. exact: same who, severity and what; similar: ignoring numbers").Default("none").Enum("none", "exact", "similar")
+	appDedupKeys    = app.Flag("dedup-keys", "Comma separated 'more' keys whose values must be alike as well for records to collapse ('*' for all)").String()
+	appMetrics      = app.Flag("metrics-listen", "Serve Prometheus metrics at http://<address>/metrics while processing (typically while following)").String()
+	appMetricsCount = app.Flag("metrics-counter", "A metrics counter of the records matching a filter expression: <name>=<filter>, may be repeated").Strings()
+	appMetricsField = app.Flag("metrics-field", "A field whose values are counted as metrics (e.g. more.status), may be repeated").Strings()
+	appMetricsSum   = app.Flag("metrics-sum", "A field whose numeric values are summed as metrics (e.g. more.bytes), may be repeated").Strings()
+	appAlerts       = app.Flag("alerts", "A YAML file of alert rules, evaluated against the records (typically while following)").String()
+	appExtract      = app.Flag("extract", "A regular expression whose named groups are extracted from messages into 'more' (e.g. 'took (?P<took>\\S+)'), may be repeated").Strings()
+	appExtractRules = app.Flag("extract-rules", "A YAML file of extraction rules, optionally scoped by who").String()
+	appRedact       = app.Flag("redact", "Redact secrets and personal information using the built in detectors (see --redact-detectors) before writing records anywhere").Bool()
+	appRedactDetect = app.Flag("redact-detectors", "Comma separated built in detectors used by --redact: "+strings.Join(kibini.RedactionDetectorNames(), ", ")).Default(strings.Join(kibini.RedactionDetectorNames(), ",")).String()
+	appRedactPatt   = app.Flag("redact-pattern", "A regular expression whose matches are redacted: <name>=<regex>, may be repeated").Strings()
+	appRedactKey    = app.Flag("redact-key", "A regular expression of 'more' key names (at any depth) whose values are redacted (e.g. 'password|secret'), may be repeated").Strings()
+	appRedactMode   = app.Flag("redact-mode", "mask: replace with [REDACTED:<rule>]; hash: replace with [<rule>:<hash>]; drop: remove").Default("mask").Enum("mask", "hash", "drop")
+	appSpans        = app.Flag("spans", "A YAML file of rules pairing start and end records into spans, whose durations annotate the end records").String()
+	appSpanStart    = app.Flag("span-start", "A regular expression of records starting a span (e.g. 'Starting (?P<name>.+)'), paired with --span-end").String()
+	appSpanEnd      = app.Flag("span-end", "A regular expression of records ending a span (e.g. 'Done (?P<name>.+)')").String()
+	appSpanKey      = app.Flag("span-key", "The field by which starts and ends are paired: who, ctx or more.<key>").Default("who").String()
+	appTemplates    = app.Flag("template", "Process only records fitting a message template ID (see 'kibini templates'), may be repeated").Strings()
+	appTemplateSim  = app.Flag("template-similarity", "Minimal fraction of tokens a message must share with a template to join it").Default("0.4").Float64()
+	appTemplateDep  = app.Flag("template-depth", "Depth of the template prefix tree (messages are grouped by their first depth-2 tokens)").Default("4").Int()
+	appOutputFormat = app.Flag("output-format", "text: human readable; json: JSON lines in a normalized schema; csv/tsv: selected columns; html: self contained report; sqlite: database").Default("text").Enum("text", "json", "csv", "tsv", "html", "sqlite")
+	appColumns      = app.Flag("columns", "Comma separated columns for csv/tsv: when, source, line, who, severity, what, ctx, more, more.<key>").String()
+	appSinks        = app.Flag("sink", "An output fed by the merger: <path>[;format=<format>][;color=<on|off|always>][;filter=<expression>][;columns=<columns>][;template=<template>] (path 'stdout' is stdout), may be repeated").Strings()
+	appFormatTpl    = app.Flag("format-template", "Format records using a go text/template or a preset (default, short, compact, verbose)").String()
 	version         string
+
+	formatCommand    = app.Command("format", "Format the log files (default)").Default()
+	formatSingleFile = formatCommand.Arg("filename", "Format only the given filename").String()
 )
 
-func getOutputMode(outputModeString string) core.OutputMode {
-	return map[string]core.OutputMode{
-		"single": core.OutputModeSingle,
-		"per":    core.OutputModePer,
+func getOutputMode(outputModeString string) kibini.OutputMode {
+	return map[string]kibini.OutputMode{
+		"single": kibini.OutputModeSingle,
+		"per":    kibini.OutputModePer,
 	}[outputModeString]
 }
 
+func getOutputFormat(outputFormatString string) kibini.OutputFormat {
+
+	// the flag is an enum of the valid names
+	outputFormat, _ := kibini.ParseOutputFormat(outputFormatString)
+
+	return outputFormat
+}
+
+func getDedupMode(dedupModeString string) kibini.DedupMode {
+	return map[string]kibini.DedupMode{
+		"none":    kibini.DedupNone,
+		"exact":   kibini.DedupExact,
+		"similar": kibini.DedupSimilar,
+	}[dedupModeString]
+}
+
+// runContext holds what the commands share, created once the flags are parsed
+type runContext struct {
+	kibini          *kibini.Kibini
+	logExtractor    *kibini.LogExtractor
+	logRedactor     *kibini.LogRedactor
+	spanConfig      *kibini.SpanConfig
+	appliedSettings []*appliedSetting
+}
+
+func (rc *runContext) getInputOptions(singleFile string) kibini.InputOptions {
+	return kibini.InputOptions{
+		InputPath:   *appInputPath,
+		InputFollow: *appInputFollow,
+		Regex:       *appRegex,
+		NoRegex:     *appNoRegex,
+		SingleFile:  singleFile,
+		Filter:      *appFilter,
+		TemplateIDs: *appTemplates,
+		TemplateOptions: kibini.TemplateMinerOptions{
+			Similarity: *appTemplateSim,
+			Depth:      *appTemplateDep,
+		},
+		Extractor: rc.logExtractor,
+		Redactor:  rc.logRedactor,
+	}
+}
+
+// createLogExtractor creates an extractor from the rules file and the inline patterns, if any were given
+func createLogExtractor() (*kibini.LogExtractor, error) {
+	extractionConfig := &kibini.ExtractionConfig{}
+
+	if len(*appExtractRules) != 0 {
+		var err error
+
+		if extractionConfig, err = kibini.LoadExtractionConfig(*appExtractRules); err != nil {
+			return nil, errors.Wrap(err, "Failed to load extraction rules")
+		}
+	}
+
+	for _, pattern := range *appExtract {
+		extractionConfig.Rules = append(extractionConfig.Rules, kibini.ExtractionRuleConfig{
+			Name:    pattern,
+			Pattern: pattern,
+		})
+	}
+
+	if len(extractionConfig.Rules) == 0 {
+		return nil, nil
+	}
+
+	return kibini.NewLogExtractor(extractionConfig)
+}
+
+// createLogRedactor creates a redactor from the redaction flags, if any redaction was requested
+func createLogRedactor() (*kibini.LogRedactor, error) {
+	if !*appRedact && len(*appRedactPatt) == 0 && len(*appRedactKey) == 0 {
+		return nil, nil
+	}
+
+	redactionOptions := kibini.RedactionOptions{
+		Mode: map[string]kibini.RedactionMode{
+			"mask": kibini.RedactionMask,
+			"hash": kibini.RedactionHash,
+			"drop": kibini.RedactionDrop,
+		}[*appRedactMode],
+		KeyPatterns: *appRedactKey,
+	}
+
+	if *appRedact {
+		redactionOptions.Detectors = kibini.ParseColumns(*appRedactDetect)
+	}
+
+	for _, pattern := range *appRedactPatt {
+		redactionPattern, err := kibini.ParseRedactionPattern(pattern)
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to parse redaction pattern")
+		}
+
+		redactionOptions.Patterns = append(redactionOptions.Patterns, redactionPattern)
+	}
+
+	return kibini.NewLogRedactor(&redactionOptions)
+}
+
+// createSpanConfig reads the span rules file and adds the inline rule, if any were given
+func createSpanConfig() (*kibini.SpanConfig, error) {
+	spanConfig := &kibini.SpanConfig{}
+
+	if len(*appSpans) != 0 {
+		var err error
+
+		if spanConfig, err = kibini.LoadSpanConfig(*appSpans); err != nil {
+			return nil, errors.Wrap(err, "Failed to load span rules")
+		}
+	}
+
+	if len(*appSpanStart) != 0 || len(*appSpanEnd) != 0 {
+		if len(*appSpanStart) == 0 || len(*appSpanEnd) == 0 {
+			return nil, errors.New("--span-start and --span-end must be given together")
+		}
+
+		spanConfig.Rules = append(spanConfig.Rules, kibini.SpanRuleConfig{
+			Name:  "span",
+			Start: *appSpanStart,
+			End:   *appSpanEnd,
+			Key:   *appSpanKey,
+		})
+	}
+
+	if len(spanConfig.Rules) == 0 {
+		return nil, nil
+	}
+
+	return spanConfig, nil
+}
+
 func augmentArguments() {
 
-	// if stdout is set, enforce single mode since stdout doesn't make sense we you do "per"
-	if *appOutputStdout {
+	// if stdout or sinks are set, enforce single mode since they don't make sense we you do "per"
+	if *appOutputStdout || len(*appSinks) != 0 {
 		*appOutputMode = "single"
 	}
 
-	// if user didn't pass output path and stdout is disabled, take input path
+	// if user didn't pass output path and stdout and sinks are disabled, take input path
 	// and shove into output path
-	if *appOutputPath == "" && !*appOutputStdout {
+	if *appOutputPath == "" && !*appOutputStdout && len(*appSinks) == 0 {
 		*appOutputPath = *appInputPath
 
 		// if output mode is single - add a default merged name because path needs
 		// to contain a file name and input path is always a dir
 		if *appOutputMode == "single" {
-			*appOutputPath = filepath.Join(*appOutputPath, "merged.log.fmt")
+			*appOutputPath = filepath.Join(*appOutputPath,
+				"merged.log"+kibini.GetOutputFileExtension(getOutputFormat(*appOutputFormat)))
 		}
 	}
 }
@@ -65,8 +228,14 @@ func run() error {
 	}
 	app.Version(version)
 
+	// settings from config files become the defaults of the flags, so they must be applied before parsing
+	appliedSettings, err := applyConfig(os.Args[1:])
+	if err != nil {
+		return errors.Wrap(err, "Failed to apply config")
+	}
+
 	// parse the args, run the subcommand
-	kingpin.MustParse(app.Parse(os.Args[1:]))
+	command := kingpin.MustParse(app.Parse(os.Args[1:]))
 
 	// set log level
 	logLevel := logrus.DebugLevel
@@ -86,29 +255,125 @@ func run() error {
 		return errors.Wrap(err, "Failed to create logger")
 	}
 
-	// create kibini
-	kibini := core.NewKibini(logger)
+	rc := runContext{
+		kibini:          kibini.NewKibini(logger),
+		appliedSettings: appliedSettings,
+	}
+
+	if rc.logExtractor, err = createLogExtractor(); err != nil {
+		return errors.Wrap(err, "Failed to create extractor")
+	}
+
+	if rc.spanConfig, err = createSpanConfig(); err != nil {
+		return errors.Wrap(err, "Failed to create span config")
+	}
+
+	if rc.logRedactor, err = createLogRedactor(); err != nil {
+		return errors.Wrap(err, "Failed to create redactor")
+	}
+
+	// once the command is done, tell how much was redacted
+	if rc.logRedactor != nil {
+		defer rc.logRedactor.WriteSummary(os.Stderr) // nolint: errcheck
+	}
+
+	switch command {
+	case sqlCommand.FullCommand():
+		return runSQL(&rc)
+	case tuiCommand.FullCommand():
+		return runTUI(&rc)
+	case serveCommand.FullCommand():
+		return runServe(&rc)
+	case indexCommand.FullCommand():
+		return runIndex(&rc)
+	case grepCommand.FullCommand():
+		return runGrep(&rc)
+	case traceCommand.FullCommand():
+		return runTrace(&rc)
+	case statsCommand.FullCommand():
+		return runStats(&rc)
+	case histogramCommand.FullCommand():
+		return runHistogram(&rc)
+	case templatesCommand.FullCommand():
+		return runTemplates(&rc)
+	case diffCommand.FullCommand():
+		return runDiff(&rc)
+	case spansCommand.FullCommand():
+		return runSpans(&rc)
+	case configCommand.FullCommand():
+		return runConfig(&rc)
+	default:
+		return runFormat(&rc)
+	}
+}
+
+func runFormat(rc *runContext) error {
 
 	// do argument augmentation
 	augmentArguments()
 
-	return kibini.ProcessLogs(*appInputPath,
-		*appInputFollow,
-		*appOutputPath,
-		getOutputMode(*appOutputMode),
-		*appOutputStdout,
-		*appRegex,
-		*appNoRegex,
-		*appSingleFile,
-		*appColorSetting,
-		*appWhoWidth)
+	var alertConfig *kibini.AlertConfig
+	if len(*appAlerts) != 0 {
+		var err error
+
+		if alertConfig, err = kibini.LoadAlertConfig(*appAlerts); err != nil {
+			return errors.Wrap(err, "Failed to load alert config")
+		}
+	}
+
+	var sinks []*kibini.LogSinkOptions
+	for _, sink := range *appSinks {
+		logSinkOptions, err := kibini.ParseLogSink(sink)
+		if err != nil {
+			return errors.Wrap(err, "Failed to parse sink")
+		}
+
+		sinks = append(sinks, logSinkOptions)
+	}
+
+	metricsOptions := kibini.LogMetricsOptions{
+		Fields: *appMetricsField,
+		Sums:   *appMetricsSum,
+	}
+
+	for _, counter := range *appMetricsCount {
+		logMetricsCounter, err := kibini.ParseLogMetricsCounter(counter)
+		if err != nil {
+			return errors.Wrap(err, "Failed to parse metrics counter")
+		}
 
+		metricsOptions.Counters = append(metricsOptions.Counters, logMetricsCounter)
+	}
+
+	return rc.kibini.ProcessLogs(&kibini.ProcessLogsOptions{
+		InputOptions:         rc.getInputOptions(*formatSingleFile),
+		OutputPath:           *appOutputPath,
+		OutputMode:           getOutputMode(*appOutputMode),
+		OutputStdout:         *appOutputStdout,
+		OutputFormat:         getOutputFormat(*appOutputFormat),
+		Columns:              kibini.ParseColumns(*appColumns),
+		ColorSetting:         *appColorSetting,
+		WhoWidth:             *appWhoWidth,
+		FormatTemplate:       *appFormatTpl,
+		Dedup:                getDedupMode(*appDedup),
+		DedupMoreKeys:        kibini.ParseColumns(*appDedupKeys),
+		Sinks:                sinks,
+		SpanConfig:           rc.spanConfig,
+		AlertConfig:          alertConfig,
+		MetricsListenAddress: *appMetrics,
+		MetricsOptions:       metricsOptions,
+	})
 }
 
 func main() {
 
 	if err := run(); err != nil {
-		errors.PrintErrorStack(os.Stderr, err, 20)
+
+		// not matching isn't a failure, there's just nothing to say
+		if err != errNoMatch {
+			errors.PrintErrorStack(os.Stderr, err, 20)
+		}
+
 		os.Exit(1)
 	}
 
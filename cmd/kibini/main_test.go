@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// the flags are package variables, so each test runs kibini in a process of its own: the test binary, told by
+// this environment variable to run main with the given (newline separated) arguments
+const testArgsEnvironmentVariable = "GO_TEST_KIBINI_ARGS"
+
+func TestMain(m *testing.M) {
+	if args, found := os.LookupEnv(testArgsEnvironmentVariable); found {
+		os.Args = append([]string{"kibini"}, strings.Split(args, "\n")...)
+		main()
+	}
+
+	os.Exit(m.Run())
+}
+
+// kibiniRun is the outcome of running kibini
+type kibiniRun struct {
+	stdout   string
+	stderr   string
+	exitCode int
+}
+
+// runKibini runs kibini in workDirectory, with the given environment variables (<name>=<value>) on top of a
+// clean environment whose home is workDirectory
+func runKibini(t *testing.T, workDirectory string, environment []string, args ...string) *kibiniRun {
+	t.Helper()
+
+	executablePath, err := os.Executable()
+	if err != nil {
+		t.Fatalf("Failed to get the test executable: %s", err)
+	}
+
+	command := exec.Command(executablePath)
+	command.Dir = workDirectory
+	command.Env = append([]string{
+		"PATH=" + os.Getenv("PATH"),
+		"HOME=" + workDirectory,
+		"XDG_CONFIG_HOME=" + filepath.Join(workDirectory, ".config"),
+		testArgsEnvironmentVariable + "=" + strings.Join(args, "\n"),
+	}, environment...)
+
+	var stdout, stderr bytes.Buffer
+	command.Stdout = &stdout
+	command.Stderr = &stderr
+
+	run := kibiniRun{}
+
+	if err := command.Run(); err != nil {
+		exitError, isExitError := err.(*exec.ExitError)
+		if !isExitError {
+			t.Fatalf("Failed to run kibini: %s", err)
+		}
+
+		run.exitCode = exitError.ExitCode()
+	}
+
+	run.stdout = stdout.String()
+	run.stderr = stderr.String()
+
+	return &run
+}
+
+// writeTestFile writes contents to a file in directory, creating the directories it's in
+func writeTestFile(t *testing.T, directory string, name string, contents string) {
+	t.Helper()
+
+	path := filepath.Join(directory, name)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("Failed to create the directory of %s: %s", path, err)
+	}
+
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %s", path, err)
+	}
+}
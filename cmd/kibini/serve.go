@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/v3io/kibini/pkg/kibini"
+
+	"github.com/nuclio/errors"
+)
+
+var (
+	serveCommand       = app.Command("serve", "Serve a web UI for browsing the log files (follows with -f)")
+	serveListenAddress = serveCommand.Flag("listen", "Address to listen on").Default("127.0.0.1:8080").String()
+)
+
+func runServe(rc *runContext) error {
+	inputOptions := rc.getInputOptions("")
+	logRecordStore := kibini.NewLogRecordStore()
+
+	logServer, err := kibini.NewLogServer(rc.kibini.GetLogger(), logRecordStore, *serveListenAddress)
+	if err != nil {
+		return errors.Wrap(err, "Failed to create server")
+	}
+
+	// read the logs into the store while serving
+	readErrors := make(chan error, 1)
+	go func() {
+		readErrors <- rc.kibini.ReadLogs(&inputOptions, []kibini.LogWriter{logRecordStore})
+	}()
+
+	serveErrors := make(chan error, 1)
+	go func() {
+		serveErrors <- logServer.ListenAndServe()
+	}()
+
+	fmt.Printf("Serving on http://%s\n", *serveListenAddress)
+
+	for {
+		select {
+		case err := <-readErrors:
+			if err != nil {
+				return errors.Wrap(err, "Failed to read logs")
+			}
+		case err := <-serveErrors:
+			return errors.Wrap(err, "Failed to serve")
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"github.com/v3io/kibini/pkg/kibini"
+
+	"github.com/nuclio/errors"
+)
+
+var (
+	spansCommand = app.Command("spans", "Pair start and end records into spans (see --spans, --span-start) and print the slowest and incomplete ones")
+	spansFormat  = spansCommand.Flag("format", "table, json or records (a JSON line per span)").Default("table").Enum("table", "json", "records")
+	spansTop     = spansCommand.Flag("top", "The number of slowest and incomplete spans to print (0 for all)").Default("10").Int()
+)
+
+func runSpans(rc *runContext) error {
+	format := map[string]kibini.SpansFormat{
+		"table":   kibini.SpansFormatTable,
+		"json":    kibini.SpansFormatJSON,
+		"records": kibini.SpansFormatRecords,
+	}[*spansFormat]
+
+	if rc.spanConfig == nil {
+		return errors.New("No span rules given (see --spans, --span-start and --span-end)")
+	}
+
+	if err := rc.kibini.Spans(&kibini.SpansOptions{
+		InputOptions: rc.getInputOptions(""),
+		Config:       rc.spanConfig,
+		Format:       format,
+		Top:          *spansTop,
+	}); err != nil {
+		return errors.Wrap(err, "Failed to get spans")
+	}
+
+	return nil
+}
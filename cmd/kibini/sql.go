@@ -0,0 +1,58 @@
+package main
+
+import (
+	"os"
+
+	"github.com/v3io/kibini/pkg/kibini"
+
+	"github.com/nuclio/errors"
+)
+
+var (
+	sqlCommand      = app.Command("sql", "Load the log files into sqlite (table 'records') and query them")
+	sqlQuery        = sqlCommand.Arg("query", "The SQL query to run. If omitted, an interactive prompt is started").String()
+	sqlREPL         = sqlCommand.Flag("repl", "Start an interactive prompt (after running the query, if given)").Bool()
+	sqlDatabasePath = sqlCommand.Flag("database", "Load into this sqlite file rather than into memory").String()
+	sqlResultFormat = sqlCommand.Flag("result-format", "table, csv or json").Default("table").Enum("table", "csv", "json")
+)
+
+func getSQLResultFormat(resultFormatString string) kibini.SQLResultFormat {
+	return map[string]kibini.SQLResultFormat{
+		"table": kibini.SQLResultFormatTable,
+		"csv":   kibini.SQLResultFormatCSV,
+		"json":  kibini.SQLResultFormatJSON,
+	}[resultFormatString]
+}
+
+func runSQL(rc *runContext) error {
+	inputOptions := rc.getInputOptions("")
+
+	// queries run on a snapshot of the logs
+	inputOptions.InputFollow = false
+
+	db, err := rc.kibini.LoadSQLDatabase(&inputOptions, *sqlDatabasePath)
+	if err != nil {
+		return errors.Wrap(err, "Failed to load logs")
+	}
+
+	defer db.Close() // nolint: errcheck
+
+	resultFormat := getSQLResultFormat(*sqlResultFormat)
+
+	if len(*sqlQuery) != 0 {
+		result, err := kibini.QuerySQL(db, *sqlQuery)
+		if err != nil {
+			return errors.Wrap(err, "Failed to query")
+		}
+
+		if err := kibini.WriteSQLResult(os.Stdout, result, resultFormat); err != nil {
+			return errors.Wrap(err, "Failed to write result")
+		}
+	}
+
+	if len(*sqlQuery) == 0 || *sqlREPL {
+		return kibini.NewSQLREPL(db, os.Stdin, os.Stdout, resultFormat).Run()
+	}
+
+	return nil
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"github.com/v3io/kibini/pkg/kibini"
+
+	"github.com/nuclio/errors"
+)
+
+var (
+	statsCommand = app.Command("stats", "Print statistics of each log file and each who in it")
+	statsFormat  = statsCommand.Flag("format", "table or json").Default("table").Enum("table", "json")
+	statsTop     = statsCommand.Flag("top", "The number of most frequent 'what' messages to print per file and who (0 for all)").Default("5").Int()
+)
+
+func runStats(rc *runContext) error {
+	format := kibini.StatsFormatTable
+	if *statsFormat == "json" {
+		format = kibini.StatsFormatJSON
+	}
+
+	if err := rc.kibini.Stats(&kibini.StatsOptions{
+		InputOptions: rc.getInputOptions(""),
+		Format:       format,
+		Top:          *statsTop,
+	}); err != nil {
+		return errors.Wrap(err, "Failed to get stats")
+	}
+
+	return nil
+}
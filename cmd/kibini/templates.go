@@ -0,0 +1,32 @@
+package main
+
+import (
+	"github.com/v3io/kibini/pkg/kibini"
+
+	"github.com/nuclio/errors"
+)
+
+var (
+	templatesCommand  = app.Command("templates", "Group records into message templates (parameters replaced by <*>) and print their counts")
+	templatesFormat   = templatesCommand.Flag("format", "table or json").Default("table").Enum("table", "json")
+	templatesTop      = templatesCommand.Flag("top", "The number of templates to print, the most frequent first (0 for all)").Default("20").Int()
+	templatesExamples = templatesCommand.Flag("examples", "The number of example records to print per template").Default("2").Int()
+)
+
+func runTemplates(rc *runContext) error {
+	format := kibini.TemplatesFormatTable
+	if *templatesFormat == "json" {
+		format = kibini.TemplatesFormatJSON
+	}
+
+	if err := rc.kibini.Templates(&kibini.TemplatesOptions{
+		InputOptions: rc.getInputOptions(""),
+		Format:       format,
+		Top:          *templatesTop,
+		Examples:     *templatesExamples,
+	}); err != nil {
+		return errors.Wrap(err, "Failed to get templates")
+	}
+
+	return nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"github.com/v3io/kibini/pkg/kibini"
+
+	"github.com/nuclio/errors"
+)
+
+var (
+	traceCommand   = app.Command("trace", "Print the records of a ctx or RequestID across all files, or list the IDs with the most errors")
+	traceID        = traceCommand.Arg("id", "The ctx or RequestID to trace (omit to list IDs with the most errors)").String()
+	traceLayout    = traceCommand.Flag("layout", "Trace layout: timeline (indented by service) or swimlane (a column per service)").Default("timeline").Enum("timeline", "swimlane")
+	traceLaneWidth = traceCommand.Flag("lane-width", "The width of each service's column in the swimlane layout").Default("40").Int()
+	traceTop       = traceCommand.Flag("top", "The number of IDs to list when no ID is given (0 for all)").Default("10").Int()
+)
+
+func runTrace(rc *runContext) error {
+	layout := kibini.TraceLayoutTimeline
+	if *traceLayout == "swimlane" {
+		layout = kibini.TraceLayoutSwimlanes
+	}
+
+	if err := rc.kibini.Trace(&kibini.TraceOptions{
+		InputOptions: rc.getInputOptions(""),
+		ID:           *traceID,
+		Layout:       layout,
+		ColorSetting: *appColorSetting,
+		WhoWidth:     *appWhoWidth,
+		LaneWidth:    *traceLaneWidth,
+		Top:          *traceTop,
+	}); err != nil {
+		return errors.Wrap(err, "Failed to trace")
+	}
+
+	return nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"github.com/v3io/kibini/pkg/kibini"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/nuclio/errors"
+)
+
+var (
+	tuiCommand = app.Command("tui", "Browse the log files in an interactive terminal viewer (follows with -f)")
+)
+
+func runTUI(rc *runContext) error {
+	inputOptions := rc.getInputOptions("")
+
+	screen, err := tcell.NewScreen()
+	if err != nil {
+		return errors.Wrap(err, "Failed to create screen")
+	}
+
+	logViewer := kibini.NewLogViewer(rc.kibini.GetLogger(), screen, *appWhoWidth, inputOptions.InputFollow)
+
+	// read the logs into the viewer while it runs
+	go func() {
+		if err := rc.kibini.ReadLogs(&inputOptions, []kibini.LogWriter{logViewer}); err != nil {
+			logViewer.SetStatusMessage("Failed to read logs: " + errors.Cause(err).Error())
+			return
+		}
+
+		if !inputOptions.InputFollow {
+			logViewer.SetStatusMessage("Done reading, ? for help")
+		}
+	}()
+
+	return logViewer.Run()
+}
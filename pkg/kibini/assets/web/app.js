@@ -0,0 +1,178 @@
+(function () {
+  var pageSize = 1000;
+  var recordsBody = document.getElementById("records");
+  var whoSelect = document.getElementById("who");
+  var severities = document.getElementById("severities");
+  var search = document.getElementById("search");
+  var follow = document.getElementById("follow");
+  var count = document.getElementById("count");
+  var moreButton = document.getElementById("more");
+  var loaded = 0, total = 0, events = null, searchTimer = null;
+  var knownWhos = {}, knownSeverities = {};
+
+  // returns the query of the selected filters, or null if they can't match anything
+  function getFilterQuery() {
+    var params = new URLSearchParams();
+    var selectedWhos = Array.prototype.map.call(whoSelect.selectedOptions, function (option) { return option.value; });
+    var selectedSeverities = Array.prototype.map.call(severities.querySelectorAll("input:checked"),
+      function (checkbox) { return checkbox.value; });
+
+    // the server treats an empty list as no filter, so unselecting everything is handled here
+    if ((whoSelect.options.length !== 0 && selectedWhos.length === 0) ||
+      (severities.querySelectorAll("input").length !== 0 && selectedSeverities.length === 0)) {
+      return null;
+    }
+
+    // everything selected means no filter
+    if (selectedWhos.length !== whoSelect.options.length) { params.set("who", selectedWhos.join(",")); }
+    if (selectedSeverities.length !== severities.querySelectorAll("input").length) {
+      params.set("severity", selectedSeverities.join(","));
+    }
+    if (search.value !== "") { params.set("q", search.value); }
+
+    return params;
+  }
+
+  function createRow(record) {
+    var row = document.createElement("tr");
+    row.className = "sev-" + (record.severity || "?").charAt(0).toUpperCase();
+    row.id = record.source + ":" + record.line;
+
+    [["when", record.when], ["who", record.who], ["severity", record.severity]].forEach(function (cell) {
+      var td = document.createElement("td");
+      td.className = cell[0];
+      td.textContent = cell[1];
+      row.appendChild(td);
+    });
+
+    var what = document.createElement("td");
+    what.className = "what";
+    what.textContent = record.what;
+    if (record.ctx) {
+      var ctx = document.createElement("span");
+      ctx.className = "ctx";
+      ctx.textContent = " ctx: " + record.ctx;
+      what.appendChild(ctx);
+    }
+
+    // clicking the message expands/collapses more
+    what.addEventListener("click", function () {
+      var pre = what.querySelector("pre.more");
+      if (pre) { what.removeChild(pre); return; }
+      pre = document.createElement("pre");
+      pre.className = "more";
+      pre.textContent = JSON.stringify(record.more, null, 4);
+      what.appendChild(pre);
+    });
+
+    row.appendChild(what);
+    return row;
+  }
+
+  function updateCount() {
+    count.textContent = loaded + " / " + total + " records";
+    moreButton.style.display = loaded < total ? "" : "none";
+  }
+
+  function loadRecords(reset) {
+    if (reset) {
+      recordsBody.innerHTML = "";
+      loaded = 0;
+    }
+
+    var params = getFilterQuery();
+    if (!params) {
+      total = 0;
+      updateCount();
+      return Promise.resolve();
+    }
+
+    params.set("offset", loaded);
+    params.set("limit", pageSize);
+
+    return fetch("api/v1/records?" + params).then(function (response) { return response.json(); }).then(function (page) {
+      var fragment = document.createDocumentFragment();
+      page.records.forEach(function (record) { fragment.appendChild(createRow(record)); });
+      recordsBody.appendChild(fragment);
+      loaded += page.records.length;
+      total = page.total;
+      updateCount();
+    });
+  }
+
+  function getValues(field) {
+    return fetch("api/v1/counts?by=" + field).then(function (response) { return response.json(); }).then(function (result) {
+      return result.counts.map(function (count) { return count[field]; });
+    });
+  }
+
+  // adds whos and severities which weren't seen yet to the filters (logs may still be loading)
+  function loadFacets() {
+    return Promise.all([getValues("who"), getValues("severity")]).then(function (values) {
+      values[0].forEach(function (who) {
+        if (knownWhos[who]) { return; }
+        knownWhos[who] = true;
+
+        var option = document.createElement("option");
+        option.value = option.textContent = who;
+        option.selected = true;
+        whoSelect.appendChild(option);
+      });
+
+      values[1].forEach(function (severity) {
+        if (knownSeverities[severity]) { return; }
+        knownSeverities[severity] = true;
+
+        var label = document.createElement("label");
+        var checkbox = document.createElement("input");
+        checkbox.type = "checkbox";
+        checkbox.checked = true;
+        checkbox.value = severity;
+        checkbox.addEventListener("change", refresh);
+        label.appendChild(checkbox);
+        label.appendChild(document.createTextNode(severity));
+        severities.appendChild(label);
+      });
+    });
+  }
+
+  // when following, load everything up to now and then stream new records
+  function updateFollow() {
+    if (events) {
+      events.close();
+      events = null;
+    }
+
+    if (!follow.checked || !getFilterQuery()) { return; }
+
+    var loadAll = function () {
+      return loaded < total ? loadRecords(false).then(loadAll) : Promise.resolve();
+    };
+
+    loadAll().then(function () {
+      window.scrollTo(0, document.body.scrollHeight);
+      events = new EventSource("api/v1/events?" + getFilterQuery());
+      events.onmessage = function (message) {
+        recordsBody.appendChild(createRow(JSON.parse(message.data)));
+        loaded++;
+        total++;
+        updateCount();
+        window.scrollTo(0, document.body.scrollHeight);
+      };
+    });
+  }
+
+  function refresh() {
+    loadFacets().then(function () { return loadRecords(true); }).then(updateFollow);
+  }
+
+  whoSelect.addEventListener("change", refresh);
+  follow.addEventListener("change", updateFollow);
+  moreButton.addEventListener("click", function () { loadRecords(false); });
+  search.addEventListener("input", function () {
+    clearTimeout(searchTimer);
+    searchTimer = setTimeout(refresh, 300);
+  });
+
+  refresh();
+})();
@@ -1,7 +1,11 @@
-package core
+package kibini
 
 import (
+	"bytes"
+	"fmt"
 	"io"
+	"net"
+	"net/http"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -11,6 +15,7 @@ import (
 	"github.com/andrew-d/go-termutil"
 	"github.com/nuclio/errors"
 	"github.com/nuclio/logger"
+	"gopkg.in/yaml.v3"
 )
 
 // whether to include matched services or exclude them
@@ -22,6 +27,7 @@ const (
 	serviceFilterExclude
 )
 
+// OutputMode determines whether log records are merged into a single output or formatted per input file
 type OutputMode int
 
 const (
@@ -29,74 +35,341 @@ const (
 	OutputModePer
 )
 
+// OutputFormat determines how log records are formatted
+type OutputFormat int
+
+const (
+	OutputFormatText OutputFormat = iota
+	OutputFormatJSON
+	OutputFormatCSV
+	OutputFormatTSV
+	OutputFormatHTML
+	OutputFormatSQLite
+)
+
+// outputFormatsByName are the output formats by the names they're given on the command line (and in sinks)
+var outputFormatsByName = map[string]OutputFormat{
+	"text":   OutputFormatText,
+	"json":   OutputFormatJSON,
+	"csv":    OutputFormatCSV,
+	"tsv":    OutputFormatTSV,
+	"html":   OutputFormatHTML,
+	"sqlite": OutputFormatSQLite,
+}
+
+// ParseOutputFormat returns the output format by name (text, json, csv, tsv, html or sqlite)
+func ParseOutputFormat(name string) (OutputFormat, error) {
+	outputFormat, found := outputFormatsByName[name]
+	if !found {
+		return OutputFormatText, errors.New(fmt.Sprintf("Unknown output format: %s (expected text, json, csv, tsv, html or sqlite)", name))
+	}
+
+	return outputFormat, nil
+}
+
+// getOutputFormatName returns the name of the output format, as given to ParseOutputFormat
+func getOutputFormatName(outputFormat OutputFormat) string {
+	for name, namedOutputFormat := range outputFormatsByName {
+		if namedOutputFormat == outputFormat {
+			return name
+		}
+	}
+
+	return "unknown"
+}
+
+// InputOptions determine which log files are read and how
+type InputOptions struct {
+
+	// InputPath is the directory in which the log files reside
+	InputPath string
+
+	// InputFollow tails the log files (like tail -f)
+	InputFollow bool
+
+	// Regex processes only log files that match the given regex
+	Regex string
+
+	// NoRegex processes all log files except those that match the given regex
+	NoRegex string
+
+	// SingleFile processes only the given file name (relative to InputPath). Empty means all files
+	SingleFile string
+
+	// Filter is a filter expression (see ParseLogFilter). Only matching records are read. Empty means all
+	// records
+	Filter string
+
+	// TemplateIDs are IDs of message templates (see Kibini.MineTemplates). If not empty, only records fitting
+	// these templates are read. The templates are mined from the input first, without the filter
+	TemplateIDs []string
+
+	// TemplateOptions determine how message templates are mined
+	TemplateOptions TemplateMinerOptions
+
+	// Extractor extracts fields from the messages of records into "more" as they're read, before they're
+	// filtered. Nil means no extraction
+	Extractor *LogExtractor
+
+	// Redactor redacts secrets and personal information from records once they're filtered, before they're
+	// written anywhere. Nil means no redaction
+	Redactor *LogRedactor
+}
+
+// ProcessLogsOptions holds everything ProcessLogs needs in order to read, format and write logs
+type ProcessLogsOptions struct {
+	InputOptions
+
+	// OutputPath is a directory in "per" mode and a file path in "single" mode
+	OutputPath string
+
+	// OutputMode is either OutputModeSingle (merge all logs) or OutputModePer (one formatted output per input)
+	OutputMode OutputMode
+
+	// OutputStdout outputs to stdout. Requires OutputModeSingle
+	OutputStdout bool
+
+	// ColorSetting is one of "on" (color when outputting to a tty), "off" or "always"
+	ColorSetting string
+
+	// WhoWidth is the truncate width of the "who" field
+	WhoWidth int
+
+	// OutputFormat is one of OutputFormatText (human readable), OutputFormatJSON (JSON lines),
+	// OutputFormatCSV, OutputFormatTSV, OutputFormatHTML (self contained report) or OutputFormatSQLite (a
+	// database with a "records" table)
+	OutputFormat OutputFormat
+
+	// Columns are the fields written by OutputFormatCSV/OutputFormatTSV (e.g. "who", "more.RequestID").
+	// Empty means DefaultCSVColumns
+	Columns []string
+
+	// FormatTemplate is a go text/template (or the name of one of FormatTemplatePresets) used to format
+	// records. Empty means the human readable format. Applies only to OutputFormatText
+	FormatTemplate string
+
+	// Dedup collapses runs of consecutive records which are alike into one (see LogDedupWriter)
+	Dedup DedupMode
+
+	// DedupMoreKeys are "more" keys whose values must be alike as well for records to collapse ("*" for all)
+	DedupMoreKeys []string
+
+	// Sinks are further outputs of OutputModeSingle, each with its own path, format, filter and color setting.
+	// They're written along with OutputPath and stdout (if given)
+	Sinks []*LogSinkOptions
+
+	// SpanConfig holds rules which pair records into spans. Records ending a span are annotated with its
+	// duration (see LogSpanWriter). Nil means no pairing
+	SpanConfig *SpanConfig
+
+	// MetricsListenAddress is the address on which metrics are served (at /metrics, in the Prometheus text
+	// format) while processing. Empty means no metrics
+	MetricsListenAddress string
+
+	// MetricsOptions determine which metrics are collected on top of the built in ones
+	MetricsOptions LogMetricsOptions
+
+	// AlertConfig holds rules evaluated against the records, whose alerts are written to stderr and trigger
+	// actions (see LogAlerter). Nil means no alerting
+	AlertConfig *AlertConfig
+}
+
+// Kibini reads, merges and formats log files
 type Kibini struct {
-	logger  logger.Logger
-	readers map[string]logReader
+	logger logger.Logger
 }
 
+// NewKibini creates a Kibini
 func NewKibini(logger logger.Logger) *Kibini {
 	return &Kibini{
 		logger.GetChild("kibini-logger"),
-		map[string]logReader{},
 	}
 }
 
-func (k *Kibini) ProcessLogs(inputPath string,
-	inputFollow bool,
-	outputPath string,
-	outputMode OutputMode,
-	outputStdout bool,
-	userRegex string,
-	userNoRegex string,
-	singleFile string,
-	colorSetting string,
-	whoWidth int) (err error) {
-	var inputFileNames []string
+// GetLogger returns the logger kibini logs to
+func (k *Kibini) GetLogger() logger.Logger {
+	return k.logger
+}
 
-	if singleFile != "\000" {
+// ProcessLogs reads the log files according to the given options and writes them formatted. If
+// options.InputFollow is set this only returns if reading stops
+func (k *Kibini) ProcessLogs(options *ProcessLogsOptions) error {
+	if len(options.Sinks) != 0 && options.OutputMode != OutputModeSingle {
+		return errors.New("Sinks require output mode single")
+	}
 
-		// if the user specified one file: verify existence
-		var fullSingleFilePath = filepath.Join(inputPath, singleFile)
-		if _, err = os.Stat(fullSingleFilePath); err == nil {
-			inputFileNames = append(inputFileNames, singleFile)
-		} else {
-			return errors.Wrap(err, "Given file not found in directory")
-		}
-	} else {
+	inputFileNames, err := k.getInputFileNames(&options.InputOptions)
+	if err != nil {
+		return errors.Wrap(err, "Failed to get input file names")
+	}
 
-		// else, get the log file names on which we shall work
-		inputFileNames, err = k.getSourceLogFileNames(inputPath, userRegex, userNoRegex)
-		if err != nil {
-			return errors.Wrap(err, "Failed to get filtered log file names")
-		}
+	logFilter, err := k.getLogFilter(&options.InputOptions)
+	if err != nil {
+		return errors.Wrap(err, "Failed to get log filter")
 	}
 
 	// create log writers - for each input file name, a list of writers will be provided
-	logWritersByLogFileName, writerWaitGroup, err := k.createLogWriters(inputPath,
-		inputFileNames,
-		inputFollow,
-		outputPath,
-		outputMode,
-		outputStdout,
-		colorSetting,
-		whoWidth)
+	logWritersByLogFileName, writerWaitGroup, err := k.createLogWriters(options, inputFileNames)
 	if err != nil {
 		return errors.Wrap(err, "Failed to create log writers")
 	}
 
-	// create a log processor
+	if len(options.MetricsListenAddress) != 0 {
+		stopServingMetrics, err := k.serveMetrics(options, inputFileNames, logWritersByLogFileName)
+		if err != nil {
+			return errors.Wrap(err, "Failed to serve metrics")
+		}
+
+		defer stopServingMetrics()
+	}
+
+	k.readLogFiles(&options.InputOptions, inputFileNames, logFilter, logWritersByLogFileName)
+
+	// wait for all writes to complete
+	writerWaitGroup.Wait()
+
+	// let the writers finalize their output
+	var logWriters []LogWriter
 	for _, inputFileName := range inputFileNames {
-		inputFilePath := filepath.Join(inputPath, inputFileName)
+		logWriters = append(logWriters, logWritersByLogFileName[inputFileName]...)
+	}
 
-		k.readers[inputFilePath] = newLogTailReader(k.logger,
-			inputFilePath,
-			logWritersByLogFileName[inputFileName])
+	if err := closeLogWriters(logWriters); err != nil {
+		return errors.Wrap(err, "Failed to close log writers")
+	}
+
+	return nil
+}
+
+// ReadLogs reads the log files according to the given options and writes their records, merged (sorted by
+// time), to the given writers. Returns once all records were written (if following, once reading stops).
+// The writers are not closed
+func (k *Kibini) ReadLogs(inputOptions *InputOptions, logWriters []LogWriter) error {
+	inputFileNames, err := k.getInputFileNames(inputOptions)
+	if err != nil {
+		return errors.Wrap(err, "Failed to get input file names")
+	}
+
+	logFilter, err := k.getLogFilter(inputOptions)
+	if err != nil {
+		return errors.Wrap(err, "Failed to get log filter")
+	}
+
+	writerWaitGroup := new(sync.WaitGroup)
+	logMerger := k.createLogMerger(writerWaitGroup, inputOptions.InputFollow, logWriters)
+
+	// all files are written to the merger
+	logWritersByLogFileName := map[string][]LogWriter{}
+	for _, inputFileName := range inputFileNames {
+		logWritersByLogFileName[inputFileName] = []LogWriter{logMerger}
+	}
+
+	k.readLogFiles(inputOptions, inputFileNames, logFilter, logWritersByLogFileName)
+
+	// wait for the merger to write everything
+	writerWaitGroup.Wait()
+
+	if err := logMerger.GetWriteErr(); err != nil {
+		return errors.Wrap(err, "Failed to write records")
+	}
+
+	return nil
+}
+
+// getInputFileNames returns the names of the log files to read, relative to the input path
+func (k *Kibini) getInputFileNames(inputOptions *InputOptions) ([]string, error) {
+	if inputOptions.SingleFile != "" {
+
+		// if the user specified one file: verify existence
+		var fullSingleFilePath = filepath.Join(inputOptions.InputPath, inputOptions.SingleFile)
+		if _, err := os.Stat(fullSingleFilePath); err != nil {
+			return nil, errors.Wrap(err, "Given file not found in directory")
+		}
+
+		return []string{inputOptions.SingleFile}, nil
+	}
+
+	// else, get the log file names on which we shall work
+	inputFileNames, err := k.getSourceLogFileNames(inputOptions.InputPath, inputOptions.Regex, inputOptions.NoRegex)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to get filtered log file names")
+	}
+
+	return inputFileNames, nil
+}
+
+// getLogFilter parses the filter of the input options, combined with its templates. Returns nil if there's no
+// filter
+func (k *Kibini) getLogFilter(inputOptions *InputOptions) (LogFilter, error) {
+	var logFilters logFilterAnd
+
+	if len(inputOptions.Filter) != 0 {
+		logFilter, err := ParseLogFilter(inputOptions.Filter)
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to parse filter")
+		}
+
+		logFilters = append(logFilters, logFilter)
+	}
+
+	if len(inputOptions.TemplateIDs) != 0 {
+		logTemplateFilter, err := k.getLogTemplateFilter(inputOptions)
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to get template filter")
+		}
+
+		logFilters = append(logFilters, logTemplateFilter)
+	}
+
+	switch len(logFilters) {
+	case 0:
+		return nil, nil
+	case 1:
+		return logFilters[0], nil
 	}
 
+	return logFilters, nil
+}
+
+// readLogFiles reads all the input files (each in its own go routine) into their writers and returns once
+// all the readers are done. Mergers among the writers are then stopped (see stopLogMergers)
+func (k *Kibini) readLogFiles(inputOptions *InputOptions,
+	inputFileNames []string,
+	logFilter LogFilter,
+	logWritersByLogFileName map[string][]LogWriter) {
 	var readerWaitGroup sync.WaitGroup
 
+	logMergers := getLogMergers(logWritersByLogFileName)
+
+	// let writers which watch mergers know about them
+	for _, logWriters := range logWritersByLogFileName {
+		for _, logWriter := range logWriters {
+			if watcher, isWatcher := logWriter.(logMergerWatcher); isWatcher {
+				for logMerger := range logMergers {
+					watcher.watchLogMerger(logMerger)
+				}
+			}
+		}
+	}
+
 	// tell all log readers to start reading
-	for inputFilePath, fileLogReader := range k.readers {
+	for _, inputFileName := range inputFileNames {
+		inputFilePath := filepath.Join(inputOptions.InputPath, inputFileName)
+
+		fileLogReader := NewLogTailReader(k.logger,
+			inputFilePath,
+			inputOptions.Extractor,
+			inputOptions.Redactor,
+			logFilter,
+			logWritersByLogFileName[inputFileName])
+
+		// let writers which watch readers know about this one
+		for _, logWriter := range logWritersByLogFileName[inputFileName] {
+			if watcher, isWatcher := logWriter.(logReaderWatcher); isWatcher {
+				watcher.watchLogReader(fileLogReader)
+			}
+		}
+
 		k.logger.DebugWith("Starting to read",
 			"inputFilePath", inputFilePath,
 			"logReader", fileLogReader)
@@ -104,21 +377,81 @@ func (k *Kibini) ProcessLogs(inputPath string,
 		readerWaitGroup.Add(1)
 
 		// do the read in a go routine which upon completion signals the wait group
-		go func(reader logReader) {
+		go func(reader LogReader) {
 
 			// tell the reader to read - if it tails it might never stop
-			reader.read(inputFollow) // nolint: errcheck
+			reader.Read(inputOptions.InputFollow) // nolint: errcheck
 
 			// this specific reader is done
 			readerWaitGroup.Done()
 		}(fileLogReader)
 	}
 
-	// wait for all reads and writes to complete
 	readerWaitGroup.Wait()
-	writerWaitGroup.Wait()
 
-	return nil
+	stopLogMergers(logMergers)
+}
+
+// getLogMergers returns the mergers among the writers
+func getLogMergers(logWritersByLogFileName map[string][]LogWriter) map[*LogMerger]bool {
+	logMergers := map[*LogMerger]bool{}
+
+	for _, logWriters := range logWritersByLogFileName {
+		for _, logWriter := range logWriters {
+			if logMerger, isLogMerger := logWriter.(*LogMerger); isLogMerger {
+				logMergers[logMerger] = true
+			}
+		}
+	}
+
+	return logMergers
+}
+
+// stopLogMergers stops mergers once nothing more will be written to them, so they flush and stop. Mergers used
+// to stop by themselves after a quiet period, which cut off the records of slow readers (e.g. ones filtering a
+// large file with --filter), so mergers created by createLogMerger must be stopped this way
+func stopLogMergers(logMergers map[*LogMerger]bool) {
+	for logMerger := range logMergers {
+		logMerger.Stop()
+	}
+}
+
+// serveMetrics serves metrics of the records read into the given writers, by adding a LogMetrics before them.
+// Returns a function which stops serving
+func (k *Kibini) serveMetrics(options *ProcessLogsOptions,
+	inputFileNames []string,
+	logWritersByLogFileName map[string][]LogWriter) (func(), error) {
+	logMetrics, err := NewLogMetrics(&options.MetricsOptions)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to create metrics")
+	}
+
+	// records are counted before they're handed on, since writers (e.g. formatters) may modify them
+	for _, inputFileName := range inputFileNames {
+		logWritersByLogFileName[inputFileName] = append([]LogWriter{logMetrics}, logWritersByLogFileName[inputFileName]...)
+	}
+
+	serveMux := http.NewServeMux()
+	serveMux.Handle("/metrics", logMetrics)
+
+	listener, err := net.Listen("tcp", options.MetricsListenAddress)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to listen")
+	}
+
+	server := &http.Server{Handler: serveMux}
+
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			k.logger.WarnWith("Failed to serve metrics", "err", err.Error())
+		}
+	}()
+
+	k.logger.InfoWith("Serving metrics", "address", listener.Addr().String())
+
+	return func() {
+		server.Close() // nolint: errcheck
+	}, nil
 }
 
 func (k *Kibini) getSourceLogFileNames(inputPath string,
@@ -221,87 +554,265 @@ func (k *Kibini) compileServiceFilter(userRegex string,
 	return
 }
 
-func (k *Kibini) createLogWriters(inputPath string,
-	inputFileNames []string,
-	inputFollow bool,
-	outputPath string,
-	outputMode OutputMode,
-	outputStdout bool,
-	colorSetting string,
-	whoWidth int) (map[string][]logWriter, *sync.WaitGroup, error) {
+func (k *Kibini) createLogWriters(options *ProcessLogsOptions,
+	inputFileNames []string) (map[string][]LogWriter, *sync.WaitGroup, error) {
 
 	writerWaitGroup := new(sync.WaitGroup)
-	logWriters := map[string][]logWriter{}
-	color := k.determineColorSetting(colorSetting, outputStdout)
+	logWriters := map[string][]LogWriter{}
+	color := k.determineColorSetting(options.ColorSetting, options.OutputStdout)
 
-	if outputMode == OutputModePer {
+	// alerts are evaluated along with the output - merged in "single" mode, and by a single (shared) alerter in
+	// "per" mode
+	var logAlerter *LogAlerter
+	if options.AlertConfig != nil {
+		var err error
+
+		if logAlerter, err = NewLogAlerter(k.logger, options.AlertConfig, os.Stderr); err != nil {
+			return nil, nil, errors.Wrap(err, "Failed to create alerter")
+		}
+	}
+
+	if options.OutputMode == OutputModePer {
 
 		// create a formatter/writer per file
 		for _, inputFileName := range inputFileNames {
-			outputFilePath := filepath.Join(outputPath, inputFileName+".fmt")
+			outputFilePath := filepath.Join(options.OutputPath, inputFileName+GetOutputFileExtension(options.OutputFormat))
 
-			outputFileWriter, err := k.createOutputFileWriter(outputFilePath)
+			// create a single formatter/writer for this input file
+			outputLogWriter, err := k.createOutputLogWriter(options, outputFilePath, color)
 			if err != nil {
-				return nil, nil, errors.Wrap(err, "Failed to create output file writer")
+				return nil, nil, errors.Wrap(err, "Failed to create output log writer")
 			}
 
-			// create a single formatter/writer for this input file
-			humanReadableFormatter := newHumanReadableFormatter(color, whoWidth)
-			logWriters[inputFileName] = []logWriter{
-				newLogFormattedWriter(k.logger, humanReadableFormatter, outputFileWriter),
+			logWriters[inputFileName] = []LogWriter{outputLogWriter}
+
+			if logAlerter != nil {
+				logWriters[inputFileName] = append(logWriters[inputFileName], logAlerter)
 			}
 		}
-	} else if outputMode == OutputModeSingle {
-		writers := []logWriter{}
+	} else if options.OutputMode == OutputModeSingle {
+		writers := []LogWriter{}
 
 		// create a formatter/writer which will receive the sorted log records from the merger
-		if len(outputPath) != 0 {
+		if len(options.OutputPath) != 0 {
 
 			// create an output file writer
-			outputFileWriter, err := k.createOutputFileWriter(outputPath)
+			outputLogWriter, err := k.createOutputLogWriter(options, options.OutputPath, color)
 			if err != nil {
-				return nil, nil, errors.Wrap(err, "Failed to create output file writer")
+				return nil, nil, errors.Wrap(err, "Failed to create output log writer")
 			}
 
-			fileWriter := newLogFormattedWriter(k.logger,
-				newHumanReadableFormatter(color, whoWidth),
-				outputFileWriter)
-
-			writers = append(writers, fileWriter)
+			writers = append(writers, outputLogWriter)
 		}
 
 		// if stdout is requested, create a writer for it
-		if outputStdout {
-			stdoutWriter := newLogFormattedWriter(k.logger,
-				newHumanReadableFormatter(color, whoWidth),
-				os.Stdout)
+		if options.OutputStdout {
+			stdoutLogWriter, err := k.createStdoutLogWriter(options, color)
+			if err != nil {
+				return nil, nil, errors.Wrap(err, "Failed to create stdout writer")
+			}
 
-			writers = append(writers, stdoutWriter)
+			writers = append(writers, stdoutLogWriter)
 		}
 
-		// get timeouts for merger
-		inactivityFlushTimeout, forceFlushTimeout := k.getMergerTimeouts(inputFollow)
+		// each sink formats the same records, so formatters mustn't modify them
+		for _, sink := range options.Sinks {
+			sinkLogWriter, err := k.createSinkLogWriter(options, sink)
+			if err != nil {
+				return nil, nil, errors.Wrapf(err, "Failed to create writer of sink %s", sink.Path)
+			}
+
+			writers = append(writers, sinkLogWriter)
+		}
+
+		if logAlerter != nil {
+			writers = append(writers, logAlerter)
+		}
 
 		// create a log merger writer that will receive all records, merge them (sorted) and then output
 		// them to log writer
-		logMerger := newLogMerger(k.logger,
-			writerWaitGroup,
-			!inputFollow, // if not following, stop after first flush
-			!inputFollow, // if not following, stop after the first quiet period
-			inactivityFlushTimeout,
-			forceFlushTimeout,
-			writers)
+		logMerger := k.createLogMerger(writerWaitGroup, options.InputFollow, writers)
 
 		// set the log merger as the writer for all input files
 		for _, inputFileName := range inputFileNames {
-			logWriters[inputFileName] = []logWriter{logMerger}
+			logWriters[inputFileName] = []LogWriter{logMerger}
 		}
 	}
 
 	return logWriters, writerWaitGroup, nil
 }
 
-func (k *Kibini) createOutputFileWriter(outputFilePath string) (io.Writer, error) {
+// GetOutputFileExtension returns the extension of formatted files for the given output format
+func GetOutputFileExtension(outputFormat OutputFormat) string {
+	switch outputFormat {
+	case OutputFormatJSON:
+		return ".jsonl"
+	case OutputFormatCSV:
+		return ".csv"
+	case OutputFormatTSV:
+		return ".tsv"
+	case OutputFormatHTML:
+		return ".html"
+	case OutputFormatSQLite:
+		return ".sqlite"
+	default:
+		return ".fmt"
+	}
+}
+
+// createOutputLogWriter creates a writer which outputs to the given file, in the requested format
+func (k *Kibini) createOutputLogWriter(options *ProcessLogsOptions,
+	outputFilePath string,
+	color bool) (LogWriter, error) {
+
+	// sqlite is not a formatted output, the writer manages the file itself
+	if options.OutputFormat == OutputFormatSQLite {
+		logSQLiteWriter, err := NewLogSQLiteWriter(k.logger, outputFilePath, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		return k.wrapOutputLogWriter(options, logSQLiteWriter)
+	}
+
+	logFormatter, err := k.createLogFormatter(options, color)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to create log formatter")
+	}
+
+	outputFileWriter, err := k.createOutputFileWriter(outputFilePath)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to create output file writer")
+	}
+
+	// the formatted writer closes the file once it's closed
+	logFormattedWriter := NewLogFormattedWriter(k.logger, logFormatter, outputFileWriter)
+
+	logWriter, err := k.wrapOutputLogWriter(options, logFormattedWriter)
+	if err != nil {
+		outputFileWriter.Close() // nolint: errcheck
+		return nil, err
+	}
+
+	return logWriter, nil
+}
+
+// createStdoutLogWriter creates a writer which outputs to stdout, in the requested format
+func (k *Kibini) createStdoutLogWriter(options *ProcessLogsOptions, color bool) (LogWriter, error) {
+	if options.OutputFormat == OutputFormatSQLite {
+		return nil, errors.New("sqlite output requires an output path, not stdout")
+	}
+
+	logFormatter, err := k.createLogFormatter(options, color)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to create log formatter")
+	}
+
+	return k.wrapOutputLogWriter(options, NewLogFormattedWriter(k.logger, logFormatter, os.Stdout))
+}
+
+// createSinkLogWriter creates a writer which outputs to the sink, with the sink's settings overriding those of
+// the options
+func (k *Kibini) createSinkLogWriter(options *ProcessLogsOptions, sink *LogSinkOptions) (LogWriter, error) {
+	sinkOptions := *options
+	sinkOptions.OutputFormat = sink.OutputFormat
+
+	if len(sink.ColorSetting) != 0 {
+		sinkOptions.ColorSetting = sink.ColorSetting
+	}
+
+	if len(sink.Columns) != 0 {
+		sinkOptions.Columns = sink.Columns
+	}
+
+	// the template of the options applies only to sinks of the text format
+	if len(sink.FormatTemplate) != 0 {
+		sinkOptions.FormatTemplate = sink.FormatTemplate
+	} else if sink.OutputFormat != OutputFormatText {
+		sinkOptions.FormatTemplate = ""
+	}
+
+	var logWriter LogWriter
+	var err error
+
+	if sink.Path == LogSinkStdout {
+		logWriter, err = k.createStdoutLogWriter(&sinkOptions, k.determineColorSetting(sinkOptions.ColorSetting, true))
+	} else {
+		logWriter, err = k.createOutputLogWriter(&sinkOptions, sink.Path, k.determineColorSetting(sinkOptions.ColorSetting, false))
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sink.Filter) == 0 {
+		return logWriter, nil
+	}
+
+	logFilter, err := ParseLogFilter(sink.Filter)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to parse filter")
+	}
+
+	return &logFilterWriter{
+		logFilter: logFilter,
+		logWriter: logWriter,
+	}, nil
+}
+
+// wrapOutputLogWriter wraps the writer with one which collapses records and one which annotates the ends of
+// spans, if requested. Records arrive merged (in "single" mode) or per file, so runs are of records which are
+// consecutive in the output and spans are paired within the output
+func (k *Kibini) wrapOutputLogWriter(options *ProcessLogsOptions, logWriter LogWriter) (LogWriter, error) {
+	if options.Dedup != DedupNone {
+		var quietPeriod time.Duration
+		if options.InputFollow {
+			quietPeriod = dedupFollowQuietPeriod
+		}
+
+		logWriter = NewLogDedupWriter(options.Dedup, options.DedupMoreKeys, quietPeriod, logWriter)
+	}
+
+	if options.SpanConfig != nil && len(options.SpanConfig.Rules) != 0 {
+		logSpanWriter, err := NewLogSpanWriter(options.SpanConfig, logWriter)
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to create span writer")
+		}
+
+		logWriter = logSpanWriter
+	}
+
+	return logWriter, nil
+}
+
+func (k *Kibini) createLogFormatter(options *ProcessLogsOptions, color bool) (LogFormatter, error) {
+
+	// templates only apply to the text format
+	if len(options.FormatTemplate) != 0 && options.OutputFormat != OutputFormatText {
+		return nil, errors.New(fmt.Sprintf("A format template applies only to the text output format, not to %s",
+			getOutputFormatName(options.OutputFormat)))
+	}
+
+	switch options.OutputFormat {
+	case OutputFormatJSON:
+		return NewJSONFormatter(), nil
+	case OutputFormatCSV:
+		return NewCSVFormatter(options.Columns, ',')
+	case OutputFormatTSV:
+		return NewCSVFormatter(options.Columns, '\t')
+	case OutputFormatHTML:
+		return NewHTMLFormatter(), nil
+	}
+
+	// if a template was given, use it
+	if len(options.FormatTemplate) != 0 {
+		return NewTemplateFormatter(options.FormatTemplate, color, options.WhoWidth)
+	}
+
+	return NewHumanReadableFormatter(color, options.WhoWidth), nil
+}
+
+func (k *Kibini) createOutputFileWriter(outputFilePath string) (io.WriteCloser, error) {
 	var err error
 
 	// create output file
@@ -316,13 +827,32 @@ func (k *Kibini) createOutputFileWriter(outputFilePath string) (io.Writer, error
 	return outputFile, nil
 }
 
+func (k *Kibini) createLogMerger(writerWaitGroup *sync.WaitGroup,
+	inputFollow bool,
+	logWriters []LogWriter) *LogMerger {
+
+	// get timeouts for merger
+	inactivityFlushTimeout, forceFlushTimeout := k.getMergerTimeouts(inputFollow)
+
+	// rather than guessing that the readers are done after a quiet period, the merger is stopped explicitly by
+	// readLogFiles once they are (so a slow reader, e.g. one reading a large file with a filter, doesn't get
+	// its records cut off)
+	return NewLogMerger(k.logger,
+		writerWaitGroup,
+		false,
+		false,
+		inactivityFlushTimeout,
+		forceFlushTimeout,
+		logWriters)
+}
+
 func (k *Kibini) getMergerTimeouts(inputFollow bool) (time.Duration, time.Duration) {
 
 	if !inputFollow {
 
-		// if there's no follow, don't do any force flushing (it'll just waste cycles and may end up with a badly
-		// sorted output). After 1 second of inactivity, assume that all readers finished reading
-		return 1 * time.Second, 0
+		// if there's no follow, don't flush until the readers are done (flushing earlier may end up with a
+		// badly sorted output)
+		return 0, 0
 
 	}
 
@@ -343,3 +873,20 @@ func (k *Kibini) determineColorSetting(colorSetting string, stdout bool) (color
 	}
 	return
 }
+
+// loadYAMLFile decodes a YAML file into out. Unknown fields are an error, and an empty file leaves out as is
+func loadYAMLFile(path string, out interface{}) error {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return errors.Wrap(err, "Failed to read file")
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(contents))
+	decoder.KnownFields(true)
+
+	if err := decoder.Decode(out); err != nil && err != io.EOF {
+		return errors.Wrap(err, "Failed to parse file")
+	}
+
+	return nil
+}
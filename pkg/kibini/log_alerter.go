@@ -0,0 +1,559 @@
+package kibini
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/nuclio/errors"
+	"github.com/nuclio/logger"
+)
+
+// alert rule types
+const (
+	AlertRuleTypePattern = "pattern"
+	AlertRuleTypeRate    = "rate"
+	AlertRuleTypeAbsence = "absence"
+)
+
+// alert action types
+const (
+	AlertActionTypeExec = "exec"
+	AlertActionTypeFile = "file"
+	AlertActionTypePost = "post"
+)
+
+const (
+	alertDefaultCooldown = time.Minute
+	alertActionTimeout   = 30 * time.Second
+	alertQueueSize       = 1024
+)
+
+// AlertConfig holds alert rules and the actions they trigger, as read from a YAML file (see LoadAlertConfig)
+type AlertConfig struct {
+	Rules   []AlertRuleConfig   `yaml:"rules"`
+	Actions []AlertActionConfig `yaml:"actions"`
+}
+
+// AlertRuleConfig is a rule which raises alerts. Records are matched by a filter expression (see ParseLogFilter)
+// and/or a regular expression (searched for in "what" and the serialized "more"). Types:
+//
+//	pattern: every matching record raises an alert
+//	rate:    Threshold matching records within Window (by record time) raise an alert
+//	absence: no matching record for Timeout (by wall clock) raises an alert
+//
+// Alerts of a rule are grouped by the value of the GroupBy field (e.g. who), if given. A group doesn't alert
+// again within Cooldown ("1m" by default), and rate and absence groups alert again only once they recovered
+type AlertRuleConfig struct {
+	Name      string   `yaml:"name"`
+	Type      string   `yaml:"type"`
+	Filter    string   `yaml:"filter"`
+	Pattern   string   `yaml:"pattern"`
+	GroupBy   string   `yaml:"groupBy"`
+	Threshold int      `yaml:"threshold"`
+	Window    string   `yaml:"window"`
+	Timeout   string   `yaml:"timeout"`
+	Cooldown  string   `yaml:"cooldown"`
+	Actions   []string `yaml:"actions"`
+}
+
+// AlertActionConfig is an action triggered by alerts. Types:
+//
+//	exec: runs Command, with the alert as JSON on its stdin (and KIBINI_ALERT_RULE, KIBINI_ALERT_MESSAGE set)
+//	file: appends the alert as a JSON line to Path
+//	post: POSTs the alert as JSON to URL, with Headers
+type AlertActionConfig struct {
+	Name    string            `yaml:"name"`
+	Type    string            `yaml:"type"`
+	Command []string          `yaml:"command"`
+	Path    string            `yaml:"path"`
+	URL     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers"`
+}
+
+// Alert is raised by a rule
+type Alert struct {
+	Rule       string               `json:"rule"`
+	Type       string               `json:"type"`
+	Group      string               `json:"group,omitempty"`
+	Message    string               `json:"message"`
+	Time       string               `json:"time"`
+	Suppressed int                  `json:"suppressed"`
+	Record     *NormalizedLogRecord `json:"record,omitempty"`
+}
+
+// LoadAlertConfig reads alert rules and actions from a YAML file
+func LoadAlertConfig(path string) (*AlertConfig, error) {
+	var alertConfig AlertConfig
+
+	if err := loadYAMLFile(path, &alertConfig); err != nil {
+		return nil, errors.Wrapf(err, "Failed to load alert config %s", path)
+	}
+
+	return &alertConfig, nil
+}
+
+// LogAlerter is a LogWriter which evaluates alert rules against the records written to it and triggers the
+// rules' actions. Alerts are also written to an io.Writer (e.g. stderr). It can be written to concurrently
+type LogAlerter struct {
+	logger      logger.Logger
+	lock        sync.Mutex
+	rules       []*logAlertRule
+	actions     map[string]*AlertActionConfig
+	alertWriter io.Writer
+	alerts      chan *logAlertDispatch
+	stop        chan struct{}
+	waitGroup   sync.WaitGroup
+	httpClient  *http.Client
+}
+
+type logAlertRule struct {
+	config    *AlertRuleConfig
+	filter    LogFilter
+	pattern   *regexp.Regexp
+	window    time.Duration
+	timeout   time.Duration
+	cooldown  time.Duration
+	actions   []*AlertActionConfig
+	groups    map[string]*logAlertGroup
+	startTime time.Time
+}
+
+// logAlertGroup is the state of a rule for a value of its GroupBy field
+type logAlertGroup struct {
+	lastAlertTime time.Time
+	suppressed    int
+	firing        bool
+	matchTimes    []time.Time
+	lastSeenTime  time.Time
+}
+
+type logAlertDispatch struct {
+	alert   *Alert
+	actions []*AlertActionConfig
+}
+
+// NewLogAlerter creates a LogAlerter, validating the config. Absence rules are evaluated from now on
+func NewLogAlerter(parentLogger logger.Logger, alertConfig *AlertConfig, alertWriter io.Writer) (*LogAlerter, error) {
+	logAlerter := LogAlerter{
+		logger:      parentLogger.GetChild("alerter"),
+		actions:     map[string]*AlertActionConfig{},
+		alertWriter: alertWriter,
+		alerts:      make(chan *logAlertDispatch, alertQueueSize),
+		stop:        make(chan struct{}),
+		httpClient:  &http.Client{Timeout: alertActionTimeout},
+	}
+
+	for actionIndex := range alertConfig.Actions {
+		action := &alertConfig.Actions[actionIndex]
+
+		if err := validateAlertAction(action); err != nil {
+			return nil, errors.Wrapf(err, "Invalid action %q", action.Name)
+		}
+
+		if _, found := logAlerter.actions[action.Name]; found {
+			return nil, errors.New(fmt.Sprintf("Duplicate action %q", action.Name))
+		}
+
+		logAlerter.actions[action.Name] = action
+	}
+
+	for ruleIndex := range alertConfig.Rules {
+		rule, err := logAlerter.createRule(&alertConfig.Rules[ruleIndex], alertConfig.Actions)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Invalid rule %q", alertConfig.Rules[ruleIndex].Name)
+		}
+
+		logAlerter.rules = append(logAlerter.rules, rule)
+	}
+
+	logAlerter.waitGroup.Add(2)
+	go logAlerter.dispatchAlerts()
+	go logAlerter.checkAbsences()
+
+	return &logAlerter, nil
+}
+
+func (la *LogAlerter) Write(logRecord *LogRecord) error {
+	la.lock.Lock()
+	defer la.lock.Unlock()
+
+	for _, rule := range la.rules {
+		if !rule.match(logRecord) {
+			continue
+		}
+
+		groupName := ""
+		if len(rule.config.GroupBy) != 0 {
+			value, _ := logRecord.GetField(rule.config.GroupBy)
+			groupName = flattenValue(value)
+		}
+
+		group := rule.getGroup(groupName)
+
+		switch rule.config.Type {
+		case AlertRuleTypePattern:
+			la.raise(rule, group, groupName, logRecord.When, fmt.Sprintf("%s: %s", logRecord.Who, logRecord.What), logRecord)
+
+		case AlertRuleTypeRate:
+			group.matchTimes = append(group.matchTimes, logRecord.When)
+
+			// forget matches which fell out of the window
+			windowStart := logRecord.When.Add(-rule.window)
+			for len(group.matchTimes) != 0 && !group.matchTimes[0].After(windowStart) {
+				group.matchTimes = group.matchTimes[1:]
+			}
+
+			if len(group.matchTimes) < rule.config.Threshold {
+				group.firing = false
+			} else if !group.firing {
+				group.firing = la.raise(rule, group, groupName, logRecord.When,
+					fmt.Sprintf("%d matching records within %s", len(group.matchTimes), rule.window),
+					logRecord)
+			}
+
+		case AlertRuleTypeAbsence:
+			group.lastSeenTime = time.Now()
+			group.firing = false
+		}
+	}
+
+	return nil
+}
+
+// Close stops evaluating absence rules and waits for pending alerts to be dispatched
+func (la *LogAlerter) Close() error {
+	close(la.stop)
+
+	la.lock.Lock()
+	close(la.alerts)
+	la.lock.Unlock()
+
+	la.waitGroup.Wait()
+
+	return nil
+}
+
+// raise raises an alert, unless the group is cooling down or the alerts pending dispatch fill the queue (e.g.
+// because actions are slow), in which case the alert is suppressed - blocking would block the writers while
+// holding the lock. Returns whether the alert was raised. Must be called with the lock held
+func (la *LogAlerter) raise(rule *logAlertRule,
+	group *logAlertGroup,
+	groupName string,
+	alertTime time.Time,
+	message string,
+	logRecord *LogRecord) bool {
+	now := time.Now()
+
+	if !group.lastAlertTime.IsZero() && now.Sub(group.lastAlertTime) < rule.cooldown {
+		group.suppressed++
+		return false
+	}
+
+	alert := Alert{
+		Rule:       rule.config.Name,
+		Type:       rule.config.Type,
+		Group:      groupName,
+		Message:    message,
+		Time:       alertTime.Format(time.RFC3339Nano),
+		Suppressed: group.suppressed,
+	}
+
+	if logRecord != nil {
+		alert.Record = logRecord.Normalize()
+	}
+
+	select {
+	case la.alerts <- &logAlertDispatch{alert: &alert, actions: rule.actions}:
+	default:
+		la.logger.WarnWith("Too many pending alerts, suppressing alert", "rule", rule.config.Name, "group", groupName)
+
+		group.suppressed++
+		return false
+	}
+
+	group.lastAlertTime = now
+	group.suppressed = 0
+
+	return true
+}
+
+// checkAbsences raises alerts for absence rules whose groups weren't seen for their timeout
+func (la *LogAlerter) checkAbsences() {
+	defer la.waitGroup.Done()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-la.stop:
+			return
+		case <-ticker.C:
+			la.lock.Lock()
+
+			for _, rule := range la.rules {
+				if rule.config.Type != AlertRuleTypeAbsence {
+					continue
+				}
+
+				// without grouping, the rule's single group is due from the start. otherwise groups are known once
+				// they were seen
+				if len(rule.config.GroupBy) == 0 {
+					rule.getGroup("")
+				}
+
+				for groupName, group := range rule.groups {
+					if group.firing || time.Since(group.lastSeenTime) < rule.timeout {
+						continue
+					}
+
+					group.firing = la.raise(rule, group, groupName, time.Now(),
+						fmt.Sprintf("No matching record for %s", time.Since(group.lastSeenTime).Round(time.Second)),
+						nil)
+				}
+			}
+
+			la.lock.Unlock()
+		}
+	}
+}
+
+// dispatchAlerts writes alerts and triggers their actions, in order
+func (la *LogAlerter) dispatchAlerts() {
+	defer la.waitGroup.Done()
+
+	for dispatch := range la.alerts {
+		alert := dispatch.alert
+
+		if la.alertWriter != nil {
+			suppressed := ""
+			if alert.Suppressed != 0 {
+				suppressed = fmt.Sprintf(" (%d suppressed)", alert.Suppressed)
+			}
+
+			group := ""
+			if len(alert.Group) != 0 {
+				group = fmt.Sprintf("[%s] ", alert.Group)
+			}
+
+			fmt.Fprintf(la.alertWriter, "ALERT %s: %s%s%s\n", alert.Rule, group, alert.Message, suppressed) // nolint: errcheck
+		}
+
+		marshalledAlert, err := json.Marshal(alert)
+		if err != nil {
+			la.logger.WarnWith("Failed to marshal alert", "rule", alert.Rule, "err", err.Error())
+			continue
+		}
+
+		for _, action := range dispatch.actions {
+			if err := la.runAction(action, alert, marshalledAlert); err != nil {
+				la.logger.WarnWith("Failed to run alert action",
+					"rule", alert.Rule,
+					"action", action.Name,
+					"err", err.Error())
+
+				if la.alertWriter != nil {
+					fmt.Fprintf(la.alertWriter, "ALERT %s: action %s failed: %s\n", alert.Rule, action.Name, errors.RootCause(err)) // nolint: errcheck
+				}
+			}
+		}
+	}
+}
+
+func (la *LogAlerter) runAction(action *AlertActionConfig, alert *Alert, marshalledAlert []byte) error {
+	switch action.Type {
+	case AlertActionTypeExec:
+		ctx, cancel := context.WithTimeout(context.Background(), alertActionTimeout)
+		defer cancel()
+
+		command := exec.CommandContext(ctx, action.Command[0], action.Command[1:]...)
+		command.Stdin = bytes.NewReader(marshalledAlert)
+		command.Env = append(os.Environ(),
+			"KIBINI_ALERT_RULE="+alert.Rule,
+			"KIBINI_ALERT_MESSAGE="+alert.Message)
+
+		if output, err := command.CombinedOutput(); err != nil {
+			return errors.Wrapf(err, "Command failed: %s", output)
+		}
+
+	case AlertActionTypeFile:
+		file, err := os.OpenFile(action.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return errors.Wrap(err, "Failed to open file")
+		}
+
+		defer file.Close() // nolint: errcheck
+
+		if _, err := file.Write(append(marshalledAlert, '\n')); err != nil {
+			return errors.Wrap(err, "Failed to write file")
+		}
+
+	case AlertActionTypePost:
+		request, err := http.NewRequest(http.MethodPost, action.URL, bytes.NewReader(marshalledAlert))
+		if err != nil {
+			return errors.Wrap(err, "Failed to create request")
+		}
+
+		request.Header.Set("Content-Type", "application/json")
+		for name, value := range action.Headers {
+			request.Header.Set(name, value)
+		}
+
+		response, err := la.httpClient.Do(request)
+		if err != nil {
+			return errors.Wrap(err, "Failed to post")
+		}
+
+		defer response.Body.Close() // nolint: errcheck
+
+		if response.StatusCode < 200 || response.StatusCode >= 300 {
+			return errors.New(fmt.Sprintf("Unexpected status: %s", response.Status))
+		}
+	}
+
+	return nil
+}
+
+func (la *LogAlerter) createRule(ruleConfig *AlertRuleConfig, actionConfigs []AlertActionConfig) (*logAlertRule, error) {
+	var err error
+
+	if len(ruleConfig.Name) == 0 {
+		return nil, errors.New("A rule must have a name")
+	}
+
+	rule := logAlertRule{
+		config:    ruleConfig,
+		cooldown:  alertDefaultCooldown,
+		groups:    map[string]*logAlertGroup{},
+		startTime: time.Now(),
+	}
+
+	if len(ruleConfig.Filter) == 0 && len(ruleConfig.Pattern) == 0 {
+		return nil, errors.New("A rule must have a filter and/or a pattern")
+	}
+
+	if len(ruleConfig.Filter) != 0 {
+		if rule.filter, err = ParseLogFilter(ruleConfig.Filter); err != nil {
+			return nil, errors.Wrap(err, "Failed to parse filter")
+		}
+	}
+
+	if len(ruleConfig.Pattern) != 0 {
+		if rule.pattern, err = regexp.Compile(ruleConfig.Pattern); err != nil {
+			return nil, errors.Wrap(err, "Failed to compile pattern")
+		}
+	}
+
+	if len(ruleConfig.GroupBy) != 0 && !IsValidFieldName(ruleConfig.GroupBy) {
+		return nil, errors.New(fmt.Sprintf("Unknown groupBy field: %s", ruleConfig.GroupBy))
+	}
+
+	durations := []struct {
+		name     string
+		value    string
+		duration *time.Duration
+		required bool
+	}{
+		{"cooldown", ruleConfig.Cooldown, &rule.cooldown, false},
+		{"window", ruleConfig.Window, &rule.window, ruleConfig.Type == AlertRuleTypeRate},
+		{"timeout", ruleConfig.Timeout, &rule.timeout, ruleConfig.Type == AlertRuleTypeAbsence},
+	}
+
+	for _, duration := range durations {
+		if len(duration.value) == 0 {
+			if duration.required {
+				return nil, errors.New(fmt.Sprintf("A %s rule must have a %s", ruleConfig.Type, duration.name))
+			}
+
+			continue
+		}
+
+		if *duration.duration, err = time.ParseDuration(duration.value); err != nil {
+			return nil, errors.Wrapf(err, "Failed to parse %s", duration.name)
+		}
+	}
+
+	switch ruleConfig.Type {
+	case AlertRuleTypePattern, AlertRuleTypeAbsence:
+	case AlertRuleTypeRate:
+		if ruleConfig.Threshold <= 0 {
+			return nil, errors.New("A rate rule must have a positive threshold")
+		}
+	default:
+		return nil, errors.New(fmt.Sprintf("Unknown rule type %q (expected pattern, rate or absence)", ruleConfig.Type))
+	}
+
+	// without explicit actions, a rule triggers all of them
+	if len(ruleConfig.Actions) == 0 {
+		for actionIndex := range actionConfigs {
+			rule.actions = append(rule.actions, &actionConfigs[actionIndex])
+		}
+	}
+
+	for _, actionName := range ruleConfig.Actions {
+		action, found := la.actions[actionName]
+		if !found {
+			return nil, errors.New(fmt.Sprintf("Unknown action %q", actionName))
+		}
+
+		rule.actions = append(rule.actions, action)
+	}
+
+	return &rule, nil
+}
+
+func validateAlertAction(action *AlertActionConfig) error {
+	if len(action.Name) == 0 {
+		return errors.New("An action must have a name")
+	}
+
+	switch action.Type {
+	case AlertActionTypeExec:
+		if len(action.Command) == 0 {
+			return errors.New("An exec action must have a command")
+		}
+	case AlertActionTypeFile:
+		if len(action.Path) == 0 {
+			return errors.New("A file action must have a path")
+		}
+	case AlertActionTypePost:
+		if len(action.URL) == 0 {
+			return errors.New("A post action must have a url")
+		}
+	default:
+		return errors.New(fmt.Sprintf("Unknown action type %q (expected exec, file or post)", action.Type))
+	}
+
+	return nil
+}
+
+func (lar *logAlertRule) match(logRecord *LogRecord) bool {
+	if lar.filter != nil && !lar.filter.Match(logRecord) {
+		return false
+	}
+
+	return lar.pattern == nil || matchRecordText(lar.pattern, logRecord)
+}
+
+// getGroup returns the state of a group, creating it if needed. A new group was last seen when the rule started
+func (lar *logAlertRule) getGroup(groupName string) *logAlertGroup {
+	group, found := lar.groups[groupName]
+	if !found {
+		group = &logAlertGroup{
+			lastSeenTime: lar.startTime,
+		}
+
+		lar.groups[groupName] = group
+	}
+
+	return group
+}
@@ -0,0 +1,254 @@
+package kibini
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// alertReceiver is an HTTP server which keeps the alerts posted to it. Posts block while it's held
+type alertReceiver struct {
+	t        *testing.T
+	server   *httptest.Server
+	lock     sync.Mutex
+	alerts   []*Alert
+	released chan struct{}
+}
+
+func newAlertReceiver(t *testing.T, held bool) *alertReceiver {
+	ar := &alertReceiver{
+		t:        t,
+		released: make(chan struct{}),
+	}
+
+	if !held {
+		close(ar.released)
+	}
+
+	ar.server = httptest.NewServer(http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+		<-ar.released
+
+		var alert Alert
+		if err := json.NewDecoder(request.Body).Decode(&alert); err != nil {
+			t.Errorf("Failed to decode alert: %s", err)
+			responseWriter.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		ar.lock.Lock()
+		ar.alerts = append(ar.alerts, &alert)
+		ar.lock.Unlock()
+	}))
+
+	t.Cleanup(ar.server.Close)
+
+	return ar
+}
+
+func (ar *alertReceiver) getAlerts() []*Alert {
+	ar.lock.Lock()
+	defer ar.lock.Unlock()
+
+	return append([]*Alert{}, ar.alerts...)
+}
+
+// waitForAlerts waits until count alerts were received and returns them
+func (ar *alertReceiver) waitForAlerts(count int) []*Alert {
+	ar.t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(5 * time.Millisecond) {
+		if alerts := ar.getAlerts(); len(alerts) >= count {
+			return alerts
+		}
+
+		if time.Now().After(deadline) {
+			ar.t.Fatalf("Timed out waiting for %d alerts, got %d", count, len(ar.getAlerts()))
+		}
+	}
+}
+
+func newTestLogAlerter(t *testing.T, alertReceiver *alertReceiver, rules ...AlertRuleConfig) *LogAlerter {
+	logAlerter, err := NewLogAlerter(newTestLogger(t), &AlertConfig{
+		Rules:   rules,
+		Actions: []AlertActionConfig{{Name: "receiver", Type: AlertActionTypePost, URL: alertReceiver.server.URL}},
+	}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create alerter: %s", err)
+	}
+
+	return logAlerter
+}
+
+func writeAlertTestRecord(t *testing.T, logAlerter *LogAlerter, second int, who string, severity string, what string) {
+	logRecord := NewLogRecord(fmt.Sprintf(`{"when":"2023-01-02T10:%02d:%02d.000","who":"%s","severity":"%s","what":"%s"}`,
+		second/60, second%60, who, severity, what))
+
+	if err := logAlerter.Write(logRecord); err != nil {
+		t.Fatalf("Failed to write: %s", err)
+	}
+}
+
+func TestLogAlerterPatternRule(t *testing.T) {
+	alertReceiver := newAlertReceiver(t, false)
+	logAlerter := newTestLogAlerter(t, alertReceiver, AlertRuleConfig{
+		Name:     "panics",
+		Type:     AlertRuleTypePattern,
+		Pattern:  "panic",
+		GroupBy:  "who",
+		Cooldown: "100ms",
+	})
+
+	// the second api panic is within the cooldown, the db panic is of another group
+	writeAlertTestRecord(t, logAlerter, 1, "api", "error", "panic: nil map")
+	writeAlertTestRecord(t, logAlerter, 2, "api", "error", "panic: nil map")
+	writeAlertTestRecord(t, logAlerter, 3, "api", "info", "recovered")
+	writeAlertTestRecord(t, logAlerter, 4, "db", "error", "panic: disk full")
+
+	alerts := alertReceiver.waitForAlerts(2)
+	if alerts[0].Group != "api" || alerts[0].Message != "api: panic: nil map" || alerts[1].Group != "db" {
+		t.Fatalf("Expected alerts of api and db, got %+v, %+v", alerts[0], alerts[1])
+	}
+
+	if alerts[0].Record == nil || alerts[0].Record.Who != "api" {
+		t.Fatalf("Expected the alert to hold its record, got %+v", alerts[0].Record)
+	}
+
+	// once the cooldown is over, the next alert counts the suppressed one
+	time.Sleep(150 * time.Millisecond)
+	writeAlertTestRecord(t, logAlerter, 5, "api", "error", "panic: again")
+
+	if alerts = alertReceiver.waitForAlerts(3); alerts[2].Suppressed != 1 {
+		t.Fatalf("Expected 1 suppressed alert, got %d", alerts[2].Suppressed)
+	}
+
+	if err := logAlerter.Close(); err != nil {
+		t.Fatalf("Failed to close: %s", err)
+	}
+
+	if alerts := alertReceiver.getAlerts(); len(alerts) != 3 {
+		t.Fatalf("Expected 3 alerts, got %d", len(alerts))
+	}
+}
+
+func TestLogAlerterRateRule(t *testing.T) {
+	alertReceiver := newAlertReceiver(t, false)
+	logAlerter := newTestLogAlerter(t, alertReceiver, AlertRuleConfig{
+		Name:      "errors",
+		Type:      AlertRuleTypeRate,
+		Filter:    "severity=error",
+		Threshold: 3,
+		Window:    "10s",
+		Cooldown:  "0s",
+	})
+
+	// the first two errors fall out of the window before the third arrives. the rule then fires once, and again
+	// only after recovering
+	for _, second := range []int{0, 5, 20, 21, 22, 23, 24, 60, 61, 62} {
+		writeAlertTestRecord(t, logAlerter, second, "api", "error", "failed")
+		writeAlertTestRecord(t, logAlerter, second, "api", "info", "ignored")
+	}
+
+	if err := logAlerter.Close(); err != nil {
+		t.Fatalf("Failed to close: %s", err)
+	}
+
+	alerts := alertReceiver.getAlerts()
+	if len(alerts) != 2 {
+		t.Fatalf("Expected 2 alerts, got %d", len(alerts))
+	}
+
+	if alerts[0].Time != "2023-01-02T10:00:22Z" || alerts[0].Message != "3 matching records within 10s" {
+		t.Fatalf("Expected the rate to be exceeded at 10:00:22, got %+v", alerts[0])
+	}
+
+	if alerts[1].Time != "2023-01-02T10:01:02Z" {
+		t.Fatalf("Expected the rate to be exceeded again at 10:01:02, got %+v", alerts[1])
+	}
+}
+
+func TestLogAlerterAbsenceRule(t *testing.T) {
+	alertReceiver := newAlertReceiver(t, false)
+	logAlerter := newTestLogAlerter(t, alertReceiver, AlertRuleConfig{
+		Name:     "heartbeat",
+		Type:     AlertRuleTypeAbsence,
+		Pattern:  "heartbeat",
+		Timeout:  "200ms",
+		Cooldown: "0s",
+	})
+
+	// absences are checked every second. the rule alerts once, until a heartbeat arrives
+	alerts := alertReceiver.waitForAlerts(1)
+	if alerts[0].Type != AlertRuleTypeAbsence || alerts[0].Record != nil {
+		t.Fatalf("Expected an absence alert, got %+v", alerts[0])
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+	if alerts := alertReceiver.getAlerts(); len(alerts) != 1 {
+		t.Fatalf("Expected the rule to alert once while firing, got %d alerts", len(alerts))
+	}
+
+	writeAlertTestRecord(t, logAlerter, 1, "api", "info", "heartbeat")
+	alertReceiver.waitForAlerts(2)
+
+	if err := logAlerter.Close(); err != nil {
+		t.Fatalf("Failed to close: %s", err)
+	}
+}
+
+func TestLogAlerterSuppressesAlertsWhenActionsFallBehind(t *testing.T) {
+	alertReceiver := newAlertReceiver(t, true)
+	logAlerter := newTestLogAlerter(t, alertReceiver, AlertRuleConfig{
+		Name:     "everything",
+		Type:     AlertRuleTypePattern,
+		Pattern:  ".",
+		Cooldown: "0s",
+	})
+
+	// the first alert is being posted while the queue fills up. writing must not block
+	extraAlerts := 10
+	writeDone := make(chan struct{})
+
+	go func() {
+		defer close(writeDone)
+
+		logRecord := NewLogRecord(`{"when":"2023-01-02T10:00:00.000","who":"api","severity":"info","what":"message"}`)
+
+		for recordIndex := 0; recordIndex < 1+alertQueueSize+extraAlerts; recordIndex++ {
+			if err := logAlerter.Write(logRecord); err != nil {
+				t.Errorf("Failed to write: %s", err)
+				return
+			}
+		}
+	}()
+
+	select {
+	case <-writeDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Writing blocked while actions were behind")
+	}
+
+	close(alertReceiver.released)
+
+	// the next alert counts the ones which were dropped. the first alert may not have been taken off the queue
+	// before it filled up, so one more may have been dropped
+	alertReceiver.waitForAlerts(alertQueueSize)
+	writeAlertTestRecord(t, logAlerter, 1, "api", "info", "message")
+
+	if err := logAlerter.Close(); err != nil {
+		t.Fatalf("Failed to close: %s", err)
+	}
+
+	alerts := alertReceiver.getAlerts()
+	lastAlert := alerts[len(alerts)-1]
+
+	if len(alerts)+lastAlert.Suppressed != 1+alertQueueSize+extraAlerts+1 ||
+		lastAlert.Suppressed < extraAlerts || lastAlert.Suppressed > extraAlerts+1 {
+		t.Fatalf("Expected the dropped alerts to be counted, got %d alerts, the last suppressing %d",
+			len(alerts),
+			lastAlert.Suppressed)
+	}
+}
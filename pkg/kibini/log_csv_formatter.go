@@ -0,0 +1,104 @@
+package kibini
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/nuclio/errors"
+)
+
+// DefaultCSVColumns are the columns of the CSV/TSV formatter if none are specified
+var DefaultCSVColumns = []string{"when", "source", "line", "who", "severity", "what", "ctx", "more"}
+
+// CSVFormatter formats log records as CSV (or TSV) rows with selectable columns
+type CSVFormatter struct {
+	columns   []string
+	separator rune
+}
+
+// NewCSVFormatter creates a CSVFormatter. columns are field names as accepted by LogRecord.GetField
+// (e.g. "who", "more.RequestID"). separator is ',' for CSV and '\t' for TSV
+func NewCSVFormatter(columns []string, separator rune) (*CSVFormatter, error) {
+	if len(columns) == 0 {
+		columns = DefaultCSVColumns
+	}
+
+	for _, column := range columns {
+		if !IsValidFieldName(column) {
+			return nil, errors.New(fmt.Sprintf("Unknown column: %s (expected a record field or more.<key>)", column))
+		}
+	}
+
+	return &CSVFormatter{
+		columns:   columns,
+		separator: separator,
+	}, nil
+}
+
+// FormatHeader returns the header row
+func (cf *CSVFormatter) FormatHeader() string {
+	return cf.formatRow(cf.columns)
+}
+
+// Format returns the row of the log record
+func (cf *CSVFormatter) Format(logRecord *LogRecord) string {
+	row := make([]string, len(cf.columns))
+
+	for columnIndex, column := range cf.columns {
+		if value, found := logRecord.GetField(column); found {
+			row[columnIndex] = flattenValue(value)
+		}
+	}
+
+	return cf.formatRow(row)
+}
+
+func (cf *CSVFormatter) formatRow(row []string) string {
+	var formattedRow bytes.Buffer
+
+	csvWriter := csv.NewWriter(&formattedRow)
+	csvWriter.Comma = cf.separator
+
+	if err := csvWriter.Write(row); err != nil {
+		return fmt.Sprintf("<Error formatting row: %s>\n", err)
+	}
+
+	csvWriter.Flush()
+
+	return formattedRow.String()
+}
+
+// flattenValue returns a cell for the value. nested objects and arrays are written as compact JSON
+func flattenValue(value interface{}) string {
+	switch typedValue := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return typedValue
+	case map[string]interface{}, []interface{}:
+		marshalledValue, err := json.Marshal(typedValue)
+		if err != nil {
+			return fmt.Sprintf("<Error formatting value: %s>", err)
+		}
+
+		return string(marshalledValue)
+	default:
+		return fmt.Sprint(typedValue)
+	}
+}
+
+// ParseColumns parses a comma separated list of columns
+func ParseColumns(columns string) []string {
+	var parsedColumns []string
+
+	for _, column := range strings.Split(columns, ",") {
+		if column = strings.TrimSpace(column); len(column) != 0 {
+			parsedColumns = append(parsedColumns, column)
+		}
+	}
+
+	return parsedColumns
+}
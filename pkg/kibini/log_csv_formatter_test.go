@@ -0,0 +1,83 @@
+package kibini
+
+import (
+	"testing"
+)
+
+func TestCSVFormatter(t *testing.T) {
+	logRecord := NewLogRecord(`{"when":"2023-01-02T10:00:01.500","who":"api","severity":"info",` +
+		`"what":"said \"hi\", then\nleft\tearly","ctx":"abc",` +
+		`"more":{"RequestID":"req-1","took":12,"request":{"path":"/a,b","ids":[1,2]},"empty":null}}`)
+	logRecord.SourceFile = "api.log"
+	logRecord.LineNumber = 7
+
+	for _, testCase := range []struct {
+		name           string
+		columns        []string
+		separator      rune
+		expectedHeader string
+		expectedRow    string
+	}{
+		{
+			name:           "default columns",
+			separator:      ',',
+			expectedHeader: "when,source,line,who,severity,what,ctx,more\n",
+			expectedRow: `2023-01-02T10:00:01.5Z,api.log,7,api,info,"said ""hi"", then` + "\n" + "left\tearly" + `",abc,` +
+				`"{""RequestID"":""req-1"",""empty"":null,""request"":{""ids"":[1,2],""path"":""/a,b""},""took"":12}"` + "\n",
+		},
+		{
+			name: "selected columns",
+			columns: []string{
+				"who",
+				"more.RequestID",
+				"more.took",
+				"more.request",
+				"more.request.path",
+				"more.missing",
+				"more.empty",
+			},
+			separator:      ',',
+			expectedHeader: "who,more.RequestID,more.took,more.request,more.request.path,more.missing,more.empty\n",
+			expectedRow:    `api,req-1,12,"{""ids"":[1,2],""path"":""/a,b""}","/a,b",,` + "\n",
+		},
+		{
+			name:           "tsv",
+			columns:        []string{"line", "what", "more.request.path"},
+			separator:      '\t',
+			expectedHeader: "line\twhat\tmore.request.path\n",
+			expectedRow:    "7\t" + `"said ""hi"", then` + "\n" + "left\tearly\"\t/a,b\n",
+		},
+	} {
+		csvFormatter, err := NewCSVFormatter(testCase.columns, testCase.separator)
+		if err != nil {
+			t.Fatalf("Failed to create formatter (%s): %s", testCase.name, err)
+		}
+
+		if header := csvFormatter.FormatHeader(); header != testCase.expectedHeader {
+			t.Errorf("Expected the header (%s) to be %q, got %q", testCase.name, testCase.expectedHeader, header)
+		}
+
+		if row := csvFormatter.Format(logRecord); row != testCase.expectedRow {
+			t.Errorf("Expected the row (%s) to be\n%q, got\n%q", testCase.name, testCase.expectedRow, row)
+		}
+	}
+
+	for _, invalidColumns := range [][]string{{"who", "bogus"}, {"Who"}} {
+		if _, err := NewCSVFormatter(invalidColumns, ','); err == nil {
+			t.Errorf("Expected columns %v to be invalid", invalidColumns)
+		}
+	}
+}
+
+func TestParseColumns(t *testing.T) {
+	if columns := ParseColumns(" when, more.RequestID ,,who "); len(columns) != 3 ||
+		columns[0] != "when" ||
+		columns[1] != "more.RequestID" ||
+		columns[2] != "who" {
+		t.Fatalf("Expected 3 trimmed columns, got %q", columns)
+	}
+
+	if columns := ParseColumns(""); len(columns) != 0 {
+		t.Fatalf("Expected no columns, got %q", columns)
+	}
+}
@@ -0,0 +1,256 @@
+package kibini
+
+import (
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nuclio/errors"
+)
+
+// DedupMode determines whether and how consecutive records are collapsed
+type DedupMode int
+
+const (
+	DedupNone DedupMode = iota
+
+	// DedupExact collapses records with the same who, severity, what (and "more" keys, if given)
+	DedupExact
+
+	// DedupSimilar is like DedupExact, but ignores numbers (e.g. "attempt 3" is like "attempt 4")
+	DedupSimilar
+)
+
+// the "more" keys which describe a collapsed run of records
+const (
+	dedupRepeatedKey      = "repeated"
+	dedupRepeatedForKey   = "repeatedFor"
+	dedupRepeatedUntilKey = "repeatedUntil"
+)
+
+// while following, a pending run is written once no record arrived for this long. it's longer than the merger's
+// inactivity flush timeout, so a run isn't cut just because the merger holds records back
+const dedupFollowQuietPeriod = 2 * time.Second
+
+var dedupNumberRegex = regexp.MustCompile(`\d+(\.\d+)?`)
+
+// LogDedupWriter collapses runs of consecutive records which are alike into their first record, to which it adds
+// the number of records in the run ("repeated"), the time span of the run ("repeatedFor") and the time of its
+// last record ("repeatedUntil") as "more" keys. A run is written once a record which isn't alike arrives, when
+// no record arrived for a quiet period (if given), or when closed
+type LogDedupWriter struct {
+	mode              DedupMode
+	moreKeys          []string
+	allMoreKeys       bool
+	logWriter         LogWriter
+	quietPeriod       time.Duration
+	lock              sync.Mutex
+	runRecord         *LogRecord
+	runKey            string
+	runLength         int
+	runLastWhen       time.Time
+	runLastReceivedAt time.Time
+	flushErr          error
+	stopFlushing      chan struct{}
+	flushingDone      chan struct{}
+}
+
+// NewLogDedupWriter creates a LogDedupWriter which writes to logWriter. Records are alike if they have the same
+// who, severity, what and values of the given "more" keys ("*" for all keys). If quietPeriod isn't 0 (e.g. when
+// following), a pending run is written once no record arrived for that long, so it isn't held back until a
+// different record arrives
+func NewLogDedupWriter(mode DedupMode, moreKeys []string, quietPeriod time.Duration, logWriter LogWriter) *LogDedupWriter {
+	logDedupWriter := LogDedupWriter{
+		mode:        mode,
+		logWriter:   logWriter,
+		quietPeriod: quietPeriod,
+	}
+
+	for _, moreKey := range moreKeys {
+		if moreKey == "*" {
+			logDedupWriter.allMoreKeys = true
+		} else {
+			logDedupWriter.moreKeys = append(logDedupWriter.moreKeys, moreKey)
+		}
+	}
+
+	if quietPeriod != 0 {
+		logDedupWriter.stopFlushing = make(chan struct{})
+		logDedupWriter.flushingDone = make(chan struct{})
+
+		go logDedupWriter.flushQuietRuns()
+	}
+
+	return &logDedupWriter
+}
+
+func (ldw *LogDedupWriter) Write(logRecord *LogRecord) error {
+	key := ldw.getKey(logRecord)
+
+	ldw.lock.Lock()
+	defer ldw.lock.Unlock()
+
+	if err := ldw.takeFlushErr(); err != nil {
+		return err
+	}
+
+	ldw.runLastReceivedAt = time.Now()
+
+	if ldw.runRecord != nil && key == ldw.runKey {
+		ldw.runLength++
+		ldw.runLastWhen = logRecord.When
+
+		return nil
+	}
+
+	if err := ldw.writeRun(); err != nil {
+		return err
+	}
+
+	ldw.runRecord = logRecord
+	ldw.runKey = key
+	ldw.runLength = 1
+	ldw.runLastWhen = logRecord.When
+
+	return nil
+}
+
+// Close writes the last run and closes the underlying writer
+func (ldw *LogDedupWriter) Close() error {
+	if ldw.stopFlushing != nil {
+		close(ldw.stopFlushing)
+		<-ldw.flushingDone
+	}
+
+	ldw.lock.Lock()
+	defer ldw.lock.Unlock()
+
+	if err := ldw.takeFlushErr(); err != nil {
+		return err
+	}
+
+	if err := ldw.writeRun(); err != nil {
+		return err
+	}
+
+	return closeLogWriters([]LogWriter{ldw.logWriter})
+}
+
+// flushQuietRuns writes the pending run once no record arrived for the quiet period
+func (ldw *LogDedupWriter) flushQuietRuns() {
+	ticker := time.NewTicker(ldw.quietPeriod / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			ldw.lock.Lock()
+			if ldw.runRecord != nil && time.Since(ldw.runLastReceivedAt) >= ldw.quietPeriod {
+				if err := ldw.writeRun(); err != nil && ldw.flushErr == nil {
+					ldw.flushErr = err
+				}
+			}
+			ldw.lock.Unlock()
+
+		case <-ldw.stopFlushing:
+			close(ldw.flushingDone)
+			return
+		}
+	}
+}
+
+// takeFlushErr returns the error of writing a quiet run, if there was one which wasn't returned yet. must be
+// called while locked
+func (ldw *LogDedupWriter) takeFlushErr() error {
+	flushErr := ldw.flushErr
+	ldw.flushErr = nil
+
+	if flushErr == nil {
+		return nil
+	}
+
+	return errors.Wrap(flushErr, "Failed to write quiet run")
+}
+
+// writeRun writes the pending run, if there is one. must be called while locked
+func (ldw *LogDedupWriter) writeRun() error {
+	if ldw.runRecord == nil {
+		return nil
+	}
+
+	runRecord := ldw.runRecord
+	ldw.runRecord = nil
+
+	if ldw.runLength > 1 {
+		var err error
+
+		if runRecord, err = ldw.getCollapsedRecord(runRecord); err != nil {
+			return errors.Wrap(err, "Failed to collapse records")
+		}
+	}
+
+	return ldw.logWriter.Write(runRecord)
+}
+
+// getCollapsedRecord returns a copy of the run's first record, describing the run in "more"
+func (ldw *LogDedupWriter) getCollapsedRecord(logRecord *LogRecord) (*LogRecord, error) {
+	collapsedRecord := *logRecord
+	collapsedRecord.More = make(map[string]*json.RawMessage, len(logRecord.More)+3)
+
+	for key, value := range logRecord.More {
+		collapsedRecord.More[key] = value
+	}
+
+	for key, value := range map[string]interface{}{
+		dedupRepeatedKey:      ldw.runLength,
+		dedupRepeatedForKey:   ldw.runLastWhen.Sub(logRecord.When).String(),
+		dedupRepeatedUntilKey: ldw.runLastWhen.Format(time.RFC3339Nano),
+	} {
+		marshalledValue, err := json.Marshal(value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to marshal %s", key)
+		}
+
+		rawValue := json.RawMessage(marshalledValue)
+		collapsedRecord.More[key] = &rawValue
+	}
+
+	return &collapsedRecord, nil
+}
+
+// getKey returns a key which is equal for records which are alike
+func (ldw *LogDedupWriter) getKey(logRecord *LogRecord) string {
+	parts := []string{logRecord.Who, logRecord.Severity, ldw.normalize(logRecord.What)}
+
+	moreKeys := ldw.moreKeys
+	if ldw.allMoreKeys {
+		moreKeys = make([]string, 0, len(logRecord.More))
+		for moreKey := range logRecord.More {
+			moreKeys = append(moreKeys, moreKey)
+		}
+
+		sort.Strings(moreKeys)
+	}
+
+	for _, moreKey := range moreKeys {
+		value := ""
+		if rawValue, found := logRecord.More[moreKey]; found && rawValue != nil {
+			value = string(*rawValue)
+		}
+
+		parts = append(parts, moreKey, ldw.normalize(value))
+	}
+
+	return strings.Join(parts, "\x00")
+}
+
+func (ldw *LogDedupWriter) normalize(value string) string {
+	if ldw.mode != DedupSimilar {
+		return value
+	}
+
+	return dedupNumberRegex.ReplaceAllString(value, "#")
+}
@@ -0,0 +1,67 @@
+package kibini
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLogDedupWriterCollapsesRuns(t *testing.T) {
+	recordingWriter := &recordingLogWriter{}
+	logDedupWriter := NewLogDedupWriter(DedupSimilar, nil, 0, recordingWriter)
+
+	for _, line := range []string{
+		`{"when":"2023-01-02T10:00:01.000","who":"api","severity":"warn","what":"retrying attempt 1"}`,
+		`{"when":"2023-01-02T10:00:02.000","who":"api","severity":"warn","what":"retrying attempt 2"}`,
+		`{"when":"2023-01-02T10:00:04.000","who":"api","severity":"warn","what":"retrying attempt 3"}`,
+		`{"when":"2023-01-02T10:00:05.000","who":"api","severity":"info","what":"connected"}`,
+	} {
+		if err := logDedupWriter.Write(NewLogRecord(line)); err != nil {
+			t.Fatalf("Failed to write: %s", err)
+		}
+	}
+
+	if err := logDedupWriter.Close(); err != nil {
+		t.Fatalf("Failed to close: %s", err)
+	}
+
+	if len(recordingWriter.logRecords) != 2 {
+		t.Fatalf("Expected 2 records, got %v", recordingWriter.getWhats())
+	}
+
+	collapsedRecord := recordingWriter.logRecords[0]
+	if repeated := string(*collapsedRecord.More[dedupRepeatedKey]); repeated != "3" {
+		t.Fatalf("Expected 3 repeats, got %s", repeated)
+	}
+
+	if repeatedFor := string(*collapsedRecord.More[dedupRepeatedForKey]); repeatedFor != `"3s"` {
+		t.Fatalf("Expected a run of 3s, got %s", repeatedFor)
+	}
+}
+
+func TestLogDedupWriterWritesQuietRuns(t *testing.T) {
+	recordingWriter := &recordingLogWriter{}
+	logDedupWriter := NewLogDedupWriter(DedupExact, nil, 50*time.Millisecond, recordingWriter)
+
+	for recordIndex := 0; recordIndex < 2; recordIndex++ {
+		if err := logDedupWriter.Write(NewLogRecord(`{"when":"2023-01-02T10:00:01.000","who":"api","severity":"info","what":"heartbeat"}`)); err != nil {
+			t.Fatalf("Failed to write: %s", err)
+		}
+	}
+
+	// the run is written without another record arriving or the writer being closed
+	for deadline := time.Now().Add(2 * time.Second); len(recordingWriter.getWhats()) == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the quiet run to be written")
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if err := logDedupWriter.Close(); err != nil {
+		t.Fatalf("Failed to close: %s", err)
+	}
+
+	if whats := recordingWriter.getWhats(); len(whats) != 1 {
+		t.Fatalf("Expected the run to be written once, got %v", whats)
+	}
+}
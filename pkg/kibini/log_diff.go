@@ -0,0 +1,307 @@
+package kibini
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/nuclio/errors"
+)
+
+// LogDifferenceKind is the way a template differs between two runs
+type LogDifferenceKind string
+
+const (
+	LogDifferenceNew     LogDifferenceKind = "new"
+	LogDifferenceGone    LogDifferenceKind = "gone"
+	LogDifferenceChanged LogDifferenceKind = "changed"
+)
+
+// DiffFormat determines how differences are written
+type DiffFormat int
+
+const (
+	DiffFormatTable DiffFormat = iota
+	DiffFormatJSON
+)
+
+// DiffOptions determine which runs are compared and how differences are reported
+type DiffOptions struct {
+
+	// InputOptions select the files and records of both runs. The input path is ignored
+	InputOptions
+
+	// InputPathA and InputPathB are the directories of the runs. A is the baseline
+	InputPathA string
+	InputPathB string
+
+	// Format is either DiffFormatTable or DiffFormatJSON
+	Format DiffFormat
+
+	// Ratio is the factor by which a template's count must change to be reported as changed
+	Ratio float64
+
+	// MinCount is the count a template must reach in one of the runs to be reported as changed
+	MinCount int
+
+	// Examples is the number of example records reported per difference, from each run
+	Examples int
+}
+
+// LogDiff holds the differences between two runs
+type LogDiff struct {
+	A           string           `json:"a"`
+	B           string           `json:"b"`
+	RecordsA    int              `json:"recordsA"`
+	RecordsB    int              `json:"recordsB"`
+	Differences []*LogDifference `json:"differences"`
+}
+
+// LogDifference is a template of a who (normalized, see getWhoTemplate) and severity whose count differs between
+// two runs
+type LogDifference struct {
+	Kind      LogDifferenceKind      `json:"kind"`
+	Who       string                 `json:"who"`
+	Severity  string                 `json:"severity"`
+	Template  string                 `json:"template"`
+	CountA    int                    `json:"countA"`
+	CountB    int                    `json:"countB"`
+	ExamplesA []*NormalizedLogRecord `json:"examplesA"`
+	ExamplesB []*NormalizedLogRecord `json:"examplesB"`
+}
+
+// Diff compares two runs and writes their differences to stdout
+func (k *Kibini) Diff(options *DiffOptions) error {
+	logDiff, err := k.GetLogDiff(options)
+	if err != nil {
+		return errors.Wrap(err, "Failed to diff logs")
+	}
+
+	if options.Format == DiffFormatJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.SetEscapeHTML(false)
+
+		return encoder.Encode(logDiff)
+	}
+
+	return writeLogDiffTables(os.Stdout, logDiff)
+}
+
+// GetLogDiff reads both runs and returns the templates which are new in B, gone from B or whose count changed
+// by the options' ratio. Messages are normalized into templates which ignore tokens with digits (IDs, numbers,
+// timestamps) and are compared per who and severity. Who is normalized as well (see getWhoTemplate), since
+// instances of the same component are usually named differently in each run
+func (k *Kibini) GetLogDiff(options *DiffOptions) (*LogDiff, error) {
+	var logTemplateCounters []*logTemplateCounter
+
+	for _, inputPath := range []string{options.InputPathA, options.InputPathB} {
+		inputOptions := options.InputOptions
+		inputOptions.InputPath = inputPath
+		inputOptions.InputFollow = false
+
+		logTemplateCounter := newLogTemplateCounter(options.Examples)
+
+		if err := k.ReadLogs(&inputOptions, []LogWriter{logTemplateCounter}); err != nil {
+			return nil, errors.Wrapf(err, "Failed to read logs of %s", inputPath)
+		}
+
+		logTemplateCounters = append(logTemplateCounters, logTemplateCounter)
+	}
+
+	logDiff := LogDiff{
+		A:           options.InputPathA,
+		B:           options.InputPathB,
+		RecordsA:    logTemplateCounters[0].records,
+		RecordsB:    logTemplateCounters[1].records,
+		Differences: []*LogDifference{},
+	}
+
+	keys := map[string]interface{}{}
+	for _, logTemplateCounter := range logTemplateCounters {
+		for key := range logTemplateCounter.counts {
+			keys[key] = nil
+		}
+	}
+
+	for _, key := range getSortedKeys(keys) {
+		countA := logTemplateCounters[0].counts[key]
+		countB := logTemplateCounters[1].counts[key]
+
+		var kind LogDifferenceKind
+		switch {
+		case countA == 0:
+			kind = LogDifferenceNew
+		case countB == 0:
+			kind = LogDifferenceGone
+		case (countA >= options.MinCount || countB >= options.MinCount) &&
+			math.Max(float64(countA), float64(countB))/math.Min(float64(countA), float64(countB)) >= options.Ratio:
+			kind = LogDifferenceChanged
+		default:
+			continue
+		}
+
+		keyParts := strings.SplitN(key, "\x00", 3)
+
+		logDiff.Differences = append(logDiff.Differences, &LogDifference{
+			Kind:      kind,
+			Who:       keyParts[0],
+			Severity:  keyParts[1],
+			Template:  keyParts[2],
+			CountA:    countA,
+			CountB:    countB,
+			ExamplesA: logTemplateCounters[0].getExamples(key),
+			ExamplesB: logTemplateCounters[1].getExamples(key),
+		})
+	}
+
+	// per who, new templates first, then gone and changed ones, the most frequent first
+	kindOrder := map[LogDifferenceKind]int{LogDifferenceNew: 0, LogDifferenceGone: 1, LogDifferenceChanged: 2}
+
+	sort.SliceStable(logDiff.Differences, func(i, j int) bool {
+		iDifference, jDifference := logDiff.Differences[i], logDiff.Differences[j]
+
+		if iDifference.Who != jDifference.Who {
+			return iDifference.Who < jDifference.Who
+		}
+
+		if iDifference.Kind != jDifference.Kind {
+			return kindOrder[iDifference.Kind] < kindOrder[jDifference.Kind]
+		}
+
+		return iDifference.CountA+iDifference.CountB > jDifference.CountA+jDifference.CountB
+	})
+
+	return &logDiff, nil
+}
+
+func writeLogDiffTables(writer io.Writer, logDiff *LogDiff) error {
+	if _, err := fmt.Fprintf(writer, "A: %s (%d records)\nB: %s (%d records)\n\n",
+		logDiff.A,
+		logDiff.RecordsA,
+		logDiff.B,
+		logDiff.RecordsB); err != nil {
+		return err
+	}
+
+	if len(logDiff.Differences) == 0 {
+		_, err := fmt.Fprintln(writer, "No differences")
+		return err
+	}
+
+	var rows [][]string
+	var exampleRows [][]string
+
+	for _, difference := range logDiff.Differences {
+		rows = append(rows, []string{
+			difference.Who,
+			strings.ToUpper(string(difference.Kind)),
+			difference.Severity,
+			strconv.Itoa(difference.CountA),
+			strconv.Itoa(difference.CountB),
+			difference.Template,
+		})
+
+		for sideIndex, examples := range [][]*NormalizedLogRecord{difference.ExamplesA, difference.ExamplesB} {
+			for _, example := range examples {
+				exampleRows = append(exampleRows, []string{
+					[]string{"A", "B"}[sideIndex],
+					fmt.Sprintf("%s:%d", example.Source, example.Line),
+					example.Who,
+					example.Severity,
+					example.What,
+				})
+			}
+		}
+	}
+
+	if err := writeTable(writer, []string{"WHO", "CHANGE", "SEVERITY", "A", "B", "TEMPLATE"}, rows); err != nil {
+		return err
+	}
+
+	if len(exampleRows) == 0 {
+		return nil
+	}
+
+	if _, err := fmt.Fprintln(writer); err != nil {
+		return err
+	}
+
+	return writeTable(writer, []string{"RUN", "SOURCE", "WHO", "SEVERITY", "EXAMPLE"}, exampleRows)
+}
+
+// getWhoTemplate returns who with the segments (separated by -, ., _ or /) which hold digits replaced by a
+// wildcard, e.g. "worker-5d8f" becomes "worker-<*>" and "pod.3" becomes "pod.<*>"
+func getWhoTemplate(who string) string {
+	var whoTemplate strings.Builder
+	segmentStart := 0
+
+	for index := 0; index <= len(who); index++ {
+		if index < len(who) && !strings.ContainsRune("-._/", rune(who[index])) {
+			continue
+		}
+
+		if segment := who[segmentStart:index]; strings.IndexFunc(segment, unicode.IsDigit) != -1 {
+			whoTemplate.WriteString(logTemplateWildcard)
+		} else {
+			whoTemplate.WriteString(segment)
+		}
+
+		if index < len(who) {
+			whoTemplate.WriteByte(who[index])
+		}
+
+		segmentStart = index + 1
+	}
+
+	return whoTemplate.String()
+}
+
+// logTemplateCounter is a LogWriter which counts records by who, severity and message template
+type logTemplateCounter struct {
+	records     int
+	counts      map[string]int
+	examples    map[string][]*LogRecord
+	maxExamples int
+}
+
+func newLogTemplateCounter(examples int) *logTemplateCounter {
+	return &logTemplateCounter{
+		counts:      map[string]int{},
+		examples:    map[string][]*LogRecord{},
+		maxExamples: examples,
+	}
+}
+
+func (ltc *logTemplateCounter) Write(logRecord *LogRecord) error {
+	key := strings.Join([]string{
+		getWhoTemplate(logRecord.Who),
+		logRecord.Severity,
+		strings.Join(getTemplateTokens(logRecord.What), " "),
+	}, "\x00")
+
+	ltc.records++
+	ltc.counts[key]++
+
+	if len(ltc.examples[key]) < ltc.maxExamples {
+		ltc.examples[key] = append(ltc.examples[key], logRecord)
+	}
+
+	return nil
+}
+
+func (ltc *logTemplateCounter) getExamples(key string) []*NormalizedLogRecord {
+	examples := []*NormalizedLogRecord{}
+
+	for _, example := range ltc.examples[key] {
+		examples = append(examples, example.Normalize())
+	}
+
+	return examples
+}
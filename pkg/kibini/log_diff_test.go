@@ -0,0 +1,64 @@
+package kibini
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGetWhoTemplate(t *testing.T) {
+	for who, expectedWhoTemplate := range map[string]string{
+		"worker-5d8f":                "worker-<*>",
+		"platform.provisioner":       "platform.provisioner",
+		"api-7b9c4-x2k9p.handler_v2": "api-<*>-<*>.handler_<*>",
+		"jobs/3":                     "jobs/<*>",
+		"":                           "",
+	} {
+		if whoTemplate := getWhoTemplate(who); whoTemplate != expectedWhoTemplate {
+			t.Errorf("Expected %q to become %q, got %q", who, expectedWhoTemplate, whoTemplate)
+		}
+	}
+}
+
+func TestLogDiffComparesInstancesOfTheSameWho(t *testing.T) {
+	var inputPaths []string
+
+	for _, who := range []string{"worker-5d8f", "worker-9a1c"} {
+		inputPath := filepath.Dir(writeTestLogFile(t, "worker.log",
+			`{"when":"2023-01-02T10:00:01.000","who":"`+who+`","severity":"info","what":"started"}`,
+			`{"when":"2023-01-02T10:00:02.000","who":"`+who+`","severity":"info","what":"processed job 17"}`))
+
+		inputPaths = append(inputPaths, inputPath)
+	}
+
+	// another message in B only
+	if err := os.WriteFile(filepath.Join(inputPaths[1], "other.log"),
+		[]byte(`{"when":"2023-01-02T10:00:03.000","who":"worker-9a1c","severity":"error","what":"crashed"}`+"\n"),
+		0644); err != nil {
+		t.Fatalf("Failed to write log file: %s", err)
+	}
+
+	logDiff, err := NewKibini(newTestLogger(t)).GetLogDiff(&DiffOptions{
+		InputPathA: inputPaths[0],
+		InputPathB: inputPaths[1],
+		Ratio:      2,
+		MinCount:   1,
+		Examples:   1,
+	})
+	if err != nil {
+		t.Fatalf("Failed to diff: %s", err)
+	}
+
+	if len(logDiff.Differences) != 1 {
+		t.Fatalf("Expected only the new message to differ, got %d differences", len(logDiff.Differences))
+	}
+
+	difference := logDiff.Differences[0]
+	if difference.Kind != LogDifferenceNew || difference.Who != "worker-<*>" || difference.Template != "crashed" {
+		t.Fatalf("Expected a new message of worker-<*>, got %+v", difference)
+	}
+
+	if difference.ExamplesB[0].Who != "worker-9a1c" {
+		t.Fatalf("Expected the example to keep its who, got %s", difference.ExamplesB[0].Who)
+	}
+}
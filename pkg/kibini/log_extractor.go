@@ -0,0 +1,159 @@
+package kibini
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/nuclio/errors"
+)
+
+// ExtractionConfig holds rules which extract fields from messages, as read from a YAML file (see
+// LoadExtractionConfig)
+type ExtractionConfig struct {
+	Rules []ExtractionRuleConfig `yaml:"rules"`
+}
+
+// ExtractionRuleConfig is a regular expression whose named groups are extracted from the "what" of records into
+// "more" keys of the same names. If Who is given, the rule only applies to records whose who matches it (a
+// regular expression as well)
+type ExtractionRuleConfig struct {
+	Name    string `yaml:"name"`
+	Who     string `yaml:"who"`
+	Pattern string `yaml:"pattern"`
+}
+
+// LoadExtractionConfig reads an extraction config from a YAML file
+func LoadExtractionConfig(path string) (*ExtractionConfig, error) {
+	var extractionConfig ExtractionConfig
+
+	if err := loadYAMLFile(path, &extractionConfig); err != nil {
+		return nil, errors.Wrapf(err, "Failed to load extraction config %s", path)
+	}
+
+	return &extractionConfig, nil
+}
+
+// LogExtractor extracts fields from the "what" of records into their "more", so that they can be filtered,
+// formatted and exported like any other "more" key. Values are coerced to JSON numbers where possible, and
+// durations (e.g. "1.5s", "250ms") are normalized to Go's notation, which filters compare as durations.
+// Keys the record already has aren't overwritten. Safe for concurrent use
+type LogExtractor struct {
+	rules []*logExtractionRule
+}
+
+type logExtractionRule struct {
+	who     *regexp.Regexp
+	pattern *regexp.Regexp
+}
+
+// NewLogExtractor compiles the rules of an extraction config
+func NewLogExtractor(config *ExtractionConfig) (*LogExtractor, error) {
+	logExtractor := LogExtractor{}
+
+	for ruleIndex, ruleConfig := range config.Rules {
+		ruleName := ruleConfig.Name
+		if len(ruleName) == 0 {
+			ruleName = fmt.Sprintf("#%d", ruleIndex+1)
+		}
+
+		rule, err := newLogExtractionRule(&ruleConfig)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Invalid extraction rule %s", ruleName)
+		}
+
+		logExtractor.rules = append(logExtractor.rules, rule)
+	}
+
+	return &logExtractor, nil
+}
+
+func newLogExtractionRule(config *ExtractionRuleConfig) (*logExtractionRule, error) {
+	var err error
+	rule := logExtractionRule{}
+
+	if len(config.Who) != 0 {
+		if rule.who, err = regexp.Compile(config.Who); err != nil {
+			return nil, errors.Wrapf(err, "Invalid who regular expression %s", config.Who)
+		}
+	}
+
+	if len(config.Pattern) == 0 {
+		return nil, errors.New("A pattern is required")
+	}
+
+	if rule.pattern, err = regexp.Compile(config.Pattern); err != nil {
+		return nil, errors.Wrapf(err, "Invalid regular expression %s", config.Pattern)
+	}
+
+	namedGroups := 0
+	for _, groupName := range rule.pattern.SubexpNames() {
+		if len(groupName) != 0 {
+			namedGroups++
+		}
+	}
+
+	if namedGroups == 0 {
+		return nil, errors.New(fmt.Sprintf("Pattern %s has no named groups (e.g. (?P<duration>\\S+))", config.Pattern))
+	}
+
+	return &rule, nil
+}
+
+// Extract adds the fields extracted by all matching rules to the record's "more". Returns whether anything
+// was extracted
+func (le *LogExtractor) Extract(logRecord *LogRecord) bool {
+	extracted := false
+
+	for _, rule := range le.rules {
+		if rule.who != nil && !rule.who.MatchString(logRecord.Who) {
+			continue
+		}
+
+		match := rule.pattern.FindStringSubmatchIndex(logRecord.What)
+		if match == nil {
+			continue
+		}
+
+		for groupIndex, groupName := range rule.pattern.SubexpNames() {
+
+			// unnamed groups and groups which didn't participate in the match are skipped
+			if len(groupName) == 0 || match[2*groupIndex] < 0 {
+				continue
+			}
+
+			if _, exists := logRecord.More[groupName]; exists {
+				continue
+			}
+
+			// records without "more" have none to add to
+			if logRecord.More == nil {
+				logRecord.More = map[string]*json.RawMessage{}
+			}
+
+			rawValue := coerceExtractedValue(logRecord.What[match[2*groupIndex]:match[2*groupIndex+1]])
+			logRecord.More[groupName] = &rawValue
+			extracted = true
+		}
+	}
+
+	return extracted
+}
+
+// coerceExtractedValue returns a JSON number if the value is one, a normalized duration string if it's a
+// duration and a JSON string otherwise
+func coerceExtractedValue(value string) json.RawMessage {
+	if _, err := strconv.ParseFloat(value, 64); err == nil && json.Valid([]byte(value)) {
+		return json.RawMessage(value)
+	}
+
+	if duration, err := time.ParseDuration(value); err == nil {
+		value = duration.String()
+	}
+
+	marshalledValue, _ := json.Marshal(value) // nolint: errcheck
+
+	return json.RawMessage(marshalledValue)
+}
@@ -0,0 +1,481 @@
+package kibini
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/nuclio/errors"
+)
+
+// LogFilter decides whether a log record is selected
+type LogFilter interface {
+	Match(logRecord *LogRecord) bool
+}
+
+// ParseLogFilter parses a filter expression. An expression is made of terms combined with "and" (or just
+// whitespace), "or", "not" and parentheses. A term is either a comparison of a field (see LogRecord.GetField)
+// with a value, or text which must appear in the record (case insensitive, see LogRecord.containsText):
+//
+//	severity=error who~adapter|provisioner
+//	more.attempt>=2 and not "connection refused"
+//	(ctx=req-1 or more.RequestID=req-1) when>2023-01-01T10:00:00
+//
+// Operators are = and != (case insensitive), ~ and !~ (regular expressions) and <, <=, >, >= (numbers, times
+// and durations such as 250ms are compared as such, other values as strings)
+func ParseLogFilter(expression string) (LogFilter, error) {
+	tokens, err := tokenizeLogFilter(expression)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to tokenize filter")
+	}
+
+	parser := logFilterParser{tokens: tokens}
+
+	// an empty expression selects everything
+	if len(tokens) == 0 {
+		return logFilterAll{}, nil
+	}
+
+	logFilter, err := parser.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if !parser.done() {
+		return nil, errors.New(fmt.Sprintf("Unexpected %s in filter", parser.peek().value))
+	}
+
+	return logFilter, nil
+}
+
+type logFilterTokenKind int
+
+const (
+	logFilterTokenWord logFilterTokenKind = iota
+	logFilterTokenString
+	logFilterTokenOperator
+	logFilterTokenOpenParen
+	logFilterTokenCloseParen
+)
+
+type logFilterToken struct {
+	kind  logFilterTokenKind
+	value string
+}
+
+const logFilterOperatorCharacters = "=!~<>"
+
+func tokenizeLogFilter(expression string) ([]logFilterToken, error) {
+	var tokens []logFilterToken
+
+	runes := []rune(expression)
+
+	for runeIndex := 0; runeIndex < len(runes); {
+		currentRune := runes[runeIndex]
+
+		switch {
+		case unicode.IsSpace(currentRune):
+			runeIndex++
+
+		case currentRune == '(':
+			tokens = append(tokens, logFilterToken{kind: logFilterTokenOpenParen, value: "("})
+			runeIndex++
+
+		case currentRune == ')':
+			tokens = append(tokens, logFilterToken{kind: logFilterTokenCloseParen, value: ")"})
+			runeIndex++
+
+		case currentRune == '"':
+			endIndex := runeIndex + 1
+			for ; endIndex < len(runes) && runes[endIndex] != '"'; endIndex++ {
+				if runes[endIndex] == '\\' {
+					endIndex++
+				}
+			}
+
+			if endIndex >= len(runes) {
+				return nil, errors.New("Unterminated string")
+			}
+
+			value, err := strconv.Unquote(string(runes[runeIndex : endIndex+1]))
+			if err != nil {
+				return nil, errors.Wrapf(err, "Invalid string %s", string(runes[runeIndex:endIndex+1]))
+			}
+
+			tokens = append(tokens, logFilterToken{kind: logFilterTokenString, value: value})
+			runeIndex = endIndex + 1
+
+		case strings.ContainsRune(logFilterOperatorCharacters, currentRune):
+			endIndex := runeIndex
+			for endIndex < len(runes) && strings.ContainsRune(logFilterOperatorCharacters, runes[endIndex]) {
+				endIndex++
+			}
+
+			tokens = append(tokens, logFilterToken{kind: logFilterTokenOperator, value: string(runes[runeIndex:endIndex])})
+			runeIndex = endIndex
+
+		default:
+			endIndex := runeIndex
+			for endIndex < len(runes) &&
+				!unicode.IsSpace(runes[endIndex]) &&
+				!strings.ContainsRune(logFilterOperatorCharacters+"()\"", runes[endIndex]) {
+				endIndex++
+			}
+
+			tokens = append(tokens, logFilterToken{kind: logFilterTokenWord, value: string(runes[runeIndex:endIndex])})
+			runeIndex = endIndex
+		}
+	}
+
+	return tokens, nil
+}
+
+// logFilterParser is a recursive descent parser of:
+//
+//	or         := and ("or" and)*
+//	and        := not (["and"] not)*
+//	not        := "not" not | primary
+//	primary    := "(" or ")" | comparison | text
+//	comparison := field operator value
+type logFilterParser struct {
+	tokens     []logFilterToken
+	tokenIndex int
+}
+
+func (lfp *logFilterParser) done() bool {
+	return lfp.tokenIndex >= len(lfp.tokens)
+}
+
+func (lfp *logFilterParser) peek() *logFilterToken {
+	if lfp.done() {
+		return nil
+	}
+
+	return &lfp.tokens[lfp.tokenIndex]
+}
+
+func (lfp *logFilterParser) peekKeyword(keyword string) bool {
+	token := lfp.peek()
+	return token != nil && token.kind == logFilterTokenWord && strings.EqualFold(token.value, keyword)
+}
+
+func (lfp *logFilterParser) parseOr() (LogFilter, error) {
+	operand, err := lfp.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	operands := []LogFilter{operand}
+
+	for lfp.peekKeyword("or") {
+		lfp.tokenIndex++
+
+		operand, err := lfp.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+
+		operands = append(operands, operand)
+	}
+
+	if len(operands) == 1 {
+		return operands[0], nil
+	}
+
+	return logFilterOr(operands), nil
+}
+
+func (lfp *logFilterParser) parseAnd() (LogFilter, error) {
+	operand, err := lfp.parseNot()
+	if err != nil {
+		return nil, err
+	}
+
+	operands := []LogFilter{operand}
+
+	for !lfp.done() && lfp.peek().kind != logFilterTokenCloseParen && !lfp.peekKeyword("or") {
+		if lfp.peekKeyword("and") {
+			lfp.tokenIndex++
+		}
+
+		operand, err := lfp.parseNot()
+		if err != nil {
+			return nil, err
+		}
+
+		operands = append(operands, operand)
+	}
+
+	if len(operands) == 1 {
+		return operands[0], nil
+	}
+
+	return logFilterAnd(operands), nil
+}
+
+func (lfp *logFilterParser) parseNot() (LogFilter, error) {
+	if lfp.peekKeyword("not") {
+		lfp.tokenIndex++
+
+		operand, err := lfp.parseNot()
+		if err != nil {
+			return nil, err
+		}
+
+		return logFilterNot{operand: operand}, nil
+	}
+
+	return lfp.parsePrimary()
+}
+
+func (lfp *logFilterParser) parsePrimary() (LogFilter, error) {
+	token := lfp.peek()
+	if token == nil {
+		return nil, errors.New("Unexpected end of filter")
+	}
+
+	lfp.tokenIndex++
+
+	switch token.kind {
+	case logFilterTokenOpenParen:
+		operand, err := lfp.parseOr()
+		if err != nil {
+			return nil, err
+		}
+
+		if closeToken := lfp.peek(); closeToken == nil || closeToken.kind != logFilterTokenCloseParen {
+			return nil, errors.New("Missing ) in filter")
+		}
+
+		lfp.tokenIndex++
+		return operand, nil
+
+	case logFilterTokenWord:
+
+		// a word followed by an operator is a comparison
+		if operatorToken := lfp.peek(); operatorToken != nil && operatorToken.kind == logFilterTokenOperator {
+			return lfp.parseComparison(token.value)
+		}
+
+		return newLogFilterText(token.value), nil
+
+	case logFilterTokenString:
+		return newLogFilterText(token.value), nil
+	}
+
+	return nil, errors.New(fmt.Sprintf("Unexpected %s in filter", token.value))
+}
+
+func (lfp *logFilterParser) parseComparison(field string) (LogFilter, error) {
+	if !IsValidFieldName(field) {
+		return nil, errors.New(fmt.Sprintf("Unknown field in filter: %s (expected a record field or more.<key>)", field))
+	}
+
+	operator := lfp.peek().value
+	lfp.tokenIndex++
+
+	valueToken := lfp.peek()
+	if valueToken == nil || (valueToken.kind != logFilterTokenWord && valueToken.kind != logFilterTokenString) {
+		return nil, errors.New(fmt.Sprintf("Missing value after %s%s", field, operator))
+	}
+
+	lfp.tokenIndex++
+
+	return newLogFilterComparison(field, operator, valueToken.value)
+}
+
+type logFilterAll struct{}
+
+func (lfa logFilterAll) Match(logRecord *LogRecord) bool {
+	return true
+}
+
+type logFilterAnd []LogFilter
+
+func (lfa logFilterAnd) Match(logRecord *LogRecord) bool {
+	for _, operand := range lfa {
+		if !operand.Match(logRecord) {
+			return false
+		}
+	}
+
+	return true
+}
+
+type logFilterOr []LogFilter
+
+func (lfo logFilterOr) Match(logRecord *LogRecord) bool {
+	for _, operand := range lfo {
+		if operand.Match(logRecord) {
+			return true
+		}
+	}
+
+	return false
+}
+
+type logFilterNot struct {
+	operand LogFilter
+}
+
+func (lfn logFilterNot) Match(logRecord *LogRecord) bool {
+	return !lfn.operand.Match(logRecord)
+}
+
+type logFilterText struct {
+	lowerText string
+}
+
+func newLogFilterText(text string) *logFilterText {
+	return &logFilterText{lowerText: strings.ToLower(text)}
+}
+
+func (lft *logFilterText) Match(logRecord *LogRecord) bool {
+	return logRecord.containsText(lft.lowerText)
+}
+
+type logFilterComparison struct {
+	field         string
+	operator      string
+	value         string
+	regex         *regexp.Regexp
+	numberValue   *float64
+	timeValue     *time.Time
+	durationValue *time.Duration
+}
+
+func newLogFilterComparison(field string, operator string, value string) (*logFilterComparison, error) {
+	lfc := logFilterComparison{
+		field:    field,
+		operator: operator,
+		value:    value,
+	}
+
+	switch operator {
+	case "=", "!=":
+	case "~", "!~":
+		regex, err := regexp.Compile(value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Invalid regular expression %s", value)
+		}
+
+		lfc.regex = regex
+
+	case "<", "<=", ">", ">=":
+		if numberValue, err := strconv.ParseFloat(value, 64); err == nil {
+			lfc.numberValue = &numberValue
+		}
+
+		if timeValue, err := ParseTime(value); err == nil {
+			lfc.timeValue = &timeValue
+		}
+
+		if durationValue, err := time.ParseDuration(value); err == nil {
+			lfc.durationValue = &durationValue
+		}
+
+	default:
+		return nil, errors.New(fmt.Sprintf("Unknown operator in filter: %s", operator))
+	}
+
+	return &lfc, nil
+}
+
+func (lfc *logFilterComparison) Match(logRecord *LogRecord) bool {
+	fieldValue, found := logRecord.GetField(lfc.field)
+
+	switch lfc.operator {
+	case "=":
+		return found && strings.EqualFold(flattenValue(fieldValue), lfc.value)
+	case "!=":
+		return !found || !strings.EqualFold(flattenValue(fieldValue), lfc.value)
+	case "~":
+		return found && lfc.regex.MatchString(flattenValue(fieldValue))
+	case "!~":
+		return !found || !lfc.regex.MatchString(flattenValue(fieldValue))
+	}
+
+	if !found {
+		return false
+	}
+
+	comparison := lfc.compare(logRecord, fieldValue)
+
+	switch lfc.operator {
+	case "<":
+		return comparison < 0
+	case "<=":
+		return comparison <= 0
+	case ">":
+		return comparison > 0
+	default:
+		return comparison >= 0
+	}
+}
+
+// compare returns a negative number if the field value is less than the filter's value, 0 if they're equal and a
+// positive number if it's greater
+func (lfc *logFilterComparison) compare(logRecord *LogRecord, fieldValue interface{}) int {
+	if lfc.field == "when" && lfc.timeValue != nil {
+		switch whenUnixNano := lfc.timeValue.UnixNano(); {
+		case logRecord.WhenUnixNano < whenUnixNano:
+			return -1
+		case logRecord.WhenUnixNano > whenUnixNano:
+			return 1
+		default:
+			return 0
+		}
+	}
+
+	flatFieldValue := flattenValue(fieldValue)
+
+	if lfc.numberValue != nil {
+		if numberFieldValue, err := strconv.ParseFloat(flatFieldValue, 64); err == nil {
+			switch {
+			case numberFieldValue < *lfc.numberValue:
+				return -1
+			case numberFieldValue > *lfc.numberValue:
+				return 1
+			default:
+				return 0
+			}
+		}
+	}
+
+	if lfc.durationValue != nil {
+		if durationFieldValue, err := time.ParseDuration(flatFieldValue); err == nil {
+			switch {
+			case durationFieldValue < *lfc.durationValue:
+				return -1
+			case durationFieldValue > *lfc.durationValue:
+				return 1
+			default:
+				return 0
+			}
+		}
+	}
+
+	return strings.Compare(flatFieldValue, lfc.value)
+}
+
+// ParseTime parses a time as either RFC3339 or as written in log records (UTC without a time zone). The date
+// alone and times without seconds are accepted as well
+func ParseTime(value string) (time.Time, error) {
+	for _, layout := range []string{
+		time.RFC3339Nano,
+		"2006-01-02T15:04:05.999999999",
+		"2006-01-02T15:04",
+		"2006-01-02 15:04:05.999999999",
+		"2006-01-02 15:04",
+		"2006-01-02",
+	} {
+		if parsedTime, err := time.Parse(layout, value); err == nil {
+			return parsedTime, nil
+		}
+	}
+
+	return time.Time{}, errors.New(fmt.Sprintf("Invalid time: %s (expected e.g. 2006-01-02T15:04:05.000)", value))
+}
@@ -1,32 +1,97 @@
-package core
+package kibini
 
 import (
 	"io"
+	"os"
 
 	"github.com/nuclio/errors"
 	"github.com/nuclio/logger"
 )
 
-type logFormattedWriter struct {
-	logger    logger.Logger
-	formatter logFormatter
-	writer    io.Writer
+// LogFormattedWriter formats log records and writes them to an io.Writer
+type LogFormattedWriter struct {
+	logger        logger.Logger
+	formatter     LogFormatter
+	writer        io.Writer
+	headerWritten bool
 }
 
-func newLogFormattedWriter(logger logger.Logger,
-	formatter logFormatter,
-	writer io.Writer) *logFormattedWriter {
-	return &logFormattedWriter{
+// NewLogFormattedWriter creates a LogFormattedWriter. The writer is closed along with it if it's an io.Closer
+// (other than stdout and stderr)
+func NewLogFormattedWriter(logger logger.Logger,
+	formatter LogFormatter,
+	writer io.Writer) *LogFormattedWriter {
+	return &LogFormattedWriter{
 		logger:    logger.GetChild("formatted-writer"),
 		formatter: formatter,
 		writer:    writer,
 	}
 }
 
-func (lfw *logFormattedWriter) Write(logRecord *logRecord) error {
+func (lfw *LogFormattedWriter) Write(logRecord *LogRecord) error {
+	if err := lfw.writeHeader(); err != nil {
+		return errors.Wrap(err, "Failed to write header")
+	}
+
 	if _, err := lfw.writer.Write([]byte(lfw.formatter.Format(logRecord))); err != nil {
 		return errors.Wrap(err, "Failed to write log record")
 	}
 
 	return nil
 }
+
+// Close writes the footer, if the formatter has one, and closes the writer. The header is written as well if no
+// records were written
+func (lfw *LogFormattedWriter) Close() error {
+	if err := lfw.writeFooter(); err != nil {
+		lfw.closeWriter() // nolint: errcheck
+		return err
+	}
+
+	if err := lfw.closeWriter(); err != nil {
+		return errors.Wrap(err, "Failed to close writer")
+	}
+
+	return nil
+}
+
+func (lfw *LogFormattedWriter) writeFooter() error {
+	if err := lfw.writeHeader(); err != nil {
+		return errors.Wrap(err, "Failed to write header")
+	}
+
+	if footerFormatter, hasFooter := lfw.formatter.(LogFooterFormatter); hasFooter {
+		if _, err := lfw.writer.Write([]byte(footerFormatter.FormatFooter())); err != nil {
+			return errors.Wrap(err, "Failed to write footer")
+		}
+	}
+
+	return nil
+}
+
+// closeWriter closes the writer if it's an io.Closer. Stdout and stderr are shared, so they're left open
+func (lfw *LogFormattedWriter) closeWriter() error {
+	if lfw.writer == io.Writer(os.Stdout) || lfw.writer == io.Writer(os.Stderr) {
+		return nil
+	}
+
+	if closer, isCloser := lfw.writer.(io.Closer); isCloser {
+		return closer.Close()
+	}
+
+	return nil
+}
+
+func (lfw *LogFormattedWriter) writeHeader() error {
+
+	// if the formatter has a header, write it before the first record
+	if headerFormatter, hasHeader := lfw.formatter.(LogHeaderFormatter); hasHeader && !lfw.headerWritten {
+		if _, err := lfw.writer.Write([]byte(headerFormatter.FormatHeader())); err != nil {
+			return err
+		}
+
+		lfw.headerWritten = true
+	}
+
+	return nil
+}
@@ -0,0 +1,46 @@
+package kibini
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+// closeRecordingWriter is a buffer which records whether it was closed
+type closeRecordingWriter struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (crw *closeRecordingWriter) Close() error {
+	crw.closed = true
+	return nil
+}
+
+func TestLogFormattedWriterClosesWriter(t *testing.T) {
+	logRecord := NewLogRecord(`{"when":"2023-01-02T10:00:01.000","who":"api","severity":"info","what":"started"}`)
+
+	writer := &closeRecordingWriter{}
+	logFormattedWriter := NewLogFormattedWriter(newTestLogger(t), NewHTMLFormatter(), writer)
+
+	if err := logFormattedWriter.Write(logRecord); err != nil {
+		t.Fatalf("Failed to write: %s", err)
+	}
+
+	if err := logFormattedWriter.Close(); err != nil {
+		t.Fatalf("Failed to close: %s", err)
+	}
+
+	if !writer.closed || !bytes.HasSuffix(bytes.TrimSpace(writer.Bytes()), []byte("</html>")) {
+		t.Fatalf("Expected the writer to be closed after the footer, closed: %t", writer.closed)
+	}
+
+	// stdout is shared, so it's never closed
+	if err := NewLogFormattedWriter(newTestLogger(t), NewHumanReadableFormatter(false, 30), os.Stdout).Close(); err != nil {
+		t.Fatalf("Failed to close: %s", err)
+	}
+
+	if _, err := os.Stdout.Stat(); err != nil {
+		t.Fatalf("Expected stdout to be open: %s", err)
+	}
+}
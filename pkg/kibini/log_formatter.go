@@ -1,73 +1,127 @@
-package core
+package kibini
 
 import (
 	"encoding/json"
 	"fmt"
+	"regexp"
 	"strings"
 
 	"github.com/mgutz/ansi"
 )
 
-type logFormatter interface {
-	Format(logRecord *logRecord) string
+// LogFormatter formats a log record to a string
+type LogFormatter interface {
+	Format(logRecord *LogRecord) string
 }
 
-type humanReadableFormatter struct {
-	color    bool
-	whoWidth int
+// LogHeaderFormatter is implemented by formatters whose output starts with a header (e.g. CSV)
+type LogHeaderFormatter interface {
+	FormatHeader() string
 }
 
-func newHumanReadableFormatter(color bool, whoWidth int) *humanReadableFormatter {
-	return &humanReadableFormatter{
-		color,
-		whoWidth,
+// LogFooterFormatter is implemented by formatters whose output ends with a footer (e.g. HTML)
+type LogFooterFormatter interface {
+	FormatFooter() string
+}
+
+// HumanReadableFormatter formats log records as stdout-like lines, pretty printing "more"
+type HumanReadableFormatter struct {
+	color     bool
+	whoWidth  int
+	highlight *regexp.Regexp
+}
+
+// NewHumanReadableFormatter creates a HumanReadableFormatter
+func NewHumanReadableFormatter(color bool, whoWidth int) *HumanReadableFormatter {
+	return &HumanReadableFormatter{
+		color:    color,
+		whoWidth: whoWidth,
 	}
 }
 
-func (hrf *humanReadableFormatter) Format(logRecord *logRecord) string {
+// SetHighlight highlights matches of the regex in "what" and "more". Only applies when coloring
+func (hrf *HumanReadableFormatter) SetHighlight(highlight *regexp.Regexp) {
+	hrf.highlight = highlight
+}
+
+func (hrf *HumanReadableFormatter) Format(logRecord *LogRecord) string {
 	var formatted string
-	severityCode := logRecord.Severity[0]
+
+	severityCode := byte('?')
+	if len(logRecord.Severity) != 0 {
+		severityCode = logRecord.Severity[0]
+	}
 
 	if !hrf.color {
 		formatted = fmt.Sprintf("%s %30s (%c) %s ",
 			logRecord.When.Format("02.01.06 15:04:05.000000"),
-			logRecord.rtruncateString(logRecord.Who, hrf.whoWidth),
-			logRecord.Severity[0],
+			rtruncateString(logRecord.Who, hrf.whoWidth),
+			severityCode,
 			logRecord.What)
 	} else {
 		formatted = fmt.Sprintf("%s%s %30s%s: (%s%c%s) %s%s%s ",
 			ansi.LightBlack,
 			logRecord.When.Format("020106 15:04:05.000000"),
-			logRecord.rtruncateString(logRecord.Who, hrf.whoWidth),
+			rtruncateString(logRecord.Who, hrf.whoWidth),
 			ansi.Reset,
 			hrf.getSeverityColor(severityCode), severityCode, ansi.Reset,
-			ansi.Cyan, logRecord.What, ansi.Reset)
+			ansi.Cyan, hrf.highlightMatches(logRecord.What, ansi.Cyan), ansi.Reset)
 	}
 
-	// if there's a context, add it to more as a string in quotations
+	formatted += hrf.highlightMatches(hrf.formatRecordMore(logRecord), ansi.Reset)
+
+	return formatted + "\n"
+}
+
+// formatRecordMore formats the record's more. if there's a context, it's shown in more as a string. the record
+// may be written elsewhere as well (e.g. to other sinks), so more is copied rather than modified
+func (hrf *HumanReadableFormatter) formatRecordMore(logRecord *LogRecord) string {
+	more := logRecord.More
 	if len(logRecord.Ctx) > 0 {
-		rm := json.RawMessage(fmt.Sprintf("\"%s\"", logRecord.Ctx))
-		logRecord.More["ctx"] = &rm
+		more = make(map[string]*json.RawMessage, len(logRecord.More)+1)
+		for key, value := range logRecord.More {
+			more[key] = value
+		}
+
+		marshalledCtx, _ := json.Marshal(logRecord.Ctx)
+		rawCtx := json.RawMessage(marshalledCtx)
+		more["ctx"] = &rawCtx
 	}
 
-	marshalledMore, err := json.MarshalIndent(logRecord.More, "", "    ")
+	return hrf.formatMore(more)
+}
+
+func (hrf *HumanReadableFormatter) formatMore(more map[string]*json.RawMessage) string {
+	marshalledMore, err := json.MarshalIndent(more, "", "    ")
 
 	if err != nil {
-		formatted += fmt.Sprintf("<Error formatting more: %s>", err)
-	} else {
+		return fmt.Sprintf("<Error formatting more: %s>", err)
+	}
 
-		// if the string is short, apply some magic to it so that it looks nice
-		if len(marshalledMore) < 150 {
-			formatted += hrf.formatShortMore(marshalledMore)
-		} else {
-			formatted += strings.Replace(string(marshalledMore), "\\n", "\n", -1)
-		}
+	// if the string is short, apply some magic to it so that it looks nice
+	if len(marshalledMore) < 150 {
+		return hrf.formatShortMore(marshalledMore)
 	}
 
-	return formatted + "\n"
+	return strings.Replace(string(marshalledMore), "\\n", "\n", -1)
+}
+
+// highlightMatches colors the matches of the highlight regex in text, restoring color after each match
+func (hrf *HumanReadableFormatter) highlightMatches(text string, color string) string {
+	if hrf.highlight == nil || !hrf.color {
+		return text
+	}
+
+	return hrf.highlight.ReplaceAllStringFunc(text, func(match string) string {
+		if len(match) == 0 {
+			return match
+		}
+
+		return ansi.ColorCode("red+b") + match + ansi.Reset + color
+	})
 }
 
-func (hrf *humanReadableFormatter) getSeverityColor(severityCode byte) string {
+func (hrf *HumanReadableFormatter) getSeverityColor(severityCode byte) string {
 	switch string(severityCode) {
 	case "V":
 		return ansi.LightBlue
@@ -80,7 +134,7 @@ func (hrf *humanReadableFormatter) getSeverityColor(severityCode byte) string {
 	return ansi.Reset
 }
 
-func (hrf *humanReadableFormatter) formatShortMore(marshalledMore []byte) string {
+func (hrf *HumanReadableFormatter) formatShortMore(marshalledMore []byte) string {
 	result := string(marshalledMore)
 
 	// replace newlines and stuff with what was supposed to be a tab
@@ -0,0 +1,219 @@
+package kibini
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/nuclio/errors"
+)
+
+// GrepOptions determine what Grep searches for and how matches are written
+type GrepOptions struct {
+	ProcessLogsOptions
+
+	// Pattern is searched for in "what" and in the serialized "more" of each record
+	Pattern string
+
+	// Regex treats the pattern as a regular expression rather than a fixed string
+	Regex bool
+
+	// IgnoreCase matches regardless of case
+	IgnoreCase bool
+
+	// Word matches only whole words
+	Word bool
+
+	// Before and After are the number of records written before and after each match, as context
+	Before int
+	After  int
+}
+
+// Grep writes the records matching the pattern to stdout, merged (sorted by time), and returns the number of
+// matching records. Matches are highlighted in the human readable format
+func (k *Kibini) Grep(options *GrepOptions) (int, error) {
+	matcher, err := CompileGrepPattern(options.Pattern, options.Regex, options.IgnoreCase, options.Word)
+	if err != nil {
+		return 0, errors.Wrap(err, "Failed to compile pattern")
+	}
+
+	if options.OutputFormat == OutputFormatSQLite {
+		return 0, errors.New("sqlite output requires an output path, not stdout")
+	}
+
+	color := k.determineColorSetting(options.ColorSetting, true)
+
+	logFormatter, err := k.createLogFormatter(&options.ProcessLogsOptions, color)
+	if err != nil {
+		return 0, errors.Wrap(err, "Failed to create log formatter")
+	}
+
+	if humanReadableFormatter, isHumanReadable := logFormatter.(*HumanReadableFormatter); isHumanReadable {
+		humanReadableFormatter.SetHighlight(matcher)
+	}
+
+	// separators between groups of context only make sense in text
+	var separatorWriter io.Writer
+	if options.OutputFormat == OutputFormatText {
+		separatorWriter = os.Stdout
+	}
+
+	logFormattedWriter, err := k.wrapOutputLogWriter(&options.ProcessLogsOptions,
+		NewLogFormattedWriter(k.logger, logFormatter, os.Stdout))
+	if err != nil {
+		return 0, errors.Wrap(err, "Failed to create output writer")
+	}
+
+	logGrepWriter := NewLogGrepWriter(matcher, options.Before, options.After, logFormattedWriter, separatorWriter)
+
+	// without context only matching records are needed, so let the readers skip the rest (using indexes,
+	// if fresh). a match must contain the pattern in "what" or in a key or value of "more", unless the pattern
+	// spans JSON syntax or characters which are escaped when serializing
+	inputOptions := options.InputOptions
+	if !options.Regex &&
+		options.Before == 0 &&
+		options.After == 0 &&
+		!strings.ContainsAny(options.Pattern, `"\{}[]:,<>&`) {
+		inputOptions.Filter = strconv.Quote(options.Pattern)
+
+		if len(options.Filter) != 0 {
+			inputOptions.Filter = fmt.Sprintf("(%s) %s", options.Filter, inputOptions.Filter)
+		}
+	}
+
+	if err := k.ReadLogs(&inputOptions, []LogWriter{logGrepWriter}); err != nil {
+		return 0, errors.Wrap(err, "Failed to read logs")
+	}
+
+	if err := closeLogWriters([]LogWriter{logFormattedWriter}); err != nil {
+		return 0, errors.Wrap(err, "Failed to close writer")
+	}
+
+	return logGrepWriter.GetMatchCount(), nil
+}
+
+// CompileGrepPattern compiles a pattern as used by Grep
+func CompileGrepPattern(pattern string, regex bool, ignoreCase bool, word bool) (*regexp.Regexp, error) {
+	if !regex {
+		pattern = regexp.QuoteMeta(pattern)
+	}
+
+	if word {
+		pattern = `\b(?:` + pattern + `)\b`
+	}
+
+	if ignoreCase {
+		pattern = "(?i)" + pattern
+	}
+
+	return regexp.Compile(pattern)
+}
+
+// LogGrepWriter writes records whose "what" or serialized "more" match a regex, along with records before and
+// after them as context (like grep -B/-A). Non adjacent groups of records are separated by "--"
+type LogGrepWriter struct {
+	matcher         *regexp.Regexp
+	before          int
+	after           int
+	logWriter       LogWriter
+	separatorWriter io.Writer
+	beforeRecords   []*LogRecord
+	afterRemaining  int
+	skipped         bool
+	written         bool
+	matchCount      int
+}
+
+// NewLogGrepWriter creates a LogGrepWriter which writes to logWriter. separatorWriter may be nil, in which case
+// separators aren't written
+func NewLogGrepWriter(matcher *regexp.Regexp,
+	before int,
+	after int,
+	logWriter LogWriter,
+	separatorWriter io.Writer) *LogGrepWriter {
+	return &LogGrepWriter{
+		matcher:         matcher,
+		before:          before,
+		after:           after,
+		logWriter:       logWriter,
+		separatorWriter: separatorWriter,
+	}
+}
+
+func (lgw *LogGrepWriter) Write(logRecord *LogRecord) error {
+	if !lgw.Match(logRecord) {
+
+		// write it as context after a match, or keep it as possible context before the next one
+		if lgw.afterRemaining > 0 {
+			lgw.afterRemaining--
+			return lgw.writeRecord(logRecord)
+		}
+
+		lgw.beforeRecords = append(lgw.beforeRecords, logRecord)
+		if len(lgw.beforeRecords) > lgw.before {
+			lgw.beforeRecords = lgw.beforeRecords[1:]
+			lgw.skipped = true
+		}
+
+		return nil
+	}
+
+	lgw.matchCount++
+
+	if lgw.skipped && lgw.written && lgw.separatorWriter != nil && (lgw.before != 0 || lgw.after != 0) {
+		if _, err := io.WriteString(lgw.separatorWriter, "--\n"); err != nil {
+			return errors.Wrap(err, "Failed to write separator")
+		}
+	}
+
+	lgw.skipped = false
+
+	for _, beforeRecord := range lgw.beforeRecords {
+		if err := lgw.writeRecord(beforeRecord); err != nil {
+			return err
+		}
+	}
+
+	lgw.beforeRecords = lgw.beforeRecords[:0]
+	lgw.afterRemaining = lgw.after
+
+	return lgw.writeRecord(logRecord)
+}
+
+// Match returns whether the record's "what" or serialized "more" match
+func (lgw *LogGrepWriter) Match(logRecord *LogRecord) bool {
+	return matchRecordText(lgw.matcher, logRecord)
+}
+
+// GetMatchCount returns the number of matching records written so far
+func (lgw *LogGrepWriter) GetMatchCount() int {
+	return lgw.matchCount
+}
+
+func (lgw *LogGrepWriter) writeRecord(logRecord *LogRecord) error {
+	lgw.written = true
+
+	return lgw.logWriter.Write(logRecord)
+}
+
+// matchRecordText returns whether the matcher matches the record's "what" or serialized "more"
+func matchRecordText(matcher *regexp.Regexp, logRecord *LogRecord) bool {
+	if matcher.MatchString(logRecord.What) {
+		return true
+	}
+
+	if len(logRecord.More) == 0 {
+		return false
+	}
+
+	marshalledMore, err := json.Marshal(logRecord.More)
+	if err != nil {
+		return false
+	}
+
+	return matcher.Match(marshalledMore)
+}
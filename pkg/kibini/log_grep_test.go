@@ -0,0 +1,91 @@
+package kibini
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+)
+
+// whatLogWriter writes the "what" of each record as a line
+type whatLogWriter struct {
+	writer io.Writer
+}
+
+func (wlw *whatLogWriter) Write(logRecord *LogRecord) error {
+	_, err := fmt.Fprintln(wlw.writer, logRecord.What)
+	return err
+}
+
+func TestLogGrepWriterContext(t *testing.T) {
+	whats := []string{"a", "b", "match 1", "c", "d", "e", "f", "match 2", "match 3", "g", "h", "i"}
+
+	for _, testCase := range []struct {
+		before         int
+		after          int
+		expectedOutput string
+	}{
+		{0, 0, "match 1\nmatch 2\nmatch 3\n"},
+		{1, 0, "b\nmatch 1\n--\nf\nmatch 2\nmatch 3\n"},
+		{0, 1, "match 1\nc\n--\nmatch 2\nmatch 3\ng\n"},
+		{1, 2, "b\nmatch 1\nc\nd\n--\nf\nmatch 2\nmatch 3\ng\nh\n"},
+
+		// groups which touch aren't separated
+		{2, 2, "a\nb\nmatch 1\nc\nd\ne\nf\nmatch 2\nmatch 3\ng\nh\n"},
+	} {
+		var output bytes.Buffer
+
+		matcher, err := CompileGrepPattern("match", false, false, false)
+		if err != nil {
+			t.Fatalf("Failed to compile pattern: %s", err)
+		}
+
+		logGrepWriter := NewLogGrepWriter(matcher, testCase.before, testCase.after, &whatLogWriter{&output}, &output)
+
+		for second, what := range whats {
+			logRecord := NewLogRecord(fmt.Sprintf(`{"when":"2023-01-02T10:00:%02d.000","who":"api","severity":"info","what":"%s"}`,
+				second,
+				what))
+
+			if err := logGrepWriter.Write(logRecord); err != nil {
+				t.Fatalf("Failed to write: %s", err)
+			}
+		}
+
+		if output.String() != testCase.expectedOutput {
+			t.Errorf("Expected -B %d -A %d to write %q, got %q",
+				testCase.before,
+				testCase.after,
+				testCase.expectedOutput,
+				output.String())
+		}
+
+		if logGrepWriter.GetMatchCount() != 3 {
+			t.Errorf("Expected 3 matches, got %d", logGrepWriter.GetMatchCount())
+		}
+	}
+}
+
+func TestLogGrepWriterWithoutSeparators(t *testing.T) {
+	var output bytes.Buffer
+
+	matcher, err := CompileGrepPattern("MATCH", false, true, true)
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %s", err)
+	}
+
+	// without a separator writer (e.g. when writing JSON) groups aren't separated
+	logGrepWriter := NewLogGrepWriter(matcher, 0, 0, &whatLogWriter{&output}, nil)
+
+	for _, what := range []string{"match 1", "matches", "a", "b", "Match 2"} {
+		logRecord := NewLogRecord(`{"when":"2023-01-02T10:00:00.000","who":"api","severity":"info","what":"` + what + `"}`)
+
+		if err := logGrepWriter.Write(logRecord); err != nil {
+			t.Fatalf("Failed to write: %s", err)
+		}
+	}
+
+	if output.String() != "match 1\nMatch 2\n" {
+		t.Fatalf("Expected the whole word matches, got %q", output.String())
+	}
+}
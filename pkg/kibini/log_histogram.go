@@ -0,0 +1,522 @@
+package kibini
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mgutz/ansi"
+	"github.com/nuclio/errors"
+)
+
+// logHistogramMaxBuckets is the most buckets a snapshot may have (e.g. a 1ms interval over an hour is too many)
+const logHistogramMaxBuckets = 100000
+
+// HistogramChart determines how a histogram is drawn in the terminal
+type HistogramChart int
+
+const (
+	HistogramChartSparklines HistogramChart = iota
+	HistogramChartBars
+)
+
+// HistogramFormat determines how a histogram is written
+type HistogramFormat int
+
+const (
+	HistogramFormatChart HistogramFormat = iota
+	HistogramFormatJSON
+	HistogramFormatCSV
+)
+
+// HistogramOptions determine how records are bucketed and how the histogram is written
+type HistogramOptions struct {
+	InputOptions
+
+	// Interval is the time span of each bucket
+	Interval time.Duration
+
+	// By is the field (see LogRecord.GetField) whose values break down each bucket (e.g. severity, who)
+	By string
+
+	// Chart is used when Format is HistogramFormatChart
+	Chart HistogramChart
+
+	// Format is HistogramFormatChart, HistogramFormatJSON or HistogramFormatCSV
+	Format HistogramFormat
+
+	// ColorSetting is one of "on" (color when outputting to a tty), "off" or "always"
+	ColorSetting string
+
+	// Width is the maximum width of sparklines and bars
+	Width int
+
+	// RefreshInterval is how often the chart is redrawn while following
+	RefreshInterval time.Duration
+}
+
+// LogHistogramSnapshot holds the counts of a histogram at some point in time. Every series has a count for
+// each of the times, which are consecutive
+type LogHistogramSnapshot struct {
+	Interval time.Duration
+	By       string
+	Times    []time.Time
+	Totals   []int
+	Series   []*LogHistogramSeries
+}
+
+// LogHistogramSeries holds the counts of the records with some value of the histogram's field
+type LogHistogramSeries struct {
+	Name   string
+	Counts []int
+	Total  int
+}
+
+// Histogram writes a histogram of the records to stdout. While following, the chart is redrawn periodically
+func (k *Kibini) Histogram(options *HistogramOptions) error {
+	if options.Interval <= 0 {
+		return errors.New("Interval must be positive")
+	}
+
+	if options.Format == HistogramFormatChart && options.Width <= 0 {
+		return errors.New("Width must be positive")
+	}
+
+	if options.InputFollow && options.Format != HistogramFormatChart {
+		return errors.New("Exporting a histogram requires finished logs (don't follow)")
+	}
+
+	if options.InputFollow && options.RefreshInterval <= 0 {
+		return errors.New("Refresh interval must be positive")
+	}
+
+	logHistogram, err := NewLogHistogram(options.By, options.Interval)
+	if err != nil {
+		return errors.Wrap(err, "Failed to create histogram")
+	}
+
+	histogramWriter := histogramWriter{
+		writer: os.Stdout,
+		color:  k.determineColorSetting(options.ColorSetting, true),
+		width:  options.Width,
+		chart:  options.Chart,
+	}
+
+	writeSnapshot := func() error {
+		snapshot, err := logHistogram.GetSnapshot()
+		if err != nil {
+			return errors.Wrap(err, "Failed to get snapshot")
+		}
+
+		return histogramWriter.write(snapshot, options.Format)
+	}
+
+	if !options.InputFollow {
+		if err := k.ReadLogs(&options.InputOptions, []LogWriter{logHistogram}); err != nil {
+			return errors.Wrap(err, "Failed to read logs")
+		}
+
+		return writeSnapshot()
+	}
+
+	// read in the background and redraw until reading stops
+	readErrors := make(chan error, 1)
+	go func() {
+		readErrors <- k.ReadLogs(&options.InputOptions, []LogWriter{logHistogram})
+	}()
+
+	refreshTicker := time.NewTicker(options.RefreshInterval)
+	defer refreshTicker.Stop()
+
+	for {
+		select {
+		case err := <-readErrors:
+			if err != nil {
+				return errors.Wrap(err, "Failed to read logs")
+			}
+
+			return writeSnapshot()
+		case <-refreshTicker.C:
+
+			// move home and clear the screen before each redraw
+			if _, err := io.WriteString(os.Stdout, "\x1b[H\x1b[2J"); err != nil {
+				return errors.Wrap(err, "Failed to clear screen")
+			}
+
+			if err := writeSnapshot(); err != nil {
+				return errors.Wrap(err, "Failed to write histogram")
+			}
+		}
+	}
+}
+
+// LogHistogram is a LogWriter which counts records in time buckets, by the values of a field. It can be
+// written to and read from concurrently
+type LogHistogram struct {
+	lock     sync.Mutex
+	by       string
+	interval time.Duration
+	counts   map[string]map[time.Time]int
+	first    time.Time
+	last     time.Time
+}
+
+// NewLogHistogram creates a LogHistogram which counts records in buckets of interval, by the values of the
+// given field (see LogRecord.GetField)
+func NewLogHistogram(by string, interval time.Duration) (*LogHistogram, error) {
+	if !IsValidFieldName(by) {
+		return nil, errors.New(fmt.Sprintf("Unknown field: %s (expected a record field or more.<key>)", by))
+	}
+
+	return &LogHistogram{
+		by:       by,
+		interval: interval,
+		counts:   map[string]map[time.Time]int{},
+	}, nil
+}
+
+func (lh *LogHistogram) Write(logRecord *LogRecord) error {
+	value, _ := logRecord.GetField(lh.by)
+	seriesName := flattenValue(value)
+	bucketTime := logRecord.When.Truncate(lh.interval)
+
+	lh.lock.Lock()
+	defer lh.lock.Unlock()
+
+	seriesCounts, found := lh.counts[seriesName]
+	if !found {
+		seriesCounts = map[time.Time]int{}
+		lh.counts[seriesName] = seriesCounts
+	}
+
+	seriesCounts[bucketTime]++
+
+	if lh.first.IsZero() || bucketTime.Before(lh.first) {
+		lh.first = bucketTime
+	}
+
+	if bucketTime.After(lh.last) {
+		lh.last = bucketTime
+	}
+
+	return nil
+}
+
+// GetSnapshot returns the current counts, for every bucket from the first record to the last. Fails if there
+// are too many buckets (see logHistogramMaxBuckets)
+func (lh *LogHistogram) GetSnapshot() (*LogHistogramSnapshot, error) {
+	lh.lock.Lock()
+	defer lh.lock.Unlock()
+
+	snapshot := LogHistogramSnapshot{
+		Interval: lh.interval,
+		By:       lh.by,
+	}
+
+	if len(lh.counts) == 0 {
+		return &snapshot, nil
+	}
+
+	if buckets := int64(lh.last.Sub(lh.first)/lh.interval) + 1; buckets > logHistogramMaxBuckets {
+		return nil, errors.New(fmt.Sprintf("The records span %d buckets of %s, more than %d (use a larger interval)",
+			buckets,
+			lh.interval,
+			logHistogramMaxBuckets))
+	}
+
+	for bucketTime := lh.first; !bucketTime.After(lh.last); bucketTime = bucketTime.Add(lh.interval) {
+		snapshot.Times = append(snapshot.Times, bucketTime)
+	}
+
+	snapshot.Totals = make([]int, len(snapshot.Times))
+
+	seriesNames := make([]string, 0, len(lh.counts))
+	for seriesName := range lh.counts {
+		seriesNames = append(seriesNames, seriesName)
+	}
+
+	sort.Strings(seriesNames)
+	if lh.by == "severity" {
+		sortSeverities(seriesNames)
+	}
+
+	for _, seriesName := range seriesNames {
+		series := LogHistogramSeries{
+			Name:   seriesName,
+			Counts: make([]int, len(snapshot.Times)),
+		}
+
+		for bucketIndex, bucketTime := range snapshot.Times {
+			series.Counts[bucketIndex] = lh.counts[seriesName][bucketTime]
+			series.Total += series.Counts[bucketIndex]
+			snapshot.Totals[bucketIndex] += series.Counts[bucketIndex]
+		}
+
+		snapshot.Series = append(snapshot.Series, &series)
+	}
+
+	return &snapshot, nil
+}
+
+// histogramWriter writes histogram snapshots
+type histogramWriter struct {
+	writer io.Writer
+	color  bool
+	width  int
+	chart  HistogramChart
+}
+
+var sparklineLevels = []rune("▁▂▃▄▅▆▇█")
+
+// series are told apart by color or, without color, by the character their bars are drawn with
+var histogramSeriesColors = []string{ansi.Cyan, ansi.Magenta, ansi.Green, ansi.Yellow, ansi.Blue, ansi.Red}
+var histogramSeriesCharacters = []string{"#", "=", "+", "*", "%", "@", "-", "~"}
+
+func (hw *histogramWriter) write(snapshot *LogHistogramSnapshot, format HistogramFormat) error {
+	switch format {
+	case HistogramFormatJSON:
+		return hw.writeJSON(snapshot)
+	case HistogramFormatCSV:
+		return hw.writeCSV(snapshot)
+	}
+
+	if len(snapshot.Times) == 0 {
+		_, err := fmt.Fprintln(hw.writer, "No records")
+		return err
+	}
+
+	if hw.chart == HistogramChartBars {
+		return hw.writeBars(snapshot)
+	}
+
+	return hw.writeSparklines(snapshot)
+}
+
+// writeSparklines writes a sparkline per series, each scaled to its own maximum. If there are more buckets
+// than fit, the latest are written
+func (hw *histogramWriter) writeSparklines(snapshot *LogHistogramSnapshot) error {
+	firstBucketIndex := 0
+	if len(snapshot.Times) > hw.width {
+		firstBucketIndex = len(snapshot.Times) - hw.width
+	}
+
+	if _, err := fmt.Fprintf(hw.writer, "%s - %s, %d buckets of %s by %s\n",
+		snapshot.Times[firstBucketIndex].Format("2006-01-02T15:04:05"),
+		snapshot.Times[len(snapshot.Times)-1].Add(snapshot.Interval).Format("2006-01-02T15:04:05"),
+		len(snapshot.Times)-firstBucketIndex,
+		snapshot.Interval,
+		snapshot.By); err != nil {
+		return err
+	}
+
+	nameWidth := hw.getNameWidth(snapshot)
+	series := append(snapshot.Series, &LogHistogramSeries{Name: "total", Counts: snapshot.Totals})
+
+	for seriesIndex, oneSeries := range series {
+		counts := oneSeries.Counts[firstBucketIndex:]
+
+		maxCount := 0
+		total := 0
+		for _, count := range counts {
+			total += count
+
+			if count > maxCount {
+				maxCount = count
+			}
+		}
+
+		var sparkline strings.Builder
+		for _, count := range counts {
+			switch {
+			case count == 0:
+				sparkline.WriteRune(' ')
+			default:
+				sparkline.WriteRune(sparklineLevels[(count*len(sparklineLevels)-1)/maxCount])
+			}
+		}
+
+		if _, err := fmt.Fprintf(hw.writer, "%s %s %7d (max %d)\n",
+			fitString(oneSeries.Name, nameWidth),
+			hw.colorize(sparkline.String(), hw.getSeriesColor(snapshot, seriesIndex)),
+			total,
+			maxCount); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeBars writes a stacked bar per bucket, scaled to the largest bucket
+func (hw *histogramWriter) writeBars(snapshot *LogHistogramSnapshot) error {
+	maxTotal := 0
+	for _, total := range snapshot.Totals {
+		if total > maxTotal {
+			maxTotal = total
+		}
+	}
+
+	// the legend tells series apart
+	var legend []string
+	for seriesIndex, series := range snapshot.Series {
+		legend = append(legend, hw.colorize(hw.getSeriesCharacter(seriesIndex), hw.getSeriesColor(snapshot, seriesIndex))+" "+series.Name)
+	}
+
+	if _, err := fmt.Fprintf(hw.writer, "%s by %s: %s\n", snapshot.Interval, snapshot.By, strings.Join(legend, "  ")); err != nil {
+		return err
+	}
+
+	for bucketIndex, bucketTime := range snapshot.Times {
+		var bar strings.Builder
+		var breakdown []string
+
+		for seriesIndex, series := range snapshot.Series {
+			count := series.Counts[bucketIndex]
+			if count == 0 {
+				continue
+			}
+
+			// any count gets at least one character
+			segmentWidth := count * hw.width / maxTotal
+			if segmentWidth == 0 {
+				segmentWidth = 1
+			}
+
+			bar.WriteString(hw.colorize(strings.Repeat(hw.getSeriesCharacter(seriesIndex), segmentWidth),
+				hw.getSeriesColor(snapshot, seriesIndex)))
+
+			breakdown = append(breakdown, fmt.Sprintf("%s:%d", series.Name, count))
+		}
+
+		if _, err := fmt.Fprintf(hw.writer, "%s %6d %s %s\n",
+			bucketTime.Format("2006-01-02T15:04:05"),
+			snapshot.Totals[bucketIndex],
+			bar.String(),
+			strings.Join(breakdown, " ")); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (hw *histogramWriter) writeJSON(snapshot *LogHistogramSnapshot) error {
+	type jsonBucket struct {
+		Time   string         `json:"time"`
+		Total  int            `json:"total"`
+		Counts map[string]int `json:"counts"`
+	}
+
+	jsonHistogram := struct {
+		Interval string       `json:"interval"`
+		By       string       `json:"by"`
+		Buckets  []jsonBucket `json:"buckets"`
+	}{
+		Interval: snapshot.Interval.String(),
+		By:       snapshot.By,
+		Buckets:  []jsonBucket{},
+	}
+
+	for bucketIndex, bucketTime := range snapshot.Times {
+		bucket := jsonBucket{
+			Time:   bucketTime.Format(time.RFC3339Nano),
+			Total:  snapshot.Totals[bucketIndex],
+			Counts: map[string]int{},
+		}
+
+		for _, series := range snapshot.Series {
+			if series.Counts[bucketIndex] != 0 {
+				bucket.Counts[series.Name] = series.Counts[bucketIndex]
+			}
+		}
+
+		jsonHistogram.Buckets = append(jsonHistogram.Buckets, bucket)
+	}
+
+	encoder := json.NewEncoder(hw.writer)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(jsonHistogram)
+}
+
+func (hw *histogramWriter) writeCSV(snapshot *LogHistogramSnapshot) error {
+	csvWriter := csv.NewWriter(hw.writer)
+
+	header := []string{"time", "total"}
+	for _, series := range snapshot.Series {
+		header = append(header, series.Name)
+	}
+
+	if err := csvWriter.Write(header); err != nil {
+		return err
+	}
+
+	for bucketIndex, bucketTime := range snapshot.Times {
+		row := []string{bucketTime.Format(time.RFC3339Nano), strconv.Itoa(snapshot.Totals[bucketIndex])}
+
+		for _, series := range snapshot.Series {
+			row = append(row, strconv.Itoa(series.Counts[bucketIndex]))
+		}
+
+		if err := csvWriter.Write(row); err != nil {
+			return err
+		}
+	}
+
+	csvWriter.Flush()
+
+	return csvWriter.Error()
+}
+
+func (hw *histogramWriter) getNameWidth(snapshot *LogHistogramSnapshot) int {
+	nameWidth := len("total")
+
+	for _, series := range snapshot.Series {
+		if len(series.Name) > nameWidth {
+			nameWidth = len(series.Name)
+		}
+	}
+
+	return nameWidth
+}
+
+// getSeriesColor returns the color of a series. Severities get their usual colors
+func (hw *histogramWriter) getSeriesColor(snapshot *LogHistogramSnapshot, seriesIndex int) string {
+	if seriesIndex >= len(snapshot.Series) {
+		return ansi.Reset
+	}
+
+	if snapshot.By == "severity" && len(snapshot.Series[seriesIndex].Name) != 0 {
+		var humanReadableFormatter HumanReadableFormatter
+
+		if severityColor := humanReadableFormatter.getSeverityColor(snapshot.Series[seriesIndex].Name[0]); severityColor != ansi.Reset {
+			return severityColor
+		}
+	}
+
+	return histogramSeriesColors[seriesIndex%len(histogramSeriesColors)]
+}
+
+// getSeriesCharacter returns the character a series' bars are drawn with. With color, all are drawn alike
+func (hw *histogramWriter) getSeriesCharacter(seriesIndex int) string {
+	if hw.color {
+		return "█"
+	}
+
+	return histogramSeriesCharacters[seriesIndex%len(histogramSeriesCharacters)]
+}
+
+func (hw *histogramWriter) colorize(s string, color string) string {
+	if !hw.color {
+		return s
+	}
+
+	return color + s + ansi.Reset
+}
@@ -0,0 +1,49 @@
+package kibini
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLogHistogramSnapshot(t *testing.T) {
+	logHistogram, err := NewLogHistogram("severity", time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to create histogram: %s", err)
+	}
+
+	for _, line := range []string{
+		`{"when":"2023-01-02T10:00:01.000","who":"api","severity":"info","what":"x"}`,
+		`{"when":"2023-01-02T10:00:59.000","who":"api","severity":"error","what":"x"}`,
+		`{"when":"2023-01-02T10:03:00.000","who":"api","severity":"info","what":"x"}`,
+	} {
+		logHistogram.Write(NewLogRecord(line)) // nolint: errcheck
+	}
+
+	snapshot, err := logHistogram.GetSnapshot()
+	if err != nil {
+		t.Fatalf("Failed to get snapshot: %s", err)
+	}
+
+	// empty buckets in between are included
+	if len(snapshot.Times) != 4 || len(snapshot.Series) != 2 {
+		t.Fatalf("Expected 4 buckets of 2 series, got %d buckets of %d series", len(snapshot.Times), len(snapshot.Series))
+	}
+
+	if totals := snapshot.Totals; totals[0] != 2 || totals[1] != 0 || totals[3] != 1 {
+		t.Fatalf("Unexpected totals: %v", totals)
+	}
+}
+
+func TestLogHistogramTooManyBuckets(t *testing.T) {
+	logHistogram, err := NewLogHistogram("severity", time.Millisecond)
+	if err != nil {
+		t.Fatalf("Failed to create histogram: %s", err)
+	}
+
+	logHistogram.Write(NewLogRecord(`{"when":"2023-01-02T10:00:00.000","who":"api","severity":"info","what":"x"}`)) // nolint: errcheck
+	logHistogram.Write(NewLogRecord(`{"when":"2023-01-03T10:00:00.000","who":"api","severity":"info","what":"x"}`)) // nolint: errcheck
+
+	if _, err := logHistogram.GetSnapshot(); err == nil {
+		t.Fatal("Expected a day of 1ms buckets to be rejected")
+	}
+}
@@ -0,0 +1,96 @@
+package kibini
+
+import (
+	"bytes"
+	_ "embed" // for the report header
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"regexp"
+)
+
+//go:embed assets/html_report_header.html
+var htmlReportHeader string
+
+const htmlReportFooter = "</tbody>\n</table>\n</body>\n</html>\n"
+
+var htmlRecordTemplate = template.Must(template.New("record").Parse(
+	`<tr id="{{.Anchor}}" class="record sev-{{.SeverityCode}}" data-who="{{.Who}}" data-severity="{{.Severity}}">` +
+		`<td class="when"><a href="#{{.Anchor}}">{{.When}}</a></td>` +
+		`<td class="who" title="{{.Source}}:{{.Line}}">{{.Who}}</td>` +
+		`<td class="severity">{{.SeverityCode}}</td>` +
+		`<td class="what">{{.What}}{{if .Ctx}} <span class="ctx">ctx: {{.Ctx}}</span>{{end}}` +
+		`{{if .More}}<details><summary>more</summary><pre>{{.More}}</pre></details>{{end}}</td>` +
+		"</tr>\n"))
+
+var htmlAnchorInvalidCharacters = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+// HTMLFormatter formats log records as rows of a self contained HTML report (inline CSS/JS) which supports
+// filtering by who/severity/text, collapsible "more" and links to records
+type HTMLFormatter struct {
+	recordIndex int
+}
+
+// NewHTMLFormatter creates an HTMLFormatter
+func NewHTMLFormatter() *HTMLFormatter {
+	return &HTMLFormatter{}
+}
+
+// FormatHeader returns the beginning of the document, up to the first record
+func (hf *HTMLFormatter) FormatHeader() string {
+	return htmlReportHeader
+}
+
+// FormatFooter returns the end of the document
+func (hf *HTMLFormatter) FormatFooter() string {
+	return htmlReportFooter
+}
+
+// Format returns the table row of the log record
+func (hf *HTMLFormatter) Format(logRecord *LogRecord) string {
+	var formatted bytes.Buffer
+	var more string
+
+	hf.recordIndex++
+
+	if len(logRecord.More) != 0 {
+		marshalledMore, err := json.MarshalIndent(logRecord.GetTypedMore(), "", "    ")
+		if err != nil {
+			more = fmt.Sprintf("<Error formatting more: %s>", err)
+		} else {
+			more = string(marshalledMore)
+		}
+	}
+
+	severityCode := "?"
+	if len(logRecord.Severity) != 0 {
+		severityCode = logRecord.Severity[:1]
+	}
+
+	if err := htmlRecordTemplate.Execute(&formatted, map[string]interface{}{
+		"Anchor":       hf.getAnchor(logRecord),
+		"When":         logRecord.When.Format("2006-01-02 15:04:05.000000"),
+		"Source":       logRecord.SourceFile,
+		"Line":         logRecord.LineNumber,
+		"Who":          logRecord.Who,
+		"Severity":     logRecord.Severity,
+		"SeverityCode": severityCode,
+		"What":         logRecord.What,
+		"Ctx":          logRecord.Ctx,
+		"More":         more,
+	}); err != nil {
+		return fmt.Sprintf("<tr><td colspan=\"4\">Error formatting record: %s</td></tr>\n",
+			template.HTMLEscapeString(err.Error()))
+	}
+
+	return formatted.String()
+}
+
+// getAnchor returns an id which is stable across reports of the same logs (source and line)
+func (hf *HTMLFormatter) getAnchor(logRecord *LogRecord) string {
+	if len(logRecord.SourceFile) == 0 {
+		return fmt.Sprintf("r%d", hf.recordIndex)
+	}
+
+	return htmlAnchorInvalidCharacters.ReplaceAllString(fmt.Sprintf("%s-%d", logRecord.SourceFile, logRecord.LineNumber), "_")
+}
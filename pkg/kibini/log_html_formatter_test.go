@@ -0,0 +1,52 @@
+package kibini
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestHTMLFormatterEscapes(t *testing.T) {
+	logRecord := NewLogRecord(`{"when":"2023-01-02T10:00:01.000","who":"<b>api</b>\" onclick=\"x","severity":"info",` +
+		`"what":"<script>alert('what')</script> & more","ctx":"<i>ctx</i>",` +
+		`"more":{"html":"</pre><script>alert(1)</script>"}}`)
+	logRecord.SourceFile = "api <1>.log"
+	logRecord.LineNumber = 3
+
+	formatted := NewHTMLFormatter().Format(logRecord)
+
+	for _, unescaped := range []string{"<b>", "<script>", "</pre><script>", "<i>", `" onclick="`, "api <1>"} {
+		if strings.Contains(formatted, unescaped) {
+			t.Errorf("Expected %q to be escaped, got %s", unescaped, formatted)
+		}
+	}
+
+	for _, escaped := range []string{
+		`&lt;b&gt;api&lt;/b&gt;&#34; onclick=&#34;x`,
+		`&lt;script&gt;alert(&#39;what&#39;)&lt;/script&gt; &amp; more`,
+		`ctx: &lt;i&gt;ctx&lt;/i&gt;`,
+		`&#34;html&#34;: &#34;\u003c/pre\u003e\u003cscript\u003ealert(1)\u003c/script\u003e&#34;`,
+		`id="api__1_.log-3"`,
+	} {
+		if !strings.Contains(formatted, escaped) {
+			t.Errorf("Expected %q in %s", escaped, formatted)
+		}
+	}
+}
+
+func TestHTMLFormatterWithoutRecords(t *testing.T) {
+	var output bytes.Buffer
+
+	if err := NewLogFormattedWriter(newTestLogger(t), NewHTMLFormatter(), &output).Close(); err != nil {
+		t.Fatalf("Failed to close: %s", err)
+	}
+
+	// an empty report is still a whole document
+	if !strings.HasPrefix(output.String(), htmlReportHeader) || !strings.HasSuffix(output.String(), htmlReportFooter) {
+		t.Fatalf("Expected the header and the footer, got %q", output.String())
+	}
+
+	if strings.Contains(output.String(), `class="record`) {
+		t.Fatalf("Expected no records, got %q", output.String())
+	}
+}
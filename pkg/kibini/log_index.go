@@ -0,0 +1,507 @@
+package kibini
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/gob"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/nuclio/errors"
+)
+
+// LogIndexFileExtension is appended to the path of a log file to get the path of its index
+const LogIndexFileExtension = ".kibini-index"
+
+// bumped whenever the index format changes, so that old indexes are rebuilt
+const logIndexVersion = 1
+
+// IndexOptions determine how log file indexes are built
+type IndexOptions struct {
+
+	// BucketInterval is the time span of a bucket. Records are read (or skipped) a bucket at a time
+	BucketInterval time.Duration
+
+	// BucketMaxRecords starts a new bucket once a bucket has this many records, even within the interval
+	BucketMaxRecords int
+
+	// Tokens indexes the words of each record (see LogRecord.containsText) so that text filters can skip
+	// buckets. The index is much larger with tokens
+	Tokens bool
+
+	// Force rebuilds indexes which are fresh
+	Force bool
+}
+
+// LogIndexSummary describes the index of a log file
+type LogIndexSummary struct {
+	FileName string
+	Lines    int
+	Records  int
+	Buckets  int
+	Tokens   int
+	Rebuilt  bool
+}
+
+// logIndex is the sidecar index of a log file. It maps buckets of records to their byte ranges, along with what
+// can be found in each bucket, so that readers can skip buckets which can't match a filter
+type logIndex struct {
+	Version       int
+	SourceSize    int64
+	SourceModTime int64
+	Lines         int
+	Records       int
+	Severities    []string
+	Whos          []string
+	Buckets       []logIndexBucket
+
+	// bucket indexes (ascending) of each token. nil if the index was built without tokens
+	Tokens map[string][]int
+}
+
+type logIndexBucket struct {
+	Offset     int64
+	Length     int64
+	FirstLine  int
+	Records    int
+	MinWhen    int64
+	MaxWhen    int64
+	Severities logIndexBitmap
+	Whos       logIndexBitmap
+}
+
+// logIndexBitmap is a set of indexes into one of the index's dictionaries (severities or whos)
+type logIndexBitmap []uint64
+
+func (lib *logIndexBitmap) set(bit int) {
+	for len(*lib) <= bit/64 {
+		*lib = append(*lib, 0)
+	}
+
+	(*lib)[bit/64] |= 1 << uint(bit%64)
+}
+
+func (lib logIndexBitmap) intersects(other logIndexBitmap) bool {
+	for wordIndex := 0; wordIndex < len(lib) && wordIndex < len(other); wordIndex++ {
+		if lib[wordIndex]&other[wordIndex] != 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IndexLogs builds the indexes of the log files selected by the input options. Indexes which are fresh are
+// kept unless options.Force is set
+func (k *Kibini) IndexLogs(inputOptions *InputOptions, options *IndexOptions) ([]*LogIndexSummary, error) {
+	inputFileNames, err := k.getInputFileNames(inputOptions)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to get input file names")
+	}
+
+	var summaries []*LogIndexSummary
+
+	for _, inputFileName := range inputFileNames {
+		inputFilePath := filepath.Join(inputOptions.InputPath, inputFileName)
+		rebuilt := false
+
+		index := loadFreshLogIndex(inputFilePath)
+		if index == nil || options.Force {
+			k.logger.DebugWith("Building index", "inputFilePath", inputFilePath)
+
+			if index, err = buildLogIndex(inputFilePath, options); err != nil {
+				return nil, errors.Wrapf(err, "Failed to build index of %s", inputFileName)
+			}
+
+			if err := index.save(inputFilePath + LogIndexFileExtension); err != nil {
+				return nil, errors.Wrapf(err, "Failed to save index of %s", inputFileName)
+			}
+
+			rebuilt = true
+		}
+
+		summaries = append(summaries, &LogIndexSummary{
+			FileName: inputFileName,
+			Lines:    index.Lines,
+			Records:  index.Records,
+			Buckets:  len(index.Buckets),
+			Tokens:   len(index.Tokens),
+			Rebuilt:  rebuilt,
+		})
+	}
+
+	return summaries, nil
+}
+
+// buildLogIndex reads the log file and indexes its records
+func buildLogIndex(inputFilePath string, options *IndexOptions) (*logIndex, error) {
+	if options.BucketInterval <= 0 {
+		return nil, errors.New("Bucket interval must be positive")
+	}
+
+	inputFile, err := os.Open(inputFilePath)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to open file")
+	}
+
+	defer inputFile.Close() // nolint: errcheck
+
+	// the index is of the file as it is now - if it's written to while indexing, the index will be stale
+	fileInfo, err := inputFile.Stat()
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to stat file")
+	}
+
+	index := logIndex{
+		Version:       logIndexVersion,
+		SourceSize:    fileInfo.Size(),
+		SourceModTime: fileInfo.ModTime().UnixNano(),
+	}
+
+	if options.Tokens {
+		index.Tokens = map[string][]int{}
+	}
+
+	severityIndexes := map[string]int{}
+	whoIndexes := map[string]int{}
+
+	var bucket *logIndexBucket
+	var bucketStart time.Time
+	var offset int64
+
+	reader := bufio.NewReader(io.LimitReader(inputFile, index.SourceSize))
+
+	for {
+		line, readErr := reader.ReadString('\n')
+		if len(line) == 0 && readErr != nil {
+			if readErr == io.EOF {
+				break
+			}
+
+			return nil, errors.Wrap(readErr, "Failed to read file")
+		}
+
+		index.Lines++
+		logRecord := NewLogRecord(strings.TrimRight(line, "\n"))
+
+		// start a new bucket when a record falls outside the current one's interval, or it's full
+		if bucket == nil || (logRecord != nil &&
+			(!logRecord.When.Truncate(options.BucketInterval).Equal(bucketStart) ||
+				(options.BucketMaxRecords != 0 && bucket.Records >= options.BucketMaxRecords))) {
+			index.Buckets = append(index.Buckets, logIndexBucket{
+				Offset:    offset,
+				FirstLine: index.Lines,
+			})
+
+			bucket = &index.Buckets[len(index.Buckets)-1]
+
+			if logRecord != nil {
+				bucketStart = logRecord.When.Truncate(options.BucketInterval)
+			}
+		}
+
+		offset += int64(len(line))
+		bucket.Length += int64(len(line))
+
+		if logRecord == nil {
+			continue
+		}
+
+		index.Records++
+
+		if bucket.Records == 0 || logRecord.WhenUnixNano < bucket.MinWhen {
+			bucket.MinWhen = logRecord.WhenUnixNano
+		}
+
+		if bucket.Records == 0 || logRecord.WhenUnixNano > bucket.MaxWhen {
+			bucket.MaxWhen = logRecord.WhenUnixNano
+		}
+
+		bucket.Records++
+		bucket.Severities.set(getDictionaryIndex(&index.Severities, severityIndexes, logRecord.Severity))
+		bucket.Whos.set(getDictionaryIndex(&index.Whos, whoIndexes, logRecord.Who))
+
+		if index.Tokens != nil {
+			bucketIndex := len(index.Buckets) - 1
+
+			logRecord.forEachTextToken(func(token string) {
+				bucketIndexes := index.Tokens[token]
+				if len(bucketIndexes) == 0 || bucketIndexes[len(bucketIndexes)-1] != bucketIndex {
+					index.Tokens[token] = append(bucketIndexes, bucketIndex)
+				}
+			})
+		}
+	}
+
+	return &index, nil
+}
+
+// loadFreshLogIndex loads the index of a log file. Returns nil if there's no index, or if the log file changed
+// since it was indexed
+func loadFreshLogIndex(inputFilePath string) *logIndex {
+	fileInfo, err := os.Stat(inputFilePath)
+	if err != nil {
+		return nil
+	}
+
+	indexFile, err := os.Open(inputFilePath + LogIndexFileExtension)
+	if err != nil {
+		return nil
+	}
+
+	defer indexFile.Close() // nolint: errcheck
+
+	gzipReader, err := gzip.NewReader(indexFile)
+	if err != nil {
+		return nil
+	}
+
+	index := logIndex{}
+	if err := gob.NewDecoder(gzipReader).Decode(&index); err != nil {
+		return nil
+	}
+
+	if index.Version != logIndexVersion ||
+		index.SourceSize != fileInfo.Size() ||
+		index.SourceModTime != fileInfo.ModTime().UnixNano() {
+		return nil
+	}
+
+	return &index
+}
+
+func (li *logIndex) save(indexFilePath string) error {
+
+	// write to a temporary file and rename, so that readers never see a partial index
+	temporaryIndexFilePath := indexFilePath + ".tmp"
+
+	indexFile, err := os.Create(temporaryIndexFilePath)
+	if err != nil {
+		return errors.Wrap(err, "Failed to create index file")
+	}
+
+	gzipWriter, _ := gzip.NewWriterLevel(indexFile, gzip.BestSpeed)
+
+	if err := gob.NewEncoder(gzipWriter).Encode(li); err != nil {
+		indexFile.Close() // nolint: errcheck
+		return errors.Wrap(err, "Failed to encode index")
+	}
+
+	if err := gzipWriter.Close(); err != nil {
+		indexFile.Close() // nolint: errcheck
+		return errors.Wrap(err, "Failed to compress index")
+	}
+
+	if err := indexFile.Close(); err != nil {
+		return errors.Wrap(err, "Failed to close index file")
+	}
+
+	return os.Rename(temporaryIndexFilePath, indexFilePath)
+}
+
+// getCandidateBuckets returns the buckets which may hold records that match the filter. Constraints which
+// the index can't evaluate are ignored, so records read from these buckets must still be matched
+func (li *logIndex) getCandidateBuckets(logFilter LogFilter) []*logIndexBucket {
+	constraints := logIndexConstraints{}
+	constraints.add(logFilter)
+
+	severities := li.getDictionaryBitmap(li.Severities, constraints.severities)
+	whos := li.getDictionaryBitmap(li.Whos, constraints.whos)
+	tokenBuckets := li.getTokenBuckets(constraints.texts)
+
+	var candidateBuckets []*logIndexBucket
+
+	for bucketIndex := range li.Buckets {
+		bucket := &li.Buckets[bucketIndex]
+
+		if bucket.Records == 0 ||
+			(constraints.from != nil && bucket.MaxWhen < *constraints.from) ||
+			(constraints.to != nil && bucket.MinWhen >= *constraints.to) ||
+			(severities != nil && !bucket.Severities.intersects(severities)) ||
+			(whos != nil && !bucket.Whos.intersects(whos)) ||
+			(tokenBuckets != nil && !tokenBuckets[bucketIndex]) {
+			continue
+		}
+
+		candidateBuckets = append(candidateBuckets, bucket)
+	}
+
+	return candidateBuckets
+}
+
+// getDictionaryBitmap returns a bitmap of the dictionary entries which are in any of the value sets (ignoring
+// case). Every value set must be matched. Returns nil if there are no value sets
+func (li *logIndex) getDictionaryBitmap(dictionary []string, valueSets [][]string) logIndexBitmap {
+	if len(valueSets) == 0 {
+		return nil
+	}
+
+	bitmap := logIndexBitmap{}
+
+	for dictionaryIndex, entry := range dictionary {
+		matchesAll := true
+
+		for _, values := range valueSets {
+			matchesAll = matchesAll && containsFold(values, entry)
+		}
+
+		if matchesAll {
+			bitmap.set(dictionaryIndex)
+		}
+	}
+
+	return bitmap
+}
+
+// getTokenBuckets returns the indexes of the buckets which may contain all the texts, or nil if there are no
+// texts or the index has no tokens
+func (li *logIndex) getTokenBuckets(texts []string) map[int]bool {
+	if len(texts) == 0 || li.Tokens == nil {
+		return nil
+	}
+
+	var candidateBuckets map[int]bool
+
+	for _, text := range texts {
+		lowerText := strings.ToLower(text)
+		textTokens := getTextTokens(lowerText)
+
+		for textTokenIndex, textToken := range textTokens {
+
+			// the text may start or end in the middle of a word, so its first and last tokens may only be part
+			// of an indexed token. the ones in between are whole
+			partialStart := textTokenIndex == 0 && strings.HasPrefix(lowerText, textToken)
+			partialEnd := textTokenIndex == len(textTokens)-1 && strings.HasSuffix(lowerText, textToken)
+
+			tokenBuckets := map[int]bool{}
+
+			for token, bucketIndexes := range li.Tokens {
+				var matches bool
+
+				switch {
+				case partialStart && partialEnd:
+					matches = strings.Contains(token, textToken)
+				case partialStart:
+					matches = strings.HasSuffix(token, textToken)
+				case partialEnd:
+					matches = strings.HasPrefix(token, textToken)
+				default:
+					matches = token == textToken
+				}
+
+				if matches {
+					for _, bucketIndex := range bucketIndexes {
+						if candidateBuckets == nil || candidateBuckets[bucketIndex] {
+							tokenBuckets[bucketIndex] = true
+						}
+					}
+				}
+			}
+
+			candidateBuckets = tokenBuckets
+		}
+	}
+
+	return candidateBuckets
+}
+
+// logIndexConstraints are the parts of a filter which an index can evaluate. A record must satisfy all of them
+type logIndexConstraints struct {
+	from       *int64
+	to         *int64
+	severities [][]string
+	whos       [][]string
+	texts      []string
+}
+
+func (lic *logIndexConstraints) add(logFilter LogFilter) {
+	switch typedLogFilter := logFilter.(type) {
+	case logFilterAnd:
+		for _, operand := range typedLogFilter {
+			lic.add(operand)
+		}
+
+	case *logFilterText:
+		lic.texts = append(lic.texts, typedLogFilter.lowerText)
+
+	case *logFilterComparison:
+		lic.addComparison(typedLogFilter)
+	}
+}
+
+func (lic *logIndexConstraints) addComparison(comparison *logFilterComparison) {
+	switch {
+	case comparison.field == "severity" && comparison.operator == "=":
+		lic.severities = append(lic.severities, []string{comparison.value})
+
+	case comparison.field == "who" && comparison.operator == "=":
+		lic.whos = append(lic.whos, []string{comparison.value})
+
+	case comparison.field == "ctx" && comparison.operator == "=" && len(comparison.value) != 0:
+		lic.texts = append(lic.texts, comparison.value)
+
+	case comparison.field == "when" && comparison.timeValue != nil:
+		switch whenUnixNano := comparison.timeValue.UnixNano(); comparison.operator {
+		case ">", ">=":
+			lic.setFrom(whenUnixNano)
+		case "<":
+			lic.setTo(whenUnixNano)
+		case "<=":
+			lic.setTo(whenUnixNano + 1)
+		}
+	}
+}
+
+func (lic *logIndexConstraints) setFrom(from int64) {
+	if lic.from == nil || from > *lic.from {
+		lic.from = &from
+	}
+}
+
+func (lic *logIndexConstraints) setTo(to int64) {
+	if lic.to == nil || to < *lic.to {
+		lic.to = &to
+	}
+}
+
+// forEachTextToken calls the callback with the (lowercase) words of everything containsText searches
+func (lr *LogRecord) forEachTextToken(callback func(token string)) {
+	texts := []string{lr.What, lr.Who, lr.Ctx}
+
+	for key, rawValue := range lr.More {
+		texts = append(texts, key)
+
+		if rawValue != nil {
+			texts = append(texts, string(*rawValue))
+		}
+	}
+
+	for _, text := range texts {
+		for _, token := range getTextTokens(strings.ToLower(text)) {
+			callback(token)
+		}
+	}
+}
+
+// getTextTokens splits text into words (runs of letters and digits)
+func getTextTokens(text string) []string {
+	return strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// getDictionaryIndex returns the index of value in the dictionary, adding it if needed
+func getDictionaryIndex(dictionary *[]string, indexes map[string]int, value string) int {
+	if index, found := indexes[value]; found {
+		return index
+	}
+
+	indexes[value] = len(*dictionary)
+	*dictionary = append(*dictionary, value)
+
+	return indexes[value]
+}
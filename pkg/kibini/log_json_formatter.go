@@ -0,0 +1,24 @@
+package kibini
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// JSONFormatter formats log records as JSON lines in the normalized schema
+type JSONFormatter struct{}
+
+// NewJSONFormatter creates a JSONFormatter
+func NewJSONFormatter() *JSONFormatter {
+	return &JSONFormatter{}
+}
+
+// Format returns the normalized log record as a single JSON line
+func (jf *JSONFormatter) Format(logRecord *LogRecord) string {
+	marshalledRecord, err := json.Marshal(logRecord.Normalize())
+	if err != nil {
+		return fmt.Sprintf("{\"error\": %q}\n", "Failed to format record: "+err.Error())
+	}
+
+	return string(marshalledRecord) + "\n"
+}
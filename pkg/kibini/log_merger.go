@@ -1,10 +1,12 @@
-package core
+package kibini
 
 import (
 	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/nuclio/errors"
 	"github.com/nuclio/logger"
 )
 
@@ -12,40 +14,45 @@ import (
 // Object that holds lots of log records and can sort them by time
 //
 
-type logRecordSorter []*logRecord
+type logRecordSorter []*LogRecord
 
 func (lrs logRecordSorter) Len() int           { return len(lrs) }
 func (lrs logRecordSorter) Swap(i, j int)      { lrs[i], lrs[j] = lrs[j], lrs[i] }
 func (lrs logRecordSorter) Less(i, j int) bool { return lrs[i].WhenUnixNano < lrs[j].WhenUnixNano }
 
-//
-// Object which receives log records from many go routines and then after 1 second of inactivity
-// flushes them to the writer, sorted
-//
-
-type logMerger struct {
+// LogMerger receives log records from many go routines and, after a period of inactivity (or once records have
+// been pending for too long), flushes them to its writers sorted by time. Unless it was created to stop by itself
+// (stopAfterFirstFlush / stopAfterFirstQuietPeriod), it runs until Stop is called once nothing more will be
+// written to it
+type LogMerger struct {
 	logger                        logger.Logger
 	waitGroup                     *sync.WaitGroup
 	stopAfterFirstFlush           bool
 	stopAfterFirstQuietPeriod     bool
 	inactivityFlushTimeout        time.Duration
 	forceFlushTimeout             time.Duration
-	writers                       []logWriter
-	incomingRecords               chan *logRecord
+	writers                       []LogWriter
+	incomingRecords               chan *LogRecord
+	stop                          chan struct{}
 	pendingRecords                logRecordSorter
+	pendingRecordCount            int64
+	failedWriteCount              int
+	firstWriteErr                 error
 	newestPendingRecordReceivedAt time.Time
 	oldestPendingRecordReceivedAt time.Time
 }
 
-func newLogMerger(logger logger.Logger,
+// NewLogMerger creates a LogMerger and starts processing incoming records. waitGroup is signaled
+// once the merger stops. An inactivityFlushTimeout of 0 means records are flushed only when the merger is stopped
+func NewLogMerger(logger logger.Logger,
 	waitGroup *sync.WaitGroup,
 	stopAfterFirstFlush bool,
 	stopAfterFirstQuietPeriod bool,
 	inactivityFlushTimeout time.Duration,
 	forceFlushTimeout time.Duration,
-	writers []logWriter) *logMerger {
+	writers []LogWriter) *LogMerger {
 
-	lm := &logMerger{
+	lm := &LogMerger{
 		logger:                        logger.GetChild("merger"),
 		waitGroup:                     waitGroup,
 		stopAfterFirstFlush:           stopAfterFirstFlush,
@@ -53,7 +60,8 @@ func newLogMerger(logger logger.Logger,
 		inactivityFlushTimeout:        inactivityFlushTimeout,
 		forceFlushTimeout:             forceFlushTimeout,
 		writers:                       writers,
-		incomingRecords:               make(chan *logRecord),
+		incomingRecords:               make(chan *LogRecord),
+		stop:                          make(chan struct{}),
 		pendingRecords:                logRecordSorter{},
 		newestPendingRecordReceivedAt: time.Now(),
 		oldestPendingRecordReceivedAt: time.Now(),
@@ -67,7 +75,7 @@ func newLogMerger(logger logger.Logger,
 	return lm
 }
 
-func (lm *logMerger) Write(logRecord *logRecord) error {
+func (lm *LogMerger) Write(logRecord *LogRecord) error {
 
 	// write the record to the channel
 	lm.incomingRecords <- logRecord
@@ -75,7 +83,12 @@ func (lm *logMerger) Write(logRecord *logRecord) error {
 	return nil
 }
 
-func (lm *logMerger) processIncomingRecords() {
+// Stop tells the merger that no more records will be written. Pending records are flushed and the merger stops
+func (lm *LogMerger) Stop() {
+	close(lm.stop)
+}
+
+func (lm *LogMerger) processIncomingRecords() {
 	lm.logger.Debug("Processing incoming records")
 
 	quit := false
@@ -95,6 +108,7 @@ func (lm *logMerger) processIncomingRecords() {
 
 			// write it to the pendingRecords
 			lm.pendingRecords = append(lm.pendingRecords, incomingRecord)
+			atomic.StoreInt64(&lm.pendingRecordCount, int64(len(lm.pendingRecords)))
 
 			// update the last time we got a record
 			lm.newestPendingRecordReceivedAt = now
@@ -102,6 +116,11 @@ func (lm *logMerger) processIncomingRecords() {
 			// check if we need to flush
 			lm.checkFlushRequired()
 
+		// no more records will arrive
+		case <-lm.stop:
+			lm.flushPendingRecords()
+			quit = true
+
 		// if nothing arrives in the queue, after 250ms check if flush is required
 		case <-time.After(250 * time.Millisecond):
 
@@ -122,10 +141,10 @@ func (lm *logMerger) processIncomingRecords() {
 	lm.waitGroup.Done()
 }
 
-func (lm *logMerger) checkFlushRequired() bool {
+func (lm *LogMerger) checkFlushRequired() bool {
 
 	// and inactivityFlushTimeout seconds passed since we got the newest pending record
-	if time.Since(lm.newestPendingRecordReceivedAt) > lm.inactivityFlushTimeout ||
+	if (lm.inactivityFlushTimeout != 0 && time.Since(lm.newestPendingRecordReceivedAt) > lm.inactivityFlushTimeout) ||
 
 		// or the oldest pending record is older than the force flush timeout and forceFlushTimeout
 		// is enabled (== non-zero)
@@ -140,18 +159,57 @@ func (lm *logMerger) checkFlushRequired() bool {
 	return false
 }
 
-func (lm *logMerger) flushPendingRecords() {
+func (lm *LogMerger) flushPendingRecords() {
 
 	// start by sorting the pending records by time
 	sort.Sort(lm.pendingRecords)
 
-	// now flush them towards the writers
+	// now flush them towards the writers. a writer failing doesn't keep the others from getting the records
 	for _, logRecord := range lm.pendingRecords {
 		for _, writer := range lm.writers {
-			writer.Write(logRecord) // nolint: errcheck
+			if err := writer.Write(logRecord); err != nil {
+				lm.recordWriteErr(err)
+			}
 		}
 	}
 
 	// clean out the pending records
 	lm.pendingRecords = nil
+	atomic.StoreInt64(&lm.pendingRecordCount, 0)
+}
+
+// recordWriteErr counts a failed write. Only the first failure is logged, so a writer which keeps failing doesn't
+// flood the log
+func (lm *LogMerger) recordWriteErr(err error) {
+	lm.failedWriteCount++
+
+	if lm.firstWriteErr == nil {
+		lm.firstWriteErr = err
+		lm.logger.WarnWith("Failed to write record", "err", err.Error())
+	}
+}
+
+// GetWriteErr returns an error describing the writes which failed, or nil if none did. Must be called only after
+// the merger stopped
+func (lm *LogMerger) GetWriteErr() error {
+	if lm.firstWriteErr == nil {
+		return nil
+	}
+
+	return errors.Wrapf(lm.firstWriteErr, "Failed %d writes of records", lm.failedWriteCount)
+}
+
+// GetPendingRecordCount returns the number of records waiting to be flushed. Safe to call from any go routine
+func (lm *LogMerger) GetPendingRecordCount() int64 {
+	return atomic.LoadInt64(&lm.pendingRecordCount)
+}
+
+// Close closes the writers to which the merger writes, and returns the error of failed writes (see GetWriteErr)
+// if closing them didn't fail. Must be called only after the merger stopped
+func (lm *LogMerger) Close() error {
+	if err := closeLogWriters(lm.writers); err != nil {
+		return err
+	}
+
+	return lm.GetWriteErr()
 }
@@ -0,0 +1,50 @@
+package kibini
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/nuclio/errors"
+)
+
+// failingLogWriter fails every write
+type failingLogWriter struct{}
+
+func (flw *failingLogWriter) Write(logRecord *LogRecord) error {
+	return errors.New("disk full")
+}
+
+func TestLogMergerReportsFailedWrites(t *testing.T) {
+	mergerWaitGroup := sync.WaitGroup{}
+	recordingWriter := &recordingLogWriter{}
+
+	logMerger := NewLogMerger(newTestLogger(t),
+		&mergerWaitGroup,
+		false,
+		false,
+		0,
+		0,
+		[]LogWriter{&failingLogWriter{}, recordingWriter})
+
+	for _, line := range []string{
+		`{"when":"2023-01-02T10:00:02.000","who":"api","severity":"info","what":"second"}`,
+		`{"when":"2023-01-02T10:00:01.000","who":"api","severity":"info","what":"first"}`,
+	} {
+		if err := logMerger.Write(NewLogRecord(line)); err != nil {
+			t.Fatalf("Failed to write: %s", err)
+		}
+	}
+
+	logMerger.Stop()
+	mergerWaitGroup.Wait()
+
+	// the other writer still gets every record
+	if whats := recordingWriter.getWhats(); len(whats) != 2 || whats[0] != "first" {
+		t.Fatalf("Expected both records, sorted, got %v", whats)
+	}
+
+	if err := logMerger.Close(); err == nil || !strings.Contains(err.Error(), "Failed 2 writes") {
+		t.Fatalf("Expected the failed writes to be reported, got %v", err)
+	}
+}
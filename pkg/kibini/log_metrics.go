@@ -0,0 +1,283 @@
+package kibini
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nuclio/errors"
+)
+
+// logMetricsMaxFieldValues limits the values counted per field, so that a field with unique values (e.g. an ID)
+// doesn't grow the metrics without bound. Further values are counted as "other"
+const logMetricsMaxFieldValues = 1000
+
+// LogMetricsOptions determine which metrics are collected, on top of the built in ones
+type LogMetricsOptions struct {
+
+	// Counters count the records matching filter expressions
+	Counters []*LogMetricsCounter
+
+	// Fields are fields (see LogRecord.GetField) whose values are counted (e.g. more.status)
+	Fields []string
+
+	// Sums are fields whose numeric values are summed (e.g. more.bytes). Durations are summed in seconds
+	Sums []string
+}
+
+// LogMetricsCounter counts the records matching a filter
+type LogMetricsCounter struct {
+	Name   string
+	Filter LogFilter
+}
+
+// ParseLogMetricsCounter parses a counter given as <name>=<filter expression> (see ParseLogFilter)
+func ParseLogMetricsCounter(counter string) (*LogMetricsCounter, error) {
+	separatorIndex := strings.Index(counter, "=")
+	if separatorIndex <= 0 {
+		return nil, errors.New(fmt.Sprintf("Invalid counter %q (expected <name>=<filter expression>)", counter))
+	}
+
+	logFilter, err := ParseLogFilter(counter[separatorIndex+1:])
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to parse filter of counter %s", counter[:separatorIndex])
+	}
+
+	return &LogMetricsCounter{
+		Name:   counter[:separatorIndex],
+		Filter: logFilter,
+	}, nil
+}
+
+// LogMetrics is a LogWriter which counts records (by file, who and severity), unparseable lines and the
+// options' counters, and serves them along with reader lag and merger buffer size in the Prometheus text format.
+// It must be written to before records are merged (see readLogFiles), and can be written to concurrently
+type LogMetrics struct {
+	lock             sync.Mutex
+	options          LogMetricsOptions
+	records          map[[3]string]int64
+	unparseableLines map[string]int64
+	counters         map[string]int64
+	fieldValues      map[string]map[string]int64
+	sums             map[string]float64
+	logReaders       []*LogTailReader
+	logMergers       []*LogMerger
+}
+
+// NewLogMetrics creates a LogMetrics
+func NewLogMetrics(options *LogMetricsOptions) (*LogMetrics, error) {
+	for _, field := range append(append([]string{}, options.Fields...), options.Sums...) {
+		if !IsValidFieldName(field) {
+			return nil, errors.New(fmt.Sprintf("Unknown field: %s (expected a record field or more.<key>)", field))
+		}
+	}
+
+	logMetrics := LogMetrics{
+		options:          *options,
+		records:          map[[3]string]int64{},
+		unparseableLines: map[string]int64{},
+		counters:         map[string]int64{},
+		fieldValues:      map[string]map[string]int64{},
+		sums:             map[string]float64{},
+	}
+
+	for _, field := range options.Fields {
+		logMetrics.fieldValues[field] = map[string]int64{}
+	}
+
+	return &logMetrics, nil
+}
+
+func (lm *LogMetrics) Write(logRecord *LogRecord) error {
+	lm.lock.Lock()
+	defer lm.lock.Unlock()
+
+	lm.records[[3]string{logRecord.SourceFile, logRecord.Who, logRecord.Severity}]++
+
+	for _, counter := range lm.options.Counters {
+		if counter.Filter.Match(logRecord) {
+			lm.counters[counter.Name]++
+		}
+	}
+
+	for _, field := range lm.options.Fields {
+		value, found := logRecord.GetField(field)
+		if !found {
+			continue
+		}
+
+		flattenedValue := flattenValue(value)
+		if _, counted := lm.fieldValues[field][flattenedValue]; !counted && len(lm.fieldValues[field]) >= logMetricsMaxFieldValues {
+			flattenedValue = "other"
+		}
+
+		lm.fieldValues[field][flattenedValue]++
+	}
+
+	for _, field := range lm.options.Sums {
+		value, found := logRecord.GetField(field)
+		if !found {
+			continue
+		}
+
+		flattenedValue := flattenValue(value)
+
+		if number, err := strconv.ParseFloat(flattenedValue, 64); err == nil {
+			lm.sums[field] += number
+		} else if duration, err := time.ParseDuration(flattenedValue); err == nil {
+			lm.sums[field] += duration.Seconds()
+		}
+	}
+
+	return nil
+}
+
+// WriteUnparseableLine counts the line for its file
+func (lm *LogMetrics) WriteUnparseableLine(sourceFile string, lineNumber int, line string) error {
+	lm.lock.Lock()
+	defer lm.lock.Unlock()
+
+	lm.unparseableLines[sourceFile]++
+
+	return nil
+}
+
+// ServeHTTP serves the metrics in the Prometheus text format
+func (lm *LogMetrics) ServeHTTP(responseWriter http.ResponseWriter, request *http.Request) {
+	responseWriter.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	lm.WriteMetrics(responseWriter) // nolint: errcheck
+}
+
+// WriteMetrics writes the metrics in the Prometheus text format
+func (lm *LogMetrics) WriteMetrics(writer io.Writer) error {
+	lm.lock.Lock()
+	defer lm.lock.Unlock()
+
+	logMetricsWriter := logMetricsWriter{writer: writer}
+
+	logMetricsWriter.writeHeader("kibini_records_total", "counter", "Records read, by file, who and severity")
+	for key, count := range lm.records {
+		logMetricsWriter.writeSample("kibini_records_total",
+			[]string{"file", key[0], "who", key[1], "severity", key[2]},
+			float64(count))
+	}
+
+	logMetricsWriter.writeHeader("kibini_unparseable_lines_total", "counter", "Lines which aren't records, by file")
+	for sourceFile, count := range lm.unparseableLines {
+		logMetricsWriter.writeSample("kibini_unparseable_lines_total", []string{"file", sourceFile}, float64(count))
+	}
+
+	logMetricsWriter.writeHeader("kibini_reader_lag_bytes", "gauge", "Bytes of the file which weren't read yet, by file")
+	for _, logReader := range lm.logReaders {
+		if lag, err := logReader.GetLag(); err == nil {
+			logMetricsWriter.writeSample("kibini_reader_lag_bytes", []string{"file", logReader.GetSourceFile()}, float64(lag))
+		}
+	}
+
+	logMetricsWriter.writeHeader("kibini_merger_pending_records", "gauge", "Records waiting to be merged")
+	if len(lm.logMergers) != 0 {
+		var pendingRecordCount int64
+		for _, logMerger := range lm.logMergers {
+			pendingRecordCount += logMerger.GetPendingRecordCount()
+		}
+
+		logMetricsWriter.writeSample("kibini_merger_pending_records", nil, float64(pendingRecordCount))
+	}
+
+	if len(lm.options.Counters) != 0 {
+		logMetricsWriter.writeHeader("kibini_counter_records_total", "counter", "Records matching user defined filters, by counter")
+		for _, counter := range lm.options.Counters {
+			logMetricsWriter.writeSample("kibini_counter_records_total", []string{"counter", counter.Name}, float64(lm.counters[counter.Name]))
+		}
+	}
+
+	if len(lm.options.Fields) != 0 {
+		logMetricsWriter.writeHeader("kibini_field_records_total", "counter", "Records by the value of user defined fields")
+		for field, counts := range lm.fieldValues {
+			for value, count := range counts {
+				logMetricsWriter.writeSample("kibini_field_records_total", []string{"field", field, "value", value}, float64(count))
+			}
+		}
+	}
+
+	if len(lm.options.Sums) != 0 {
+		logMetricsWriter.writeHeader("kibini_field_sum_total", "counter", "Sum of the numeric values (durations in seconds) of user defined fields")
+		for _, field := range lm.options.Sums {
+			logMetricsWriter.writeSample("kibini_field_sum_total", []string{"field", field}, lm.sums[field])
+		}
+	}
+
+	return logMetricsWriter.flush()
+}
+
+// watchLogReader has the reader's lag reported (see readLogFiles)
+func (lm *LogMetrics) watchLogReader(logReader *LogTailReader) {
+	lm.lock.Lock()
+	defer lm.lock.Unlock()
+
+	lm.logReaders = append(lm.logReaders, logReader)
+}
+
+// watchLogMerger has the merger's pending records reported (see readLogFiles)
+func (lm *LogMetrics) watchLogMerger(logMerger *LogMerger) {
+	lm.lock.Lock()
+	defer lm.lock.Unlock()
+
+	lm.logMergers = append(lm.logMergers, logMerger)
+}
+
+// logMetricsWriter writes metrics in the Prometheus text format. Samples of a metric are sorted, since they're
+// gathered from maps
+type logMetricsWriter struct {
+	writer  io.Writer
+	samples []string
+	err     error
+}
+
+func (lmw *logMetricsWriter) writeHeader(name string, metricType string, help string) {
+	lmw.flush() // nolint: errcheck
+
+	lmw.write(fmt.Sprintf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType))
+}
+
+// writeSample adds a sample, with labels given as name, value pairs
+func (lmw *logMetricsWriter) writeSample(name string, labels []string, value float64) {
+	var formattedLabels []string
+	for labelIndex := 0; labelIndex+1 < len(labels); labelIndex += 2 {
+		formattedLabels = append(formattedLabels, fmt.Sprintf(`%s="%s"`, labels[labelIndex], escapeLabelValue(labels[labelIndex+1])))
+	}
+
+	if len(formattedLabels) != 0 {
+		name += "{" + strings.Join(formattedLabels, ",") + "}"
+	}
+
+	lmw.samples = append(lmw.samples, name+" "+strconv.FormatFloat(value, 'g', -1, 64)+"\n")
+}
+
+func (lmw *logMetricsWriter) flush() error {
+	sort.Strings(lmw.samples)
+
+	for _, sample := range lmw.samples {
+		lmw.write(sample)
+	}
+
+	lmw.samples = nil
+
+	return lmw.err
+}
+
+func (lmw *logMetricsWriter) write(s string) {
+	if lmw.err == nil {
+		_, lmw.err = io.WriteString(lmw.writer, s)
+	}
+}
+
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
@@ -0,0 +1,127 @@
+package kibini
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nuclio/errors"
+)
+
+// LogQuery selects log records. Zero values don't filter (e.g. a zero To means no upper bound)
+type LogQuery struct {
+	From       time.Time
+	To         time.Time
+	Severities []string
+	Whos       []string
+	Ctx        string
+	Text       string
+	Filter     LogFilter
+}
+
+// Match returns whether the log record is selected by the query
+func (lq *LogQuery) Match(logRecord *LogRecord) bool {
+	if !lq.From.IsZero() && logRecord.WhenUnixNano < lq.From.UnixNano() {
+		return false
+	}
+
+	// the time range is [From, To)
+	if !lq.To.IsZero() && logRecord.WhenUnixNano >= lq.To.UnixNano() {
+		return false
+	}
+
+	if len(lq.Severities) != 0 && !containsFold(lq.Severities, logRecord.Severity) {
+		return false
+	}
+
+	if len(lq.Whos) != 0 && !containsFold(lq.Whos, logRecord.Who) {
+		return false
+	}
+
+	if len(lq.Ctx) != 0 && logRecord.Ctx != lq.Ctx {
+		return false
+	}
+
+	if len(lq.Text) != 0 && !logRecord.containsText(strings.ToLower(lq.Text)) {
+		return false
+	}
+
+	return lq.Filter == nil || lq.Filter.Match(logRecord)
+}
+
+// LogRecordCount is the number of records which share the values of the fields they were grouped by and,
+// if counted with an interval, the time bucket
+type LogRecordCount struct {
+	Time   time.Time
+	Values map[string]interface{}
+	Count  int
+}
+
+// CountLogRecords groups the log records by the given fields (see LogRecord.GetField) and, if interval isn't
+// zero, by time buckets of that size. Counts are sorted by time and then by the values of the fields
+func CountLogRecords(logRecords []*LogRecord, fields []string, interval time.Duration) ([]*LogRecordCount, error) {
+	for _, field := range fields {
+		if !IsValidFieldName(field) {
+			return nil, errors.New(fmt.Sprintf("Unknown field: %s (expected a record field or more.<key>)", field))
+		}
+	}
+
+	countsByKey := map[string]*LogRecordCount{}
+	var counts []*LogRecordCount
+
+	for _, logRecord := range logRecords {
+		logRecordCount := LogRecordCount{
+			Values: make(map[string]interface{}, len(fields)),
+		}
+
+		if interval != 0 {
+			logRecordCount.Time = logRecord.When.Truncate(interval)
+		}
+
+		key := logRecordCount.Time.String()
+
+		for _, field := range fields {
+			value, _ := logRecord.GetField(field)
+			logRecordCount.Values[field] = value
+			key += "\x00" + flattenValue(value)
+		}
+
+		if existingLogRecordCount, found := countsByKey[key]; found {
+			existingLogRecordCount.Count++
+			continue
+		}
+
+		logRecordCount.Count = 1
+		countsByKey[key] = &logRecordCount
+		counts = append(counts, &logRecordCount)
+	}
+
+	sort.SliceStable(counts, func(i, j int) bool {
+		if !counts[i].Time.Equal(counts[j].Time) {
+			return counts[i].Time.Before(counts[j].Time)
+		}
+
+		for _, field := range fields {
+			iValue, jValue := flattenValue(counts[i].Values[field]), flattenValue(counts[j].Values[field])
+			if iValue != jValue {
+				return iValue < jValue
+			}
+		}
+
+		return false
+	})
+
+	return counts, nil
+}
+
+// containsFold returns whether value is in values, ignoring case
+func containsFold(values []string, value string) bool {
+	for _, candidateValue := range values {
+		if strings.EqualFold(candidateValue, value) {
+			return true
+		}
+	}
+
+	return false
+}
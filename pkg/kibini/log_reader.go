@@ -1,5 +1,6 @@
-package core
+package kibini
 
-type logReader interface {
-	read(follow bool) error
+// LogReader reads log records from some source and writes them to log writers
+type LogReader interface {
+	Read(follow bool) error
 }
@@ -1,11 +1,17 @@
-package core
+package kibini
 
 import (
+	"bytes"
 	"encoding/json"
+	"sort"
+	"strings"
 	"time"
+
+	"github.com/nuclio/errors"
 )
 
-type logRecord struct {
+// LogRecord is a single parsed log line
+type LogRecord struct {
 	WhenRaw      string `json:"when"`
 	When         time.Time
 	WhenUnixNano int64
@@ -14,11 +20,28 @@ type logRecord struct {
 	Severity     string                      `json:"severity"`
 	More         map[string]*json.RawMessage `json:"more"`
 	Ctx          string                      `json:"ctx"`
+
+	// where the record was read from (populated by readers)
+	SourceFile string `json:"-"`
+	LineNumber int    `json:"-"`
 }
 
-func newLogRecord(unparsedLogRecord string) *logRecord {
+// NormalizedLogRecord is the schema in which log records are exported to other tools
+type NormalizedLogRecord struct {
+	When     string                 `json:"when"`
+	Source   string                 `json:"source"`
+	Line     int                    `json:"line"`
+	Who      string                 `json:"who"`
+	Severity string                 `json:"severity"`
+	What     string                 `json:"what"`
+	Ctx      string                 `json:"ctx"`
+	More     map[string]interface{} `json:"more"`
+}
+
+// NewLogRecord parses a JSON log line. Returns nil if the line is not a valid log record
+func NewLogRecord(unparsedLogRecord string) *LogRecord {
 	var err error
-	logRecord := logRecord{}
+	logRecord := LogRecord{}
 
 	if err := json.Unmarshal([]byte(unparsedLogRecord), &logRecord); err != nil {
 		return nil
@@ -36,12 +59,195 @@ func newLogRecord(unparsedLogRecord string) *logRecord {
 	return &logRecord
 }
 
-func (lr *logRecord) rtruncateString(s string, length int) string {
-	sLen := len(s)
+// Normalize returns the record in the normalized schema, with a full timestamp and typed "more" values
+func (lr *LogRecord) Normalize() *NormalizedLogRecord {
+	return &NormalizedLogRecord{
+		When:     lr.When.Format(time.RFC3339Nano),
+		Source:   lr.SourceFile,
+		Line:     lr.LineNumber,
+		Who:      lr.Who,
+		Severity: lr.Severity,
+		What:     lr.What,
+		Ctx:      lr.Ctx,
+		More:     lr.GetTypedMore(),
+	}
+}
+
+// GetTypedMore returns "more" with its values unmarshalled. Strings stay strings (see GetField for looking into
+// stringified objects)
+func (lr *LogRecord) GetTypedMore() map[string]interface{} {
+	typedMore := make(map[string]interface{}, len(lr.More))
+
+	for key, rawValue := range lr.More {
+		typedMore[key] = getTypedValue(rawValue)
+	}
+
+	return typedMore
+}
+
+// GetField returns the value of a field by name: one of the normalized schema fields ("when", "source",
+// "line", "who", "severity", "what", "ctx", "more") or a dotted path into "more" (e.g. "more.request.id"). Paths
+// may lead into strings which hold JSON objects
+func (lr *LogRecord) GetField(name string) (interface{}, bool) {
+	switch name {
+	case "when":
+		return lr.When.Format(time.RFC3339Nano), true
+	case "source":
+		return lr.SourceFile, true
+	case "line":
+		return lr.LineNumber, true
+	case "who":
+		return lr.Who, true
+	case "severity":
+		return lr.Severity, true
+	case "what":
+		return lr.What, true
+	case "ctx":
+		return lr.Ctx, true
+	case "more":
+		return lr.GetTypedMore(), true
+	}
+
+	if strings.HasPrefix(name, "more.") {
+		return getValueByPath(lr.GetTypedMore(), strings.TrimPrefix(name, "more."))
+	}
+
+	return nil, false
+}
 
-	if length > sLen {
-		length = sLen
+// containsText returns whether lowerText appears in what, who, ctx or any "more" key or value, ignoring case
+func (lr *LogRecord) containsText(lowerText string) bool {
+	for _, value := range []string{lr.What, lr.Who, lr.Ctx} {
+		if strings.Contains(strings.ToLower(value), lowerText) {
+			return true
+		}
 	}
 
-	return s[sLen-length:]
+	for key, rawValue := range lr.More {
+		if strings.Contains(strings.ToLower(key), lowerText) {
+			return true
+		}
+
+		if rawValue != nil && strings.Contains(strings.ToLower(string(*rawValue)), lowerText) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsValidFieldName returns whether GetField can be called with name
+func IsValidFieldName(name string) bool {
+	_, valid := (&LogRecord{}).GetField(name)
+	return valid || strings.HasPrefix(name, "more.")
+}
+
+// getValueByPath looks up a dotted path in nested maps. since keys may contain dots themselves, the longest
+// matching key is preferred at each level
+func getValueByPath(values map[string]interface{}, path string) (interface{}, bool) {
+	if value, found := values[path]; found {
+		return value, true
+	}
+
+	for dotIndex := strings.LastIndex(path, "."); dotIndex > 0; dotIndex = strings.LastIndex(path[:dotIndex], ".") {
+		nestedValues, isMap := getNestedValues(values[path[:dotIndex]])
+		if !isMap {
+			continue
+		}
+
+		if value, found := getValueByPath(nestedValues, path[dotIndex+1:]); found {
+			return value, true
+		}
+	}
+
+	return nil, false
+}
+
+// getNestedValues returns the value as a map, if it is one. since loggers tend to stringify objects, strings
+// holding JSON objects can be looked into as well
+func getNestedValues(value interface{}) (map[string]interface{}, bool) {
+	switch typedValue := value.(type) {
+	case map[string]interface{}:
+		return typedValue, true
+	case string:
+		if !strings.HasPrefix(strings.TrimSpace(typedValue), "{") {
+			return nil, false
+		}
+
+		var nestedValue interface{}
+		if err := unmarshalUsingNumber([]byte(typedValue), &nestedValue); err != nil {
+			return nil, false
+		}
+
+		nestedValues, isMap := nestedValue.(map[string]interface{})
+		return nestedValues, isMap
+	}
+
+	return nil, false
+}
+
+// getTypedValue unmarshals a raw value. JSON strings stay strings, even if they look like numbers or JSON
+func getTypedValue(rawValue *json.RawMessage) interface{} {
+	var value interface{}
+
+	if rawValue == nil {
+		return nil
+	}
+
+	if err := unmarshalUsingNumber(*rawValue, &value); err != nil {
+		return string(*rawValue)
+	}
+
+	return value
+}
+
+// unmarshalUsingNumber unmarshals numbers as json.Number so that large integers don't lose precision
+func unmarshalUsingNumber(data []byte, value *interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	if err := decoder.Decode(value); err != nil {
+		return err
+	}
+
+	// make sure there's nothing but the value
+	if decoder.More() {
+		return errors.New("Unexpected data after value")
+	}
+
+	return nil
+}
+
+// rtruncateString keeps the last length characters (runes) of s
+func rtruncateString(s string, length int) string {
+	runes := []rune(s)
+
+	if length > len(runes) {
+		length = len(runes)
+	}
+
+	return string(runes[len(runes)-length:])
+}
+
+// truncateString keeps the first length characters (runes) of s
+func truncateString(s string, length int) string {
+	runes := []rune(s)
+
+	if length > len(runes) {
+		length = len(runes)
+	}
+
+	return string(runes[:length])
+}
+
+// getSortedKeys returns the keys of a map, sorted
+func getSortedKeys(values map[string]interface{}) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
 }
@@ -0,0 +1,85 @@
+package kibini
+
+import (
+	"sort"
+	"sync"
+)
+
+// LogRecordStore is a LogWriter which keeps records in memory, sorted by time, and notifies subscribers of
+// new records
+type LogRecordStore struct {
+	lock        sync.RWMutex
+	records     []*LogRecord
+	subscribers map[chan *LogRecord]struct{}
+}
+
+// NewLogRecordStore creates an empty LogRecordStore
+func NewLogRecordStore() *LogRecordStore {
+	return &LogRecordStore{
+		subscribers: map[chan *LogRecord]struct{}{},
+	}
+}
+
+// Write stores the record and passes it to subscribers. Slow subscribers miss records rather than block
+func (lrs *LogRecordStore) Write(logRecord *LogRecord) error {
+	lrs.lock.Lock()
+	defer lrs.lock.Unlock()
+
+	// records mostly arrive in order, so this is usually an append
+	insertIndex := sort.Search(len(lrs.records), func(recordIndex int) bool {
+		return lrs.records[recordIndex].WhenUnixNano > logRecord.WhenUnixNano
+	})
+
+	lrs.records = append(lrs.records, nil)
+	copy(lrs.records[insertIndex+1:], lrs.records[insertIndex:])
+	lrs.records[insertIndex] = logRecord
+
+	for subscriber := range lrs.subscribers {
+		select {
+		case subscriber <- logRecord:
+		default:
+		}
+	}
+
+	return nil
+}
+
+// Subscribe returns a channel on which new records are received, and a function which unsubscribes
+func (lrs *LogRecordStore) Subscribe() (<-chan *LogRecord, func()) {
+	lrs.lock.Lock()
+	defer lrs.lock.Unlock()
+
+	subscriber := make(chan *LogRecord, 1024)
+	lrs.subscribers[subscriber] = struct{}{}
+
+	return subscriber, func() {
+		lrs.lock.Lock()
+		defer lrs.lock.Unlock()
+
+		delete(lrs.subscribers, subscriber)
+	}
+}
+
+// Query returns up to limit records (0 means no limit) which match the filter, skipping the first offset
+// matching records. It also returns the total number of matching records
+func (lrs *LogRecordStore) Query(filter func(*LogRecord) bool, offset int, limit int) ([]*LogRecord, int) {
+	lrs.lock.RLock()
+	defer lrs.lock.RUnlock()
+
+	var matchingRecords []*LogRecord
+	total := 0
+
+	for _, logRecord := range lrs.records {
+		if filter != nil && !filter(logRecord) {
+			continue
+		}
+
+		if total >= offset && (limit == 0 || len(matchingRecords) < limit) {
+			matchingRecords = append(matchingRecords, logRecord)
+		}
+
+		total++
+	}
+
+	return matchingRecords, total
+}
@@ -0,0 +1,32 @@
+package kibini
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestTypedMoreKeepsStrings(t *testing.T) {
+	logRecord := NewLogRecord(`{"when":"2023-01-02T10:00:01.000","who":"api","severity":"info","what":"x",` +
+		`"more":{"count":1,"countString":"1","flag":"true","nothing":"null","id":"0012345","request":"{\"id\":7}"}}`)
+
+	typedMore := logRecord.GetTypedMore()
+
+	if typedMore["count"] != json.Number("1") {
+		t.Fatalf("Expected a number, got %#v", typedMore["count"])
+	}
+
+	for _, key := range []string{"countString", "flag", "nothing", "id", "request"} {
+		if _, isString := typedMore[key].(string); !isString {
+			t.Fatalf("Expected %s to stay a string, got %#v", key, typedMore[key])
+		}
+	}
+
+	if typedMore["id"] != "0012345" {
+		t.Fatalf("Expected the id as logged, got %#v", typedMore["id"])
+	}
+
+	// paths may still lead into stringified objects
+	if value, found := logRecord.GetField("more.request.id"); !found || value != json.Number("7") {
+		t.Fatalf("Expected the nested id, got %#v (found: %v)", value, found)
+	}
+}
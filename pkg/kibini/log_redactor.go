@@ -0,0 +1,381 @@
+package kibini
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/nuclio/errors"
+)
+
+// RedactionMode determines what redacted values are replaced with
+type RedactionMode int
+
+const (
+
+	// RedactionMask replaces values with [REDACTED:<rule>]
+	RedactionMask RedactionMode = iota
+
+	// RedactionHash replaces values with [<rule>:<hash>], so that equal values can still be correlated. The hash
+	// is keyed by a random key per LogRedactor, so values can't be recovered by hashing guesses (e.g. all IPv4
+	// addresses), and hashes of different runs don't correlate
+	RedactionHash
+
+	// RedactionDrop removes values (and "more" keys matching key rules)
+	RedactionDrop
+)
+
+// redactionDetector is a built in rule
+type redactionDetector struct {
+	name  string
+	regex *regexp.Regexp
+}
+
+// the built in detectors, in the order they're applied (bearer tokens may be JWTs)
+var redactionDetectors = []redactionDetector{
+	{"bearer", regexp.MustCompile(`(?i)\bbearer\s+[A-Za-z0-9\-._~+/]+=*`)},
+	{"jwt", regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)},
+	{"aws", regexp.MustCompile(`\b(?:AKIA|ASIA|AIDA|AROA)[0-9A-Z]{16}\b`)},
+	{"email", regexp.MustCompile(`\b[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}\b`)},
+	{"ip", regexp.MustCompile(`\b(?:(?:25[0-5]|2[0-4]\d|1?\d?\d)\.){3}(?:25[0-5]|2[0-4]\d|1?\d?\d)\b`)},
+}
+
+// RedactionDetectorNames returns the names of the built in detectors
+func RedactionDetectorNames() []string {
+	var names []string
+	for _, detector := range redactionDetectors {
+		names = append(names, detector.name)
+	}
+
+	return names
+}
+
+// RedactionOptions determine what is redacted and how
+type RedactionOptions struct {
+	Mode RedactionMode
+
+	// Detectors are names of built in detectors (see RedactionDetectorNames)
+	Detectors []string
+
+	// Patterns are user defined regular expressions whose matches are redacted
+	Patterns []*RedactionPattern
+
+	// KeyPatterns are regular expressions of "more" key names (case insensitive, at any depth) whose values are
+	// redacted entirely (e.g. password|secret)
+	KeyPatterns []string
+}
+
+// RedactionPattern is a named regular expression whose matches are redacted
+type RedactionPattern struct {
+	Name  string
+	Regex *regexp.Regexp
+}
+
+// ParseRedactionPattern parses a pattern given as <name>=<regular expression>
+func ParseRedactionPattern(pattern string) (*RedactionPattern, error) {
+	separatorIndex := strings.Index(pattern, "=")
+	if separatorIndex <= 0 {
+		return nil, errors.New(fmt.Sprintf("Invalid redaction pattern %q (expected <name>=<regular expression>)", pattern))
+	}
+
+	regex, err := regexp.Compile(pattern[separatorIndex+1:])
+	if err != nil {
+		return nil, errors.Wrapf(err, "Invalid regular expression of redaction pattern %s", pattern[:separatorIndex])
+	}
+
+	return &RedactionPattern{
+		Name:  pattern[:separatorIndex],
+		Regex: regex,
+	}, nil
+}
+
+// LogRedactor redacts secrets and personal information from the "what", "ctx" and "more" (at any depth) of
+// records, and counts what it redacted. Safe for concurrent use
+type LogRedactor struct {
+	mode     RedactionMode
+	hashKey  []byte
+	patterns []*RedactionPattern
+	keyRegex *regexp.Regexp
+	lock     sync.Mutex
+	counts   map[string]int
+}
+
+// NewLogRedactor creates a LogRedactor
+func NewLogRedactor(options *RedactionOptions) (*LogRedactor, error) {
+	logRedactor := LogRedactor{
+		mode:   options.Mode,
+		counts: map[string]int{},
+	}
+
+	if logRedactor.mode == RedactionHash {
+		logRedactor.hashKey = make([]byte, sha256.Size)
+
+		if _, err := rand.Read(logRedactor.hashKey); err != nil {
+			return nil, errors.Wrap(err, "Failed to create hash key")
+		}
+	}
+
+	for _, detectorName := range options.Detectors {
+		found := false
+
+		for _, detector := range redactionDetectors {
+			if detector.name == detectorName {
+				logRedactor.patterns = append(logRedactor.patterns, &RedactionPattern{
+					Name:  detector.name,
+					Regex: detector.regex,
+				})
+
+				found = true
+			}
+		}
+
+		if !found {
+			return nil, errors.New(fmt.Sprintf("Unknown redaction detector: %s (expected one of %s)",
+				detectorName,
+				strings.Join(RedactionDetectorNames(), ", ")))
+		}
+	}
+
+	logRedactor.patterns = append(logRedactor.patterns, options.Patterns...)
+
+	if len(options.KeyPatterns) != 0 {
+		keyRegex, err := regexp.Compile("(?i)" + strings.Join(options.KeyPatterns, "|"))
+		if err != nil {
+			return nil, errors.Wrap(err, "Invalid key pattern")
+		}
+
+		logRedactor.keyRegex = keyRegex
+	}
+
+	return &logRedactor, nil
+}
+
+// Redact redacts the record in place
+func (lr *LogRedactor) Redact(logRecord *LogRecord) {
+	counts := map[string]int{}
+
+	logRecord.What = lr.redactString(logRecord.What, counts)
+	logRecord.Ctx = lr.redactString(logRecord.Ctx, counts)
+
+	for key, rawValue := range logRecord.More {
+		if lr.isRedactedKey(key) {
+			counts["key "+key]++
+
+			if lr.mode == RedactionDrop {
+				delete(logRecord.More, key)
+				continue
+			}
+
+			redactedValue := lr.marshal(lr.replace("key", lr.rawString(rawValue)))
+			logRecord.More[key] = &redactedValue
+
+			continue
+		}
+
+		if rawValue == nil {
+			continue
+		}
+
+		var value interface{}
+		if err := unmarshalUsingNumber(*rawValue, &value); err != nil {
+			continue
+		}
+
+		valueCounts := map[string]int{}
+		redactedValue := lr.redactValue(value, valueCounts)
+
+		// re-marshal only what changed, so that untouched values keep their exact form
+		if len(valueCounts) == 0 {
+			continue
+		}
+
+		for name, count := range valueCounts {
+			counts[name] += count
+		}
+
+		marshalledValue := lr.marshal(redactedValue)
+		logRecord.More[key] = &marshalledValue
+	}
+
+	if len(counts) == 0 {
+		return
+	}
+
+	lr.lock.Lock()
+	defer lr.lock.Unlock()
+
+	for name, count := range counts {
+		lr.counts[name] += count
+	}
+}
+
+// GetCounts returns the number of values redacted, by rule (key rules as "key <name>")
+func (lr *LogRedactor) GetCounts() map[string]int {
+	lr.lock.Lock()
+	defer lr.lock.Unlock()
+
+	counts := make(map[string]int, len(lr.counts))
+	for name, count := range lr.counts {
+		counts[name] = count
+	}
+
+	return counts
+}
+
+// WriteSummary writes the number of values redacted, in total and by rule
+func (lr *LogRedactor) WriteSummary(writer io.Writer) error {
+	counts := lr.GetCounts()
+
+	total := 0
+	var names []string
+
+	for name, count := range counts {
+		total += count
+		names = append(names, name)
+	}
+
+	// the most redacted first
+	sort.Slice(names, func(i, j int) bool {
+		if counts[names[i]] != counts[names[j]] {
+			return counts[names[i]] > counts[names[j]]
+		}
+
+		return names[i] < names[j]
+	})
+
+	var formattedCounts []string
+	for _, name := range names {
+		formattedCounts = append(formattedCounts, fmt.Sprintf("%s: %d", name, counts[name]))
+	}
+
+	summary := fmt.Sprintf("Redacted %d values", total)
+	if len(formattedCounts) != 0 {
+		summary += " (" + strings.Join(formattedCounts, ", ") + ")"
+	}
+
+	_, err := fmt.Fprintln(writer, summary)
+	return err
+}
+
+// redactValue redacts strings in an unmarshalled value, and the values of redacted keys in nested maps
+func (lr *LogRedactor) redactValue(value interface{}, counts map[string]int) interface{} {
+	switch typedValue := value.(type) {
+	case string:
+
+		// loggers tend to stringify objects, whose keys must be redacted as well
+		trimmedValue := strings.TrimSpace(typedValue)
+		if strings.HasPrefix(trimmedValue, "{") || strings.HasPrefix(trimmedValue, "[") {
+			var nestedValue interface{}
+
+			if err := unmarshalUsingNumber([]byte(trimmedValue), &nestedValue); err == nil {
+				nestedCounts := map[string]int{}
+				nestedValue = lr.redactValue(nestedValue, nestedCounts)
+
+				if len(nestedCounts) == 0 {
+					return typedValue
+				}
+
+				for name, count := range nestedCounts {
+					counts[name] += count
+				}
+
+				return string(lr.marshal(nestedValue))
+			}
+		}
+
+		return lr.redactString(typedValue, counts)
+
+	case []interface{}:
+		for index, item := range typedValue {
+			typedValue[index] = lr.redactValue(item, counts)
+		}
+
+	case map[string]interface{}:
+		for key, item := range typedValue {
+			if lr.isRedactedKey(key) {
+				counts["key "+key]++
+
+				if lr.mode == RedactionDrop {
+					delete(typedValue, key)
+				} else {
+					typedValue[key] = lr.replace("key", flattenValue(item))
+				}
+
+				continue
+			}
+
+			typedValue[key] = lr.redactValue(item, counts)
+		}
+	}
+
+	return value
+}
+
+// redactText redacts a text the way "what" is redacted, without counting what's redacted
+func (lr *LogRedactor) redactText(text string) string {
+	return lr.redactString(text, map[string]int{})
+}
+
+func (lr *LogRedactor) redactString(value string, counts map[string]int) string {
+	for _, pattern := range lr.patterns {
+		value = pattern.Regex.ReplaceAllStringFunc(value, func(match string) string {
+			counts[pattern.Name]++
+
+			return lr.replace(pattern.Name, match)
+		})
+	}
+
+	return value
+}
+
+// replace returns what replaces a redacted value
+func (lr *LogRedactor) replace(name string, value string) string {
+	switch lr.mode {
+	case RedactionHash:
+		hash := hmac.New(sha256.New, lr.hashKey)
+		hash.Write([]byte(value)) // nolint: errcheck
+
+		return fmt.Sprintf("[%s:%s]", name, hex.EncodeToString(hash.Sum(nil)[:6]))
+	case RedactionDrop:
+		return ""
+	}
+
+	return fmt.Sprintf("[REDACTED:%s]", name)
+}
+
+func (lr *LogRedactor) isRedactedKey(key string) bool {
+	return lr.keyRegex != nil && lr.keyRegex.MatchString(key)
+}
+
+// rawString returns a raw value as a string (unquoted, if it's a JSON string)
+func (lr *LogRedactor) rawString(rawValue *json.RawMessage) string {
+	if rawValue == nil {
+		return ""
+	}
+
+	var stringValue string
+	if err := json.Unmarshal(*rawValue, &stringValue); err == nil {
+		return stringValue
+	}
+
+	return string(*rawValue)
+}
+
+func (lr *LogRedactor) marshal(value interface{}) json.RawMessage {
+	var buffer bytes.Buffer
+
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(value) // nolint: errcheck
+
+	return bytes.TrimRight(buffer.Bytes(), "\n")
+}
@@ -0,0 +1,89 @@
+package kibini
+
+import (
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+func newTestLogRedactor(t *testing.T, mode RedactionMode) *LogRedactor {
+	logRedactor, err := NewLogRedactor(&RedactionOptions{
+		Mode:      mode,
+		Detectors: []string{"email", "ip"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create redactor: %s", err)
+	}
+
+	return logRedactor
+}
+
+func TestLogRedactorHashIsKeyedPerRedactor(t *testing.T) {
+	redact := func(logRedactor *LogRedactor, what string) string {
+		logRecord := NewLogRecord(`{"when":"2023-01-02T10:00:01.000","who":"api","severity":"info","what":"` + what + `"}`)
+		logRedactor.Redact(logRecord)
+
+		return logRecord.What
+	}
+
+	logRedactor := newTestLogRedactor(t, RedactionHash)
+
+	first := redact(logRedactor, "login from 10.0.0.1")
+	if !regexp.MustCompile(`^login from \[ip:[0-9a-f]{12}\]$`).MatchString(first) {
+		t.Fatalf("Expected a hashed IP, got %q", first)
+	}
+
+	// equal values correlate within a redactor's output, but not across redactors (runs)
+	if second := redact(logRedactor, "login from 10.0.0.1"); second != first {
+		t.Fatalf("Expected equal values to hash alike, got %q and %q", first, second)
+	}
+
+	if other := redact(newTestLogRedactor(t, RedactionHash), "login from 10.0.0.1"); other == first {
+		t.Fatalf("Expected another redactor to hash with another key, got %q twice", other)
+	}
+}
+
+func TestLogRedactorCountsOnceWithTemplateFilter(t *testing.T) {
+	inputPath := writeTestLogFile(t, "input.log",
+		`{"when":"2023-01-02T10:00:01.000","who":"api","severity":"info","what":"login of user@example.com from 10.0.0.1"}`,
+		`{"when":"2023-01-02T10:00:02.000","who":"api","severity":"info","what":"login of admin@example.com from 10.0.0.2"}`,
+		`{"when":"2023-01-02T10:00:03.000","who":"api","severity":"info","what":"connected to 10.0.0.3"}`)
+
+	kibiniInstance := NewKibini(newTestLogger(t))
+	inputOptions := InputOptions{
+		InputPath:       filepath.Dir(inputPath),
+		TemplateOptions: TemplateMinerOptions{Similarity: 0.4, Depth: 4},
+		Redactor:        newTestLogRedactor(t, RedactionMask),
+	}
+
+	logTemplates, err := kibiniInstance.MineTemplates(&inputOptions, 0)
+	if err != nil {
+		t.Fatalf("Failed to mine templates: %s", err)
+	}
+
+	for _, logTemplate := range logTemplates {
+		if logTemplate.Template == "login of [REDACTED:email] from [REDACTED:ip]" {
+			inputOptions.TemplateIDs = append(inputOptions.TemplateIDs, logTemplate.ID)
+		}
+	}
+
+	if len(inputOptions.TemplateIDs) != 1 {
+		t.Fatalf("Expected a template of the redacted logins, got %d templates", len(logTemplates))
+	}
+
+	// a fresh redactor counts what's read using the template filter. mining the templates doesn't count
+	inputOptions.Redactor = newTestLogRedactor(t, RedactionMask)
+	recordingWriter := &recordingLogWriter{}
+
+	if err := kibiniInstance.ReadLogs(&inputOptions, []LogWriter{recordingWriter}); err != nil {
+		t.Fatalf("Failed to read logs: %s", err)
+	}
+
+	if whats := recordingWriter.getWhats(); len(whats) != 2 || whats[0] != "login of [REDACTED:email] from [REDACTED:ip]" {
+		t.Fatalf("Expected the redacted logins, got %v", whats)
+	}
+
+	if counts := inputOptions.Redactor.GetCounts(); counts["email"] != 2 || counts["ip"] != 2 {
+		t.Fatalf("Expected each value to be counted once, got %v", counts)
+	}
+}
@@ -0,0 +1,346 @@
+package kibini
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nuclio/errors"
+	"github.com/nuclio/logger"
+)
+
+//go:embed assets/web
+var webAssets embed.FS
+
+//go:embed assets/openapi.json
+var openAPIDescription []byte
+
+const logServerDefaultPageSize = 1000
+
+// LogServer serves a web UI and an HTTP API (described in assets/openapi.json) over the records of a
+// LogRecordStore
+type LogServer struct {
+	logger         logger.Logger
+	logRecordStore *LogRecordStore
+	listenAddress  string
+	serveMux       *http.ServeMux
+}
+
+// logSource is the summary of a source file returned by the API
+type logSource struct {
+	Name    string   `json:"name"`
+	Records int      `json:"records"`
+	First   string   `json:"first"`
+	Last    string   `json:"last"`
+	Whos    []string `json:"whos"`
+}
+
+// NewLogServer creates a LogServer which will listen on listenAddress (e.g. "127.0.0.1:8080")
+func NewLogServer(logger logger.Logger, logRecordStore *LogRecordStore, listenAddress string) (*LogServer, error) {
+	ls := &LogServer{
+		logger:         logger.GetChild("server"),
+		logRecordStore: logRecordStore,
+		listenAddress:  listenAddress,
+		serveMux:       http.NewServeMux(),
+	}
+
+	webAssetsRoot, err := fs.Sub(webAssets, "assets/web")
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to get web assets")
+	}
+
+	ls.serveMux.Handle("/", http.FileServer(http.FS(webAssetsRoot)))
+	ls.serveMux.HandleFunc("/api/v1/openapi.json", ls.handleOpenAPI)
+	ls.serveMux.HandleFunc("/api/v1/sources", ls.handleSources)
+	ls.serveMux.HandleFunc("/api/v1/records", ls.handleRecords)
+	ls.serveMux.HandleFunc("/api/v1/counts", ls.handleCounts)
+	ls.serveMux.HandleFunc("/api/v1/contexts/", ls.handleContextRecords)
+	ls.serveMux.HandleFunc("/api/v1/events", ls.handleEvents)
+
+	return ls, nil
+}
+
+// ServeHTTP allows the server to be used as an http.Handler
+func (ls *LogServer) ServeHTTP(responseWriter http.ResponseWriter, request *http.Request) {
+	ls.serveMux.ServeHTTP(responseWriter, request)
+}
+
+// ListenAndServe serves until an error occurs
+func (ls *LogServer) ListenAndServe() error {
+	ls.logger.InfoWith("Listening", "listenAddress", ls.listenAddress)
+
+	return http.ListenAndServe(ls.listenAddress, ls)
+}
+
+func (ls *LogServer) handleOpenAPI(responseWriter http.ResponseWriter, request *http.Request) {
+	responseWriter.Header().Set("Content-Type", "application/json")
+	responseWriter.Write(openAPIDescription) // nolint: errcheck
+}
+
+// handleSources returns a summary of each source file, sorted by name
+func (ls *LogServer) handleSources(responseWriter http.ResponseWriter, request *http.Request) {
+	logRecords, _ := ls.logRecordStore.Query(nil, 0, 0)
+
+	sourcesByName := map[string]*logSource{}
+	whosBySource := map[string]map[string]interface{}{}
+
+	// records are sorted by time, so the first record of a source is its earliest
+	for _, logRecord := range logRecords {
+		source, found := sourcesByName[logRecord.SourceFile]
+		if !found {
+			source = &logSource{
+				Name:  logRecord.SourceFile,
+				First: logRecord.When.Format(time.RFC3339Nano),
+			}
+
+			sourcesByName[logRecord.SourceFile] = source
+			whosBySource[logRecord.SourceFile] = map[string]interface{}{}
+		}
+
+		source.Records++
+		source.Last = logRecord.When.Format(time.RFC3339Nano)
+		whosBySource[logRecord.SourceFile][logRecord.Who] = nil
+	}
+
+	sources := make([]*logSource, 0, len(sourcesByName))
+	for name, source := range sourcesByName {
+		source.Whos = getSortedKeys(whosBySource[name])
+		sources = append(sources, source)
+	}
+
+	sort.Slice(sources, func(i, j int) bool {
+		return sources[i].Name < sources[j].Name
+	})
+
+	ls.writeJSON(responseWriter, http.StatusOK, map[string]interface{}{
+		"sources": sources,
+	})
+}
+
+// handleRecords returns a page of the records selected by the query string
+func (ls *LogServer) handleRecords(responseWriter http.ResponseWriter, request *http.Request) {
+	logQuery, err := ls.getLogQuery(request.URL.Query())
+	if err != nil {
+		ls.writeError(responseWriter, err)
+		return
+	}
+
+	offset, limit, err := ls.getPagination(request.URL.Query())
+	if err != nil {
+		ls.writeError(responseWriter, err)
+		return
+	}
+
+	logRecords, total := ls.logRecordStore.Query(logQuery.Match, offset, limit)
+
+	ls.writeJSON(responseWriter, http.StatusOK, map[string]interface{}{
+		"total":   total,
+		"offset":  offset,
+		"limit":   limit,
+		"records": ls.normalizeLogRecords(logRecords),
+	})
+}
+
+// handleCounts returns the number of records selected by the query string, grouped by the fields in "by" and
+// by time buckets of "interval"
+func (ls *LogServer) handleCounts(responseWriter http.ResponseWriter, request *http.Request) {
+	logQuery, err := ls.getLogQuery(request.URL.Query())
+	if err != nil {
+		ls.writeError(responseWriter, err)
+		return
+	}
+
+	var interval time.Duration
+	if intervalParameter := request.URL.Query().Get("interval"); len(intervalParameter) != 0 {
+		interval, err = time.ParseDuration(intervalParameter)
+		if err != nil || interval <= 0 {
+			ls.writeError(responseWriter, errors.New(fmt.Sprintf("Invalid interval: %s", intervalParameter)))
+			return
+		}
+	}
+
+	logRecords, total := ls.logRecordStore.Query(logQuery.Match, 0, 0)
+
+	logRecordCounts, err := CountLogRecords(logRecords, ParseColumns(request.URL.Query().Get("by")), interval)
+	if err != nil {
+		ls.writeError(responseWriter, err)
+		return
+	}
+
+	counts := make([]map[string]interface{}, 0, len(logRecordCounts))
+	for _, logRecordCount := range logRecordCounts {
+		count := map[string]interface{}{
+			"count": logRecordCount.Count,
+		}
+
+		if interval != 0 {
+			count["time"] = logRecordCount.Time.Format(time.RFC3339Nano)
+		}
+
+		for field, value := range logRecordCount.Values {
+			count[field] = value
+		}
+
+		counts = append(counts, count)
+	}
+
+	ls.writeJSON(responseWriter, http.StatusOK, map[string]interface{}{
+		"total":  total,
+		"counts": counts,
+	})
+}
+
+// handleContextRecords returns all the records of a ctx (/api/v1/contexts/<ctx>/records)
+func (ls *LogServer) handleContextRecords(responseWriter http.ResponseWriter, request *http.Request) {
+	ctx := strings.TrimPrefix(request.URL.Path, "/api/v1/contexts/")
+	if !strings.HasSuffix(ctx, "/records") {
+		http.NotFound(responseWriter, request)
+		return
+	}
+
+	ctx = strings.TrimSuffix(ctx, "/records")
+
+	logRecords, total := ls.logRecordStore.Query((&LogQuery{Ctx: ctx}).Match, 0, 0)
+
+	ls.writeJSON(responseWriter, http.StatusOK, map[string]interface{}{
+		"ctx":     ctx,
+		"total":   total,
+		"records": ls.normalizeLogRecords(logRecords),
+	})
+}
+
+// handleEvents streams new records selected by the query string as server sent events
+func (ls *LogServer) handleEvents(responseWriter http.ResponseWriter, request *http.Request) {
+	flusher, canFlush := responseWriter.(http.Flusher)
+	if !canFlush {
+		http.Error(responseWriter, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	logQuery, err := ls.getLogQuery(request.URL.Query())
+	if err != nil {
+		ls.writeError(responseWriter, err)
+		return
+	}
+
+	newRecords, unsubscribe := ls.logRecordStore.Subscribe()
+	defer unsubscribe()
+
+	responseWriter.Header().Set("Content-Type", "text/event-stream")
+	responseWriter.Header().Set("Cache-Control", "no-cache")
+	responseWriter.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-request.Context().Done():
+			return
+
+		case logRecord := <-newRecords:
+			if !logQuery.Match(logRecord) {
+				continue
+			}
+
+			marshalledRecord, err := json.Marshal(logRecord.Normalize())
+			if err != nil {
+				continue
+			}
+
+			if _, err := fmt.Fprintf(responseWriter, "data: %s\n\n", marshalledRecord); err != nil {
+				return
+			}
+
+			flusher.Flush()
+		}
+	}
+}
+
+// getLogQuery creates a query from the query string: from and to (times), severity and who (comma separated
+// lists), ctx, q (text) and query (a filter expression, see ParseLogFilter)
+func (ls *LogServer) getLogQuery(query url.Values) (*LogQuery, error) {
+	var err error
+
+	logQuery := LogQuery{
+		Severities: ParseColumns(query.Get("severity")),
+		Whos:       ParseColumns(query.Get("who")),
+		Ctx:        query.Get("ctx"),
+		Text:       query.Get("q"),
+	}
+
+	for name, value := range map[string]*time.Time{"from": &logQuery.From, "to": &logQuery.To} {
+		if len(query.Get(name)) == 0 {
+			continue
+		}
+
+		if *value, err = ParseTime(query.Get(name)); err != nil {
+			return nil, errors.Wrapf(err, "Invalid %s", name)
+		}
+	}
+
+	if len(query.Get("query")) != 0 {
+		if logQuery.Filter, err = ParseLogFilter(query.Get("query")); err != nil {
+			return nil, errors.Wrap(err, "Invalid query")
+		}
+	}
+
+	return &logQuery, nil
+}
+
+func (ls *LogServer) getPagination(query url.Values) (int, int, error) {
+	offset, limit := 0, logServerDefaultPageSize
+
+	for name, value := range map[string]*int{"offset": &offset, "limit": &limit} {
+		if len(query.Get(name)) == 0 {
+			continue
+		}
+
+		parsedValue, err := strconv.Atoi(query.Get(name))
+		if err != nil || parsedValue < 0 {
+			return 0, 0, errors.New(fmt.Sprintf("Invalid %s: %s", name, query.Get(name)))
+		}
+
+		*value = parsedValue
+	}
+
+	return offset, limit, nil
+}
+
+func (ls *LogServer) normalizeLogRecords(logRecords []*LogRecord) []*NormalizedLogRecord {
+	normalizedLogRecords := make([]*NormalizedLogRecord, 0, len(logRecords))
+
+	for _, logRecord := range logRecords {
+		normalizedLogRecords = append(normalizedLogRecords, logRecord.Normalize())
+	}
+
+	return normalizedLogRecords
+}
+
+// writeError writes a bad request response with the error's messages, outermost first
+func (ls *LogServer) writeError(responseWriter http.ResponseWriter, err error) {
+	var messages []string
+
+	errorStack := errors.GetErrorStack(err, -1)
+	for errorIndex := len(errorStack) - 1; errorIndex >= 0; errorIndex-- {
+		messages = append(messages, errorStack[errorIndex].Error())
+	}
+
+	ls.writeJSON(responseWriter, http.StatusBadRequest, map[string]interface{}{
+		"error": strings.Join(messages, ": "),
+	})
+}
+
+func (ls *LogServer) writeJSON(responseWriter http.ResponseWriter, statusCode int, value interface{}) {
+	responseWriter.Header().Set("Content-Type", "application/json")
+	responseWriter.WriteHeader(statusCode)
+
+	if err := json.NewEncoder(responseWriter).Encode(value); err != nil {
+		ls.logger.WarnWith("Failed to write response", "err", err.Error())
+	}
+}
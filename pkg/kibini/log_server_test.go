@@ -0,0 +1,217 @@
+package kibini
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// logServerPage is a response of /api/v1/records
+type logServerPage struct {
+	Total   int                    `json:"total"`
+	Offset  int                    `json:"offset"`
+	Limit   int                    `json:"limit"`
+	Records []*NormalizedLogRecord `json:"records"`
+	Error   string                 `json:"error"`
+}
+
+func newTestLogServer(t *testing.T) (*LogRecordStore, *httptest.Server) {
+	logRecordStore := NewLogRecordStore()
+
+	logServer, err := NewLogServer(newTestLogger(t), logRecordStore, "")
+	if err != nil {
+		t.Fatalf("Failed to create server: %s", err)
+	}
+
+	server := httptest.NewServer(logServer)
+	t.Cleanup(server.Close)
+
+	return logRecordStore, server
+}
+
+func writeServerTestRecord(t *testing.T, logRecordStore *LogRecordStore, second int, who string, severity string, what string) {
+	logRecord := NewLogRecord(fmt.Sprintf(`{"when":"2023-01-02T10:00:%02d.000","who":"%s","severity":"%s","what":"%s"}`,
+		second,
+		who,
+		severity,
+		what))
+
+	if err := logRecordStore.Write(logRecord); err != nil {
+		t.Fatalf("Failed to write: %s", err)
+	}
+}
+
+func getLogServerPage(t *testing.T, server *httptest.Server, query string) (int, *logServerPage) {
+	response, err := http.Get(server.URL + "/api/v1/records?" + query)
+	if err != nil {
+		t.Fatalf("Failed to get records: %s", err)
+	}
+
+	defer response.Body.Close() // nolint: errcheck
+
+	page := logServerPage{}
+	if err := json.NewDecoder(response.Body).Decode(&page); err != nil {
+		t.Fatalf("Failed to decode records: %s", err)
+	}
+
+	return response.StatusCode, &page
+}
+
+func TestLogServerPagination(t *testing.T) {
+	logRecordStore, server := newTestLogServer(t)
+
+	for second := 0; second < 5; second++ {
+		writeServerTestRecord(t, logRecordStore, second, "api", "info", fmt.Sprintf("record %d", second))
+	}
+
+	for _, testCase := range []struct {
+		query          string
+		expectedLimit  int
+		expectedWhats  []string
+		expectedStatus int
+	}{
+		{query: "", expectedLimit: logServerDefaultPageSize, expectedWhats: []string{"record 0", "record 1", "record 2", "record 3", "record 4"}},
+		{query: "offset=1&limit=2", expectedLimit: 2, expectedWhats: []string{"record 1", "record 2"}},
+		{query: "offset=4&limit=2", expectedLimit: 2, expectedWhats: []string{"record 4"}},
+		{query: "offset=5", expectedLimit: logServerDefaultPageSize, expectedWhats: []string{}},
+		{query: "offset=100", expectedLimit: logServerDefaultPageSize, expectedWhats: []string{}},
+		{query: "offset=3&limit=0", expectedLimit: 0, expectedWhats: []string{"record 3", "record 4"}},
+		{query: "offset=-1", expectedStatus: http.StatusBadRequest},
+		{query: "limit=-1", expectedStatus: http.StatusBadRequest},
+		{query: "limit=ten", expectedStatus: http.StatusBadRequest},
+	} {
+		statusCode, page := getLogServerPage(t, server, testCase.query)
+
+		if testCase.expectedStatus == http.StatusBadRequest {
+			if statusCode != http.StatusBadRequest || len(page.Error) == 0 {
+				t.Errorf("Expected %q to be rejected, got %d", testCase.query, statusCode)
+			}
+
+			continue
+		}
+
+		if statusCode != http.StatusOK || page.Total != 5 || page.Limit != testCase.expectedLimit {
+			t.Errorf("Expected %q to return a page of 5 records limited to %d, got %d: %+v",
+				testCase.query,
+				testCase.expectedLimit,
+				statusCode,
+				page)
+
+			continue
+		}
+
+		var whats []string
+		for _, record := range page.Records {
+			whats = append(whats, record.What)
+		}
+
+		if page.Records == nil || strings.Join(whats, ",") != strings.Join(testCase.expectedWhats, ",") {
+			t.Errorf("Expected %q to return %v, got %v", testCase.query, testCase.expectedWhats, whats)
+		}
+	}
+}
+
+func TestLogServerFilters(t *testing.T) {
+	logRecordStore, server := newTestLogServer(t)
+
+	writeServerTestRecord(t, logRecordStore, 1, "api", "info", "request handled")
+	writeServerTestRecord(t, logRecordStore, 2, "api", "error", "request failed")
+	writeServerTestRecord(t, logRecordStore, 3, "db", "warn", "slow query")
+	writeServerTestRecord(t, logRecordStore, 4, "db", "error", "connection refused")
+
+	for _, testCase := range []struct {
+		query         string
+		expectedWhats []string
+	}{
+		{"severity=error", []string{"request failed", "connection refused"}},
+		{"severity=warn,error&who=db", []string{"slow query", "connection refused"}},
+		{"q=REQUEST", []string{"request handled", "request failed"}},
+		{"from=2023-01-02T10:00:02&to=2023-01-02T10:00:04", []string{"request failed", "slow query"}},
+		{"query=" + strings.ReplaceAll(`severity=error and not who=api`, " ", "+"), []string{"connection refused"}},
+		{"who=cache", nil},
+	} {
+		statusCode, page := getLogServerPage(t, server, testCase.query)
+
+		var whats []string
+		for _, record := range page.Records {
+			whats = append(whats, record.What)
+		}
+
+		if statusCode != http.StatusOK ||
+			page.Total != len(testCase.expectedWhats) ||
+			strings.Join(whats, ",") != strings.Join(testCase.expectedWhats, ",") {
+			t.Errorf("Expected %q to select %v, got %d: %v", testCase.query, testCase.expectedWhats, statusCode, whats)
+		}
+	}
+
+	for _, invalidQuery := range []string{"from=yesterday", "query=severity%3D", "query=(who=api"} {
+		if statusCode, page := getLogServerPage(t, server, invalidQuery); statusCode != http.StatusBadRequest || len(page.Error) == 0 {
+			t.Errorf("Expected %q to be rejected, got %d", invalidQuery, statusCode)
+		}
+	}
+}
+
+func TestLogServerEvents(t *testing.T) {
+	logRecordStore, server := newTestLogServer(t)
+
+	// records written before subscribing aren't sent
+	writeServerTestRecord(t, logRecordStore, 1, "api", "error", "before")
+
+	response, err := http.Get(server.URL + "/api/v1/events?severity=error")
+	if err != nil {
+		t.Fatalf("Failed to get events: %s", err)
+	}
+
+	defer response.Body.Close() // nolint: errcheck
+
+	if contentType := response.Header.Get("Content-Type"); contentType != "text/event-stream" {
+		t.Fatalf("Expected an event stream, got %s", contentType)
+	}
+
+	writeServerTestRecord(t, logRecordStore, 2, "api", "info", "filtered out")
+	writeServerTestRecord(t, logRecordStore, 3, "db", "error", "after")
+
+	events := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(response.Body)
+		for scanner.Scan() {
+			if line := scanner.Text(); strings.HasPrefix(line, "data: ") {
+				events <- strings.TrimPrefix(line, "data: ")
+			}
+		}
+
+		close(events)
+	}()
+
+	select {
+	case event := <-events:
+		var record NormalizedLogRecord
+		if err := json.Unmarshal([]byte(event), &record); err != nil {
+			t.Fatalf("Failed to decode event %q: %s", event, err)
+		}
+
+		if record.What != "after" || record.Who != "db" || record.When != "2023-01-02T10:00:03Z" {
+			t.Fatalf("Expected the error written after subscribing, got %+v", record)
+		}
+
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for an event")
+	}
+
+	// an invalid filter is rejected before streaming
+	invalidResponse, err := http.Get(server.URL + "/api/v1/events?from=yesterday")
+	if err != nil {
+		t.Fatalf("Failed to get events: %s", err)
+	}
+
+	invalidResponse.Body.Close() // nolint: errcheck
+
+	if invalidResponse.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected an invalid filter to be rejected, got %d", invalidResponse.StatusCode)
+	}
+}
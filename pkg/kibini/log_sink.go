@@ -0,0 +1,181 @@
+package kibini
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/nuclio/errors"
+)
+
+// LogSinkStdout is the path of a sink which writes to stdout
+const LogSinkStdout = "stdout"
+
+// a part of a sink which starts a setting (<key>=...), whether the key is known or not
+var logSinkSettingRegex = regexp.MustCompile(`^\s*\w+\s*=`)
+
+// LogSinkOptions describe an output of "single" mode. Each sink gets the merged records, and has its own path,
+// format, filter and color setting
+type LogSinkOptions struct {
+
+	// Path is the output file path, or LogSinkStdout
+	Path string
+
+	// OutputFormat is one of the output formats (see ProcessLogsOptions.OutputFormat)
+	OutputFormat OutputFormat
+
+	// ColorSetting is "on", "off" or "always". Empty means that of the options
+	ColorSetting string
+
+	// Filter is a filter expression (see ParseLogFilter). Only matching records are written to the sink. Empty
+	// means all records
+	Filter string
+
+	// Columns are the fields written in the CSV/TSV formats. Empty means those of the options
+	Columns []string
+
+	// FormatTemplate is a go text/template (or preset) used in the text format. Empty means that of the options
+	FormatTemplate string
+}
+
+// ParseLogSink parses a sink given as <path>[;<key>=<value>...] where keys are format (text, json, csv, tsv,
+// html or sqlite, by default according to the path's extension), color (on, off or always), filter (a filter
+// expression), columns (comma separated) and template. A path of "stdout" is stdout. Values may hold ";", either
+// escaped as "\;" or as long as what follows it doesn't look like another setting (see splitLogSink)
+func ParseLogSink(sink string) (*LogSinkOptions, error) {
+	parts := splitLogSink(sink)
+
+	logSinkOptions := LogSinkOptions{
+		Path: strings.TrimSpace(parts[0]),
+	}
+
+	if len(logSinkOptions.Path) == 0 {
+		return nil, errors.New(fmt.Sprintf("Invalid sink %q (expected <path>[;<key>=<value>...])", sink))
+	}
+
+	logSinkOptions.OutputFormat = getOutputFormatByExtension(logSinkOptions.Path)
+
+	for _, part := range parts[1:] {
+		separatorIndex := strings.Index(part, "=")
+		if separatorIndex <= 0 {
+			return nil, errors.New(fmt.Sprintf("Invalid sink setting %q of %s (expected <key>=<value>)", part, logSinkOptions.Path))
+		}
+
+		key, value := strings.TrimSpace(part[:separatorIndex]), part[separatorIndex+1:]
+
+		switch key {
+		case "format":
+			outputFormat, err := ParseOutputFormat(value)
+			if err != nil {
+				return nil, errors.Wrapf(err, "Invalid format of sink %s", logSinkOptions.Path)
+			}
+
+			logSinkOptions.OutputFormat = outputFormat
+
+		case "color":
+			if value != "on" && value != "off" && value != "always" {
+				return nil, errors.New(fmt.Sprintf("Invalid color setting of sink %s: %s (expected on, off or always)", logSinkOptions.Path, value))
+			}
+
+			logSinkOptions.ColorSetting = value
+
+		case "filter":
+			if _, err := ParseLogFilter(value); err != nil {
+				return nil, errors.Wrapf(err, "Invalid filter of sink %s", logSinkOptions.Path)
+			}
+
+			logSinkOptions.Filter = value
+
+		case "columns":
+			logSinkOptions.Columns = ParseColumns(value)
+
+		case "template":
+			logSinkOptions.FormatTemplate = value
+
+		default:
+			return nil, errors.New(fmt.Sprintf("Unknown sink setting %q of %s (expected format, color, filter, columns or template)", key, logSinkOptions.Path))
+		}
+	}
+
+	if logSinkOptions.Path == LogSinkStdout && logSinkOptions.OutputFormat == OutputFormatSQLite {
+		return nil, errors.New("sqlite output requires an output path, not stdout")
+	}
+
+	return &logSinkOptions, nil
+}
+
+// splitLogSink splits a sink into its path and settings on ";". An escaped "\;" doesn't split, and neither does a
+// ";" followed by something which doesn't start like a setting (<word>=...), which continues the value of the
+// setting before it (e.g. "template={{.Who}};{{.What}}" or `filter=what~"a;b"`). Anything starting like a setting
+// is one, so that a misspelled key is rejected rather than taken as part of a value
+func splitLogSink(sink string) []string {
+	var parts []string
+	var part strings.Builder
+
+	for index := 0; index < len(sink); index++ {
+		switch {
+		case strings.HasPrefix(sink[index:], `\;`):
+			part.WriteByte(';')
+			index++
+		case sink[index] == ';':
+			parts = append(parts, part.String())
+			part.Reset()
+		default:
+			part.WriteByte(sink[index])
+		}
+	}
+
+	parts = append(parts, part.String())
+
+	// the path and the first setting are never continued
+	mergedParts := []string{parts[0]}
+	for partIndex, part := range parts[1:] {
+		if partIndex != 0 && !logSinkSettingRegex.MatchString(part) {
+			mergedParts[len(mergedParts)-1] += ";" + part
+			continue
+		}
+
+		mergedParts = append(mergedParts, part)
+	}
+
+	return mergedParts
+}
+
+// getOutputFormatByExtension returns the output format whose extension the path has (see
+// GetOutputFileExtension), or OutputFormatText
+func getOutputFormatByExtension(path string) OutputFormat {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jsonl", ".json":
+		return OutputFormatJSON
+	case ".csv":
+		return OutputFormatCSV
+	case ".tsv":
+		return OutputFormatTSV
+	case ".html":
+		return OutputFormatHTML
+	case ".sqlite", ".db":
+		return OutputFormatSQLite
+	}
+
+	return OutputFormatText
+}
+
+// logFilterWriter is a LogWriter which writes only the records matching a filter
+type logFilterWriter struct {
+	logFilter LogFilter
+	logWriter LogWriter
+}
+
+func (lfw *logFilterWriter) Write(logRecord *LogRecord) error {
+	if !lfw.logFilter.Match(logRecord) {
+		return nil
+	}
+
+	return lfw.logWriter.Write(logRecord)
+}
+
+// Close closes the underlying writer
+func (lfw *logFilterWriter) Close() error {
+	return closeLogWriters([]LogWriter{lfw.logWriter})
+}
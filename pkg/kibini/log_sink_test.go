@@ -0,0 +1,59 @@
+package kibini
+
+import (
+	"testing"
+)
+
+func TestParseLogSink(t *testing.T) {
+	logSinkOptions, err := ParseLogSink("errors.jsonl;color=off;filter=severity=error;columns=when,who")
+	if err != nil {
+		t.Fatalf("Failed to parse sink: %s", err)
+	}
+
+	if logSinkOptions.Path != "errors.jsonl" ||
+		logSinkOptions.OutputFormat != OutputFormatJSON ||
+		logSinkOptions.ColorSetting != "off" ||
+		logSinkOptions.Filter != "severity=error" ||
+		len(logSinkOptions.Columns) != 2 {
+		t.Fatalf("Unexpected sink options: %+v", logSinkOptions)
+	}
+
+	for _, invalidSink := range []string{
+		"",
+		";format=json",
+		"out.txt;{{.What}}",
+		"out.txt;size=3",
+		"stdout;format=sqlite",
+		"out.log;template=x;colr=on",
+		`out.log;filter=what~"a;b=c"`,
+	} {
+		if _, err := ParseLogSink(invalidSink); err == nil {
+			t.Errorf("Expected sink %q to be invalid", invalidSink)
+		}
+	}
+}
+
+func TestParseLogSinkValuesWithSemicolons(t *testing.T) {
+	for _, testCase := range []struct {
+		sink           string
+		filter         string
+		formatTemplate string
+	}{
+		{sink: "s2.txt;template={{.Who}};{{.What}}", formatTemplate: "{{.Who}};{{.What}}"},
+		{sink: `s2.txt;template={{.Who}}\;{{.What}};color=off`, formatTemplate: "{{.Who}};{{.What}}"},
+		{sink: `s2.txt;filter=what~"a;b";template={{.What}}`, filter: `what~"a;b"`, formatTemplate: "{{.What}}"},
+		{sink: `s2.txt;filter=what~"a\;color=on"`, filter: `what~"a;color=on"`},
+		{sink: `s2.txt;filter=what~"a\;b=c";template={{.What}}`, filter: `what~"a;b=c"`, formatTemplate: "{{.What}}"},
+	} {
+		logSinkOptions, err := ParseLogSink(testCase.sink)
+		if err != nil {
+			t.Fatalf("Failed to parse sink %q: %s", testCase.sink, err)
+		}
+
+		if logSinkOptions.Path != "s2.txt" ||
+			logSinkOptions.Filter != testCase.filter ||
+			logSinkOptions.FormatTemplate != testCase.formatTemplate {
+			t.Fatalf("Unexpected options of sink %q: %+v", testCase.sink, logSinkOptions)
+		}
+	}
+}
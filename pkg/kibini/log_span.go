@@ -0,0 +1,543 @@
+package kibini
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nuclio/errors"
+)
+
+// the "more" keys with which end records are annotated (see LogSpanWriter)
+const (
+	spanNameKey     = "span"
+	spanDurationKey = "spanDuration"
+)
+
+// the regex group whose value names a span (e.g. "Starting (?P<name>.+)")
+const spanNameGroup = "name"
+
+// SpanConfig holds rules which pair start and end records into spans, as read from a YAML file (see
+// LoadSpanConfig)
+type SpanConfig struct {
+	Rules []SpanRuleConfig `yaml:"rules"`
+}
+
+// SpanRuleConfig pairs records whose "what" matches Start with later records whose "what" matches End and have
+// the same value of the Key field ("who" by default, or e.g. "ctx" or "more.RequestID"). If Start has a group
+// named "name", its value names the span and End must capture the same name (e.g. "Starting (?P<name>.+)" and
+// "Done (?P<name>.+)"). If Who is given, the rule only applies to records whose who matches it
+type SpanRuleConfig struct {
+	Name  string `yaml:"name"`
+	Who   string `yaml:"who"`
+	Start string `yaml:"start"`
+	End   string `yaml:"end"`
+	Key   string `yaml:"key"`
+}
+
+// LoadSpanConfig reads a span config from a YAML file
+func LoadSpanConfig(path string) (*SpanConfig, error) {
+	var spanConfig SpanConfig
+
+	if err := loadYAMLFile(path, &spanConfig); err != nil {
+		return nil, errors.Wrapf(err, "Failed to load span config %s", path)
+	}
+
+	return &spanConfig, nil
+}
+
+// SpansFormat determines how spans are written
+type SpansFormat int
+
+const (
+	SpansFormatTable SpansFormat = iota
+	SpansFormatJSON
+
+	// SpansFormatRecords writes every span as a JSON line
+	SpansFormatRecords
+)
+
+// SpansOptions determine which records are paired into spans and how they're reported
+type SpansOptions struct {
+	InputOptions
+
+	// Config holds the rules by which records are paired
+	Config *SpanConfig
+
+	// Format is SpansFormatTable, SpansFormatJSON or SpansFormatRecords
+	Format SpansFormat
+
+	// Top is the number of slowest and incomplete spans reported (0 for all)
+	Top int
+}
+
+// LogSpan is an operation which started with one record and (unless incomplete) ended with another
+type LogSpan struct {
+	Rule            string  `json:"rule"`
+	Name            string  `json:"name"`
+	Key             string  `json:"key"`
+	Who             string  `json:"who"`
+	Start           string  `json:"start"`
+	End             string  `json:"end,omitempty"`
+	Duration        string  `json:"duration,omitempty"`
+	DurationSeconds float64 `json:"durationSeconds,omitempty"`
+	StartSource     string  `json:"startSource"`
+	EndSource       string  `json:"endSource,omitempty"`
+	Complete        bool    `json:"complete"`
+
+	duration  time.Duration
+	startWhen time.Time
+}
+
+// LogSpanSummary summarizes the spans of a name
+type LogSpanSummary struct {
+	Rule       string `json:"rule"`
+	Name       string `json:"name"`
+	Count      int    `json:"count"`
+	Incomplete int    `json:"incomplete"`
+	Average    string `json:"average,omitempty"`
+	Max        string `json:"max,omitempty"`
+}
+
+// LogSpanReport holds the spans found in records
+type LogSpanReport struct {
+	Summaries  []*LogSpanSummary `json:"summaries"`
+	Slowest    []*LogSpan        `json:"slowest"`
+	Incomplete []*LogSpan        `json:"incomplete"`
+
+	spans []*LogSpan
+}
+
+// Spans pairs records into spans and writes the slowest and incomplete ones to stdout
+func (k *Kibini) Spans(options *SpansOptions) error {
+	logSpanReport, err := k.GetLogSpanReport(options)
+	if err != nil {
+		return errors.Wrap(err, "Failed to get spans")
+	}
+
+	switch options.Format {
+	case SpansFormatJSON:
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.SetEscapeHTML(false)
+
+		return encoder.Encode(logSpanReport)
+
+	case SpansFormatRecords:
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetEscapeHTML(false)
+
+		for _, logSpan := range logSpanReport.spans {
+			if err := encoder.Encode(logSpan); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	return writeLogSpanTables(os.Stdout, logSpanReport)
+}
+
+// GetLogSpanReport reads the records (merged, so that spans may cross files) and pairs them into spans. Spans
+// still open once the records end are incomplete, so files are never followed
+func (k *Kibini) GetLogSpanReport(options *SpansOptions) (*LogSpanReport, error) {
+	if options.Config == nil || len(options.Config.Rules) == 0 {
+		return nil, errors.New("No span rules given")
+	}
+
+	if options.Top < 0 {
+		return nil, errors.New("Top must not be negative")
+	}
+
+	logSpanPairer, err := newLogSpanPairer(options.Config)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to create span pairer")
+	}
+
+	logSpanCollector := logSpanCollector{logSpanPairer: logSpanPairer}
+
+	spansInputOptions := options.InputOptions
+	spansInputOptions.InputFollow = false
+
+	if err := k.ReadLogs(&spansInputOptions, []LogWriter{&logSpanCollector}); err != nil {
+		return nil, errors.Wrap(err, "Failed to read logs")
+	}
+
+	logSpanReport := LogSpanReport{
+		Summaries:  []*LogSpanSummary{},
+		Slowest:    []*LogSpan{},
+		Incomplete: logSpanPairer.getIncompleteSpans(),
+		spans:      logSpanCollector.spans,
+	}
+
+	logSpanReport.spans = append(logSpanReport.spans, logSpanReport.Incomplete...)
+
+	// summarize per rule and name
+	logSpanSummaries := map[[2]string]*LogSpanSummary{}
+	totalDurations := map[[2]string]time.Duration{}
+	maxDurations := map[[2]string]time.Duration{}
+
+	for _, logSpan := range logSpanReport.spans {
+		key := [2]string{logSpan.Rule, logSpan.Name}
+
+		logSpanSummary, found := logSpanSummaries[key]
+		if !found {
+			logSpanSummary = &LogSpanSummary{Rule: logSpan.Rule, Name: logSpan.Name}
+			logSpanSummaries[key] = logSpanSummary
+			logSpanReport.Summaries = append(logSpanReport.Summaries, logSpanSummary)
+		}
+
+		if !logSpan.Complete {
+			logSpanSummary.Incomplete++
+			continue
+		}
+
+		logSpanSummary.Count++
+		totalDurations[key] += logSpan.duration
+
+		if logSpan.duration > maxDurations[key] {
+			maxDurations[key] = logSpan.duration
+		}
+	}
+
+	for key, logSpanSummary := range logSpanSummaries {
+		if logSpanSummary.Count != 0 {
+			logSpanSummary.Average = formatTraceDuration(totalDurations[key] / time.Duration(logSpanSummary.Count))
+			logSpanSummary.Max = formatTraceDuration(maxDurations[key])
+		}
+	}
+
+	sort.SliceStable(logSpanReport.Summaries, func(i, j int) bool {
+		if logSpanReport.Summaries[i].Rule != logSpanReport.Summaries[j].Rule {
+			return logSpanReport.Summaries[i].Rule < logSpanReport.Summaries[j].Rule
+		}
+
+		return logSpanReport.Summaries[i].Name < logSpanReport.Summaries[j].Name
+	})
+
+	// the slowest complete spans, and the earliest incomplete ones
+	logSpanReport.Slowest = append(logSpanReport.Slowest, logSpanCollector.spans...)
+	sort.SliceStable(logSpanReport.Slowest, func(i, j int) bool {
+		return logSpanReport.Slowest[i].duration > logSpanReport.Slowest[j].duration
+	})
+
+	if options.Top != 0 && len(logSpanReport.Slowest) > options.Top {
+		logSpanReport.Slowest = logSpanReport.Slowest[:options.Top]
+	}
+
+	if options.Top != 0 && len(logSpanReport.Incomplete) > options.Top {
+		logSpanReport.Incomplete = logSpanReport.Incomplete[:options.Top]
+	}
+
+	return &logSpanReport, nil
+}
+
+func writeLogSpanTables(writer io.Writer, logSpanReport *LogSpanReport) error {
+	if len(logSpanReport.Summaries) == 0 {
+		_, err := fmt.Fprintln(writer, "No spans")
+		return err
+	}
+
+	var summaryRows [][]string
+	for _, logSpanSummary := range logSpanReport.Summaries {
+		summaryRows = append(summaryRows, []string{
+			logSpanSummary.Rule,
+			logSpanSummary.Name,
+			strconv.Itoa(logSpanSummary.Count),
+			strconv.Itoa(logSpanSummary.Incomplete),
+			logSpanSummary.Average,
+			logSpanSummary.Max,
+		})
+	}
+
+	if err := writeTable(writer, []string{"RULE", "NAME", "COMPLETE", "INCOMPLETE", "AVERAGE", "MAX"}, summaryRows); err != nil {
+		return err
+	}
+
+	for _, section := range []struct {
+		title string
+		spans []*LogSpan
+	}{
+		{"Slowest spans", logSpanReport.Slowest},
+		{"Incomplete spans", logSpanReport.Incomplete},
+	} {
+		if len(section.spans) == 0 {
+			continue
+		}
+
+		var rows [][]string
+		for _, logSpan := range section.spans {
+			rows = append(rows, []string{
+				logSpan.Duration,
+				logSpan.Name,
+				logSpan.Key,
+				logSpan.Start,
+				logSpan.StartSource,
+				logSpan.EndSource,
+			})
+		}
+
+		if _, err := fmt.Fprintf(writer, "\n%s:\n", section.title); err != nil {
+			return err
+		}
+
+		if err := writeTable(writer, []string{"DURATION", "NAME", "KEY", "START", "START SOURCE", "END SOURCE"}, rows); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// LogSpanWriter pairs the records written to it into spans and annotates each record which ends a span with the
+// span's name ("span") and duration ("spanDuration") in "more", before writing it to the underlying writer.
+// Annotated records are copies, so that other writers of the same records aren't affected
+type LogSpanWriter struct {
+	logSpanPairer *logSpanPairer
+	logWriter     LogWriter
+}
+
+// NewLogSpanWriter creates a LogSpanWriter which writes to logWriter
+func NewLogSpanWriter(config *SpanConfig, logWriter LogWriter) (*LogSpanWriter, error) {
+	logSpanPairer, err := newLogSpanPairer(config)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to create span pairer")
+	}
+
+	return &LogSpanWriter{
+		logSpanPairer: logSpanPairer,
+		logWriter:     logWriter,
+	}, nil
+}
+
+func (lsw *LogSpanWriter) Write(logRecord *LogRecord) error {
+	logSpan := lsw.logSpanPairer.pair(logRecord)
+	if logSpan == nil {
+		return lsw.logWriter.Write(logRecord)
+	}
+
+	annotatedRecord := *logRecord
+	annotatedRecord.More = make(map[string]*json.RawMessage, len(logRecord.More)+2)
+
+	for key, value := range logRecord.More {
+		annotatedRecord.More[key] = value
+	}
+
+	for key, value := range map[string]string{
+		spanNameKey:     logSpan.Name,
+		spanDurationKey: logSpan.Duration,
+	} {
+		marshalledValue, err := json.Marshal(value)
+		if err != nil {
+			return errors.Wrapf(err, "Failed to marshal %s", key)
+		}
+
+		rawValue := json.RawMessage(marshalledValue)
+		annotatedRecord.More[key] = &rawValue
+	}
+
+	return lsw.logWriter.Write(&annotatedRecord)
+}
+
+// Close closes the underlying writer
+func (lsw *LogSpanWriter) Close() error {
+	return closeLogWriters([]LogWriter{lsw.logWriter})
+}
+
+// logSpanCollector is a LogWriter which collects the spans of the records written to it
+type logSpanCollector struct {
+	logSpanPairer *logSpanPairer
+	spans         []*LogSpan
+}
+
+func (lsc *logSpanCollector) Write(logRecord *LogRecord) error {
+	if logSpan := lsc.logSpanPairer.pair(logRecord); logSpan != nil {
+		lsc.spans = append(lsc.spans, logSpan)
+	}
+
+	return nil
+}
+
+// logSpanPairer pairs start and end records by the rules of a span config. Records must arrive in time order
+type logSpanPairer struct {
+	rules []*logSpanRule
+
+	// open spans by rule, key and name. When spans of the same key and name nest, the latest is ended first
+	openSpans map[string][]*LogSpan
+}
+
+type logSpanRule struct {
+	name  string
+	who   *regexp.Regexp
+	start *regexp.Regexp
+	end   *regexp.Regexp
+	key   string
+}
+
+func newLogSpanPairer(config *SpanConfig) (*logSpanPairer, error) {
+	logSpanPairer := logSpanPairer{
+		openSpans: map[string][]*LogSpan{},
+	}
+
+	for ruleIndex, ruleConfig := range config.Rules {
+		if len(ruleConfig.Name) == 0 {
+			ruleConfig.Name = fmt.Sprintf("#%d", ruleIndex+1)
+		}
+
+		rule, err := newLogSpanRule(&ruleConfig)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Invalid span rule %s", ruleConfig.Name)
+		}
+
+		logSpanPairer.rules = append(logSpanPairer.rules, rule)
+	}
+
+	return &logSpanPairer, nil
+}
+
+func newLogSpanRule(config *SpanRuleConfig) (*logSpanRule, error) {
+	var err error
+
+	rule := logSpanRule{
+		name: config.Name,
+		key:  config.Key,
+	}
+
+	if len(rule.key) == 0 {
+		rule.key = "who"
+	}
+
+	if !IsValidFieldName(rule.key) {
+		return nil, errors.New(fmt.Sprintf("Unknown key field: %s (expected a record field or more.<key>)", rule.key))
+	}
+
+	if len(config.Who) != 0 {
+		if rule.who, err = regexp.Compile(config.Who); err != nil {
+			return nil, errors.Wrapf(err, "Invalid who regular expression %s", config.Who)
+		}
+	}
+
+	if len(config.Start) == 0 || len(config.End) == 0 {
+		return nil, errors.New("Both a start and an end pattern are required")
+	}
+
+	if rule.start, err = regexp.Compile(config.Start); err != nil {
+		return nil, errors.Wrapf(err, "Invalid start regular expression %s", config.Start)
+	}
+
+	if rule.end, err = regexp.Compile(config.End); err != nil {
+		return nil, errors.Wrapf(err, "Invalid end regular expression %s", config.End)
+	}
+
+	return &rule, nil
+}
+
+// pair opens a span if the record starts one, and returns the span it ends if it ends one (nil otherwise)
+func (lsp *logSpanPairer) pair(logRecord *LogRecord) *LogSpan {
+	var endedSpan *LogSpan
+
+	for _, rule := range lsp.rules {
+		if rule.who != nil && !rule.who.MatchString(logRecord.Who) {
+			continue
+		}
+
+		keyValue, found := logRecord.GetField(rule.key)
+		if !found {
+			continue
+		}
+
+		key := flattenValue(keyValue)
+
+		// a record may end a span and start another (e.g. "Done A, starting B")
+		if name, matched := rule.match(rule.end, logRecord.What); matched && endedSpan == nil {
+			endedSpan = lsp.end(rule, key, name, logRecord)
+		}
+
+		if name, matched := rule.match(rule.start, logRecord.What); matched {
+			lsp.start(rule, key, name, logRecord)
+		}
+	}
+
+	return endedSpan
+}
+
+func (lsp *logSpanPairer) start(rule *logSpanRule, key string, name string, logRecord *LogRecord) {
+	openSpanKey := strings.Join([]string{rule.name, key, name}, "\x00")
+
+	lsp.openSpans[openSpanKey] = append(lsp.openSpans[openSpanKey], &LogSpan{
+		Rule:        rule.name,
+		Name:        name,
+		Key:         key,
+		Who:         logRecord.Who,
+		Start:       logRecord.When.Format(time.RFC3339Nano),
+		StartSource: fmt.Sprintf("%s:%d", logRecord.SourceFile, logRecord.LineNumber),
+		startWhen:   logRecord.When,
+	})
+}
+
+func (lsp *logSpanPairer) end(rule *logSpanRule, key string, name string, logRecord *LogRecord) *LogSpan {
+	openSpanKey := strings.Join([]string{rule.name, key, name}, "\x00")
+
+	openSpans := lsp.openSpans[openSpanKey]
+	if len(openSpans) == 0 {
+		return nil
+	}
+
+	logSpan := openSpans[len(openSpans)-1]
+
+	if len(openSpans) == 1 {
+		delete(lsp.openSpans, openSpanKey)
+	} else {
+		lsp.openSpans[openSpanKey] = openSpans[:len(openSpans)-1]
+	}
+
+	logSpan.duration = logRecord.When.Sub(logSpan.startWhen)
+	logSpan.End = logRecord.When.Format(time.RFC3339Nano)
+	logSpan.Duration = formatTraceDuration(logSpan.duration)
+	logSpan.DurationSeconds = logSpan.duration.Seconds()
+	logSpan.EndSource = fmt.Sprintf("%s:%d", logRecord.SourceFile, logRecord.LineNumber)
+	logSpan.Complete = true
+
+	return logSpan
+}
+
+// getIncompleteSpans returns the spans which are still open, by start time
+func (lsp *logSpanPairer) getIncompleteSpans() []*LogSpan {
+	incompleteSpans := []*LogSpan{}
+
+	for _, openSpans := range lsp.openSpans {
+		incompleteSpans = append(incompleteSpans, openSpans...)
+	}
+
+	sort.SliceStable(incompleteSpans, func(i, j int) bool {
+		if !incompleteSpans[i].startWhen.Equal(incompleteSpans[j].startWhen) {
+			return incompleteSpans[i].startWhen.Before(incompleteSpans[j].startWhen)
+		}
+
+		return incompleteSpans[i].StartSource < incompleteSpans[j].StartSource
+	})
+
+	return incompleteSpans
+}
+
+// match returns whether the pattern matches what, and the name of the span: the value of the pattern's "name"
+// group if it has one, otherwise the rule's name
+func (lsr *logSpanRule) match(pattern *regexp.Regexp, what string) (string, bool) {
+	submatches := pattern.FindStringSubmatch(what)
+	if submatches == nil {
+		return "", false
+	}
+
+	if groupIndex := pattern.SubexpIndex(spanNameGroup); groupIndex >= 0 && len(submatches[groupIndex]) != 0 {
+		return submatches[groupIndex], true
+	}
+
+	return lsr.name, true
+}
@@ -0,0 +1,124 @@
+package kibini
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func getTestLogSpanReport(t *testing.T, top int, lines ...string) *LogSpanReport {
+	inputPath := writeTestLogFile(t, "input.log", lines...)
+
+	var logSpanReport *LogSpanReport
+	var err error
+
+	// following is ignored, since spans are only reported once the records end
+	requireReturns(t, func() {
+		logSpanReport, err = NewKibini(newTestLogger(t)).GetLogSpanReport(&SpansOptions{
+			InputOptions: InputOptions{
+				InputPath:   filepath.Dir(inputPath),
+				InputFollow: true,
+			},
+			Config: &SpanConfig{
+				Rules: []SpanRuleConfig{{
+					Name:  "jobs",
+					Start: "^Starting (?P<name>\\w+)",
+					End:   "^Done (?P<name>\\w+)",
+				}},
+			},
+			Top: top,
+		})
+	})
+
+	if err != nil {
+		t.Fatalf("Failed to get spans: %s", err)
+	}
+
+	return logSpanReport
+}
+
+func TestLogSpanReportPairsStartsAndEnds(t *testing.T) {
+	logSpanReport := getTestLogSpanReport(t, 0,
+		`{"when":"2023-01-02T10:00:00.000","who":"api","severity":"info","what":"Starting build"}`,
+		`{"when":"2023-01-02T10:00:01.000","who":"db","severity":"info","what":"Starting build"}`,
+		`{"when":"2023-01-02T10:00:02.000","who":"api","severity":"info","what":"Starting deploy"}`,
+		`{"when":"2023-01-02T10:00:03.000","who":"api","severity":"info","what":"Done build"}`,
+		`{"when":"2023-01-02T10:00:05.000","who":"api","severity":"info","what":"Done deploy"}`,
+		`{"when":"2023-01-02T10:00:06.000","who":"db","severity":"info","what":"Done build"}`,
+		`{"when":"2023-01-02T10:00:07.000","who":"api","severity":"info","what":"Done build"}`)
+
+	// each end is paired with the start of the same who and name. the last end has no start and is ignored.
+	// spans of the same duration are in the order they ended
+	if len(logSpanReport.Slowest) != 3 || len(logSpanReport.Incomplete) != 0 {
+		t.Fatalf("Expected 3 complete spans, got %d complete and %d incomplete",
+			len(logSpanReport.Slowest),
+			len(logSpanReport.Incomplete))
+	}
+
+	for spanIndex, expectedSpan := range []struct {
+		name     string
+		who      string
+		duration string
+	}{
+		{"build", "db", "5s"},
+		{"build", "api", "3s"},
+		{"deploy", "api", "3s"},
+	} {
+		logSpan := logSpanReport.Slowest[spanIndex]
+
+		if logSpan.Name != expectedSpan.name || logSpan.Who != expectedSpan.who || logSpan.Duration != expectedSpan.duration {
+			t.Fatalf("Expected span %d to be %+v, got %+v", spanIndex, expectedSpan, logSpan)
+		}
+	}
+
+	if len(logSpanReport.Summaries) != 2 ||
+		logSpanReport.Summaries[0].Name != "build" ||
+		logSpanReport.Summaries[0].Count != 2 ||
+		logSpanReport.Summaries[0].Max != "5s" {
+		t.Fatalf("Expected summaries of build and deploy, got %+v", logSpanReport.Summaries)
+	}
+}
+
+func TestLogSpanReportUnmatchedStartsAndTop(t *testing.T) {
+	lines := []string{
+		`{"when":"2023-01-02T10:00:00.000","who":"api","severity":"info","what":"Starting build"}`,
+		`{"when":"2023-01-02T10:00:01.000","who":"api","severity":"info","what":"Starting deploy"}`,
+		`{"when":"2023-01-02T10:00:02.000","who":"api","severity":"info","what":"Done deploy"}`,
+		`{"when":"2023-01-02T10:00:03.000","who":"db","severity":"info","what":"Starting build"}`,
+		`{"when":"2023-01-02T10:00:04.000","who":"db","severity":"info","what":"Starting deploy"}`,
+		`{"when":"2023-01-02T10:00:09.000","who":"db","severity":"info","what":"Done deploy"}`,
+	}
+
+	// starts which never end are incomplete, the earliest first
+	logSpanReport := getTestLogSpanReport(t, 0, lines...)
+
+	if len(logSpanReport.Incomplete) != 2 ||
+		logSpanReport.Incomplete[0].Who != "api" ||
+		logSpanReport.Incomplete[1].Who != "db" ||
+		logSpanReport.Incomplete[0].Complete {
+		t.Fatalf("Expected the builds to be incomplete, got %+v", logSpanReport.Incomplete)
+	}
+
+	if len(logSpanReport.Summaries) != 2 || logSpanReport.Summaries[0].Incomplete != 2 || logSpanReport.Summaries[0].Count != 0 {
+		t.Fatalf("Expected 2 incomplete builds, got %+v", logSpanReport.Summaries)
+	}
+
+	// top limits the slowest and the incomplete spans, but not the summaries
+	logSpanReport = getTestLogSpanReport(t, 1, lines...)
+
+	if len(logSpanReport.Slowest) != 1 || logSpanReport.Slowest[0].Who != "db" || len(logSpanReport.Incomplete) != 1 {
+		t.Fatalf("Expected the slowest and the earliest incomplete span, got %+v and %+v",
+			logSpanReport.Slowest,
+			logSpanReport.Incomplete)
+	}
+
+	if len(logSpanReport.Summaries) != 2 || logSpanReport.Summaries[1].Count != 2 {
+		t.Fatalf("Expected summaries of all the spans, got %+v", logSpanReport.Summaries)
+	}
+
+	if _, err := NewKibini(newTestLogger(t)).GetLogSpanReport(&SpansOptions{
+		Config: &SpanConfig{Rules: []SpanRuleConfig{{Start: "a", End: "b"}}},
+		Top:    -1,
+	}); err == nil {
+		t.Fatal("Expected a negative top to be rejected")
+	}
+}
@@ -0,0 +1,390 @@
+package kibini
+
+import (
+	"bufio"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/nuclio/errors"
+	"modernc.org/sqlite"
+)
+
+// SQLResultFormat determines how query results are written
+type SQLResultFormat int
+
+const (
+	SQLResultFormatTable SQLResultFormat = iota
+	SQLResultFormatCSV
+	SQLResultFormatJSON
+)
+
+// SQLResult holds the columns and rows returned by a query
+type SQLResult struct {
+	Columns []string
+	Rows    [][]interface{}
+}
+
+var registerSQLiteFunctionsOnce sync.Once
+
+// registerSQLiteFunctions registers helpers for working with the "more" JSON column:
+//
+//	more(more, 'request.id')   - the value at a dotted path (see LogRecord.GetField), objects as JSON
+//	more_has(more, 'key')      - 1 if the dotted path exists, 0 otherwise
+//	more_keys(more)            - the top level keys, comma separated and sorted
+func registerSQLiteFunctions() {
+	registerSQLiteFunctionsOnce.Do(func() {
+		sqlite.MustRegisterDeterministicScalarFunction("more", 2, func(ctx *sqlite.FunctionContext,
+			args []driver.Value) (driver.Value, error) {
+			value, found := getSQLiteMoreValue(args)
+			if !found {
+				return nil, nil
+			}
+
+			return getSQLiteValue(value), nil
+		})
+
+		sqlite.MustRegisterDeterministicScalarFunction("more_has", 2, func(ctx *sqlite.FunctionContext,
+			args []driver.Value) (driver.Value, error) {
+			_, found := getSQLiteMoreValue(args)
+			return found, nil
+		})
+
+		sqlite.MustRegisterDeterministicScalarFunction("more_keys", 1, func(ctx *sqlite.FunctionContext,
+			args []driver.Value) (driver.Value, error) {
+			more, valid := parseSQLiteMore(args[0])
+			if !valid {
+				return nil, nil
+			}
+
+			return strings.Join(getSortedKeys(more), ","), nil
+		})
+	})
+}
+
+// LoadSQLDatabase reads the log files into a sqlite database (see LogSQLiteWriter for the schema). An
+// empty databasePath means an in-memory database, otherwise an existing database file is replaced
+func (k *Kibini) LoadSQLDatabase(inputOptions *InputOptions, databasePath string) (*sql.DB, error) {
+	dataSourceName := ":memory:"
+
+	if len(databasePath) != 0 {
+		dataSourceName = databasePath
+
+		if err := os.Remove(databasePath); err != nil && !os.IsNotExist(err) {
+			return nil, errors.Wrap(err, "Failed to remove existing database")
+		}
+	}
+
+	db, err := openSQLiteDatabase(dataSourceName)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to open database")
+	}
+
+	sqliteWriter := newLogSQLiteWriterForDB(k.logger, db, 0)
+
+	if err := k.ReadLogs(inputOptions, []LogWriter{sqliteWriter}); err != nil {
+		sqliteWriter.Close() // nolint: errcheck
+		db.Close()           // nolint: errcheck
+
+		return nil, errors.Wrap(err, "Failed to read logs")
+	}
+
+	// flush whatever's pending
+	if err := sqliteWriter.Close(); err != nil {
+		db.Close() // nolint: errcheck
+
+		return nil, errors.Wrap(err, "Failed to close sqlite writer")
+	}
+
+	return db, nil
+}
+
+// QuerySQL runs a query and returns all of its rows
+func QuerySQL(db *sql.DB, query string) (*SQLResult, error) {
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to run query")
+	}
+
+	defer rows.Close() // nolint: errcheck
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to get columns")
+	}
+
+	result := SQLResult{Columns: columns}
+
+	for rows.Next() {
+		row := make([]interface{}, len(columns))
+		rowPointers := make([]interface{}, len(columns))
+
+		for columnIndex := range row {
+			rowPointers[columnIndex] = &row[columnIndex]
+		}
+
+		if err := rows.Scan(rowPointers...); err != nil {
+			return nil, errors.Wrap(err, "Failed to scan row")
+		}
+
+		// text may be returned as bytes
+		for columnIndex, value := range row {
+			if bytesValue, isBytes := value.([]byte); isBytes {
+				row[columnIndex] = string(bytesValue)
+			}
+		}
+
+		result.Rows = append(result.Rows, row)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "Failed to iterate rows")
+	}
+
+	return &result, nil
+}
+
+// WriteSQLResult writes the result as an aligned table, CSV (with a header) or JSON lines (an object per row)
+func WriteSQLResult(writer io.Writer, result *SQLResult, resultFormat SQLResultFormat) error {
+	switch resultFormat {
+	case SQLResultFormatJSON:
+		encoder := json.NewEncoder(writer)
+
+		for _, row := range result.Rows {
+			rowObject := make(map[string]interface{}, len(result.Columns))
+			for columnIndex, column := range result.Columns {
+				rowObject[column] = row[columnIndex]
+			}
+
+			if err := encoder.Encode(rowObject); err != nil {
+				return errors.Wrap(err, "Failed to encode row")
+			}
+		}
+
+		return nil
+
+	case SQLResultFormatCSV:
+		csvWriter := csv.NewWriter(writer)
+
+		if err := csvWriter.Write(result.Columns); err != nil {
+			return errors.Wrap(err, "Failed to write header")
+		}
+
+		for _, row := range result.Rows {
+			if err := csvWriter.Write(formatSQLRow(row)); err != nil {
+				return errors.Wrap(err, "Failed to write row")
+			}
+		}
+
+		csvWriter.Flush()
+		return csvWriter.Error()
+
+	default:
+		var rows [][]string
+		for _, row := range result.Rows {
+			rows = append(rows, formatSQLRow(row))
+		}
+
+		if err := writeTable(writer, result.Columns, rows); err != nil {
+			return errors.Wrap(err, "Failed to write table")
+		}
+
+		_, err := fmt.Fprintf(writer, "(%d rows)\n", len(result.Rows))
+		return err
+	}
+}
+
+// SQLREPL reads queries from a reader and writes their results, until EOF or ".quit"
+type SQLREPL struct {
+	db           *sql.DB
+	reader       io.Reader
+	writer       io.Writer
+	resultFormat SQLResultFormat
+}
+
+// NewSQLREPL creates an SQLREPL
+func NewSQLREPL(db *sql.DB, reader io.Reader, writer io.Writer, resultFormat SQLResultFormat) *SQLREPL {
+	return &SQLREPL{
+		db:           db,
+		reader:       reader,
+		writer:       writer,
+		resultFormat: resultFormat,
+	}
+}
+
+// Run reads statements (terminated by ';') and dot commands until EOF or ".quit"
+func (sr *SQLREPL) Run() error {
+	var pendingQuery string
+
+	scanner := bufio.NewScanner(sr.reader)
+	sr.writePrompt(pendingQuery)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		switch {
+		case len(pendingQuery) == 0 && strings.HasPrefix(line, "."):
+			if quit := sr.runDotCommand(line); quit {
+				return nil
+			}
+
+		case len(line) != 0:
+			pendingQuery += line + "\n"
+
+			// a statement ends with a semicolon
+			if strings.HasSuffix(line, ";") {
+				sr.runQuery(pendingQuery)
+				pendingQuery = ""
+			}
+		}
+
+		sr.writePrompt(pendingQuery)
+	}
+
+	return scanner.Err()
+}
+
+func (sr *SQLREPL) runQuery(query string) {
+	result, err := QuerySQL(sr.db, query)
+	if err != nil {
+		fmt.Fprintf(sr.writer, "Error: %s\n", errors.Cause(err)) // nolint: errcheck
+		return
+	}
+
+	if err := WriteSQLResult(sr.writer, result, sr.resultFormat); err != nil {
+		fmt.Fprintf(sr.writer, "Error: %s\n", err) // nolint: errcheck
+	}
+}
+
+// runDotCommand runs a REPL command, returning true if the REPL should quit
+func (sr *SQLREPL) runDotCommand(line string) bool {
+	fields := strings.Fields(line)
+
+	switch fields[0] {
+	case ".quit", ".exit":
+		return true
+
+	case ".tables":
+		sr.runQuery("SELECT name FROM sqlite_master WHERE type IN ('table', 'view') ORDER BY name")
+
+	case ".schema":
+		sr.runQuery("SELECT sql FROM sqlite_master WHERE sql IS NOT NULL ORDER BY name")
+
+	case ".mode":
+		resultFormats := map[string]SQLResultFormat{
+			"table": SQLResultFormatTable,
+			"csv":   SQLResultFormatCSV,
+			"json":  SQLResultFormatJSON,
+		}
+
+		if len(fields) == 2 {
+			if resultFormat, found := resultFormats[fields[1]]; found {
+				sr.resultFormat = resultFormat
+				break
+			}
+		}
+
+		fmt.Fprintln(sr.writer, "Usage: .mode table|csv|json") // nolint: errcheck
+
+	default:
+		fmt.Fprint(sr.writer, `Statements end with ';'. Commands:
+  .tables                 list tables
+  .schema                 show the schema
+  .mode table|csv|json    set the result format
+  .quit                   exit
+Helper functions: more(more, 'path'), more_has(more, 'path'), more_keys(more)
+`) // nolint: errcheck
+	}
+
+	return false
+}
+
+func (sr *SQLREPL) writePrompt(pendingQuery string) {
+	prompt := "kibini> "
+	if len(pendingQuery) != 0 {
+		prompt = "   ...> "
+	}
+
+	fmt.Fprint(sr.writer, prompt) // nolint: errcheck
+}
+
+func formatSQLRow(row []interface{}) []string {
+	formattedRow := make([]string, len(row))
+
+	for columnIndex, value := range row {
+		if value == nil {
+			formattedRow[columnIndex] = "NULL"
+		} else {
+			formattedRow[columnIndex] = fmt.Sprint(value)
+		}
+	}
+
+	return formattedRow
+}
+
+// getSQLiteMoreValue returns the value at the path (args[1]) of the more JSON (args[0])
+func getSQLiteMoreValue(args []driver.Value) (interface{}, bool) {
+	more, valid := parseSQLiteMore(args[0])
+	if !valid {
+		return nil, false
+	}
+
+	path, isString := args[1].(string)
+	if !isString {
+		return nil, false
+	}
+
+	return getValueByPath(more, path)
+}
+
+func parseSQLiteMore(value driver.Value) (map[string]interface{}, bool) {
+	var more interface{}
+	var moreJSON []byte
+
+	switch typedValue := value.(type) {
+	case string:
+		moreJSON = []byte(typedValue)
+	case []byte:
+		moreJSON = typedValue
+	default:
+		return nil, false
+	}
+
+	if err := unmarshalUsingNumber(moreJSON, &more); err != nil {
+		return nil, false
+	}
+
+	moreMap, isMap := more.(map[string]interface{})
+	return moreMap, isMap
+}
+
+// getSQLiteValue converts a typed JSON value to a value sqlite accepts
+func getSQLiteValue(value interface{}) driver.Value {
+	switch typedValue := value.(type) {
+	case nil, string, bool:
+		return typedValue
+	case json.Number:
+		if intValue, err := typedValue.Int64(); err == nil {
+			return intValue
+		}
+
+		if floatValue, err := typedValue.Float64(); err == nil {
+			return floatValue
+		}
+
+		return typedValue.String()
+	default:
+		marshalledValue, err := json.Marshal(typedValue)
+		if err != nil {
+			return nil
+		}
+
+		return string(marshalledValue)
+	}
+}
@@ -0,0 +1,259 @@
+package kibini
+
+import (
+	"database/sql"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/nuclio/errors"
+	"github.com/nuclio/logger"
+	_ "modernc.org/sqlite" // pure go sqlite driver
+)
+
+const (
+	sqliteDefaultBatchSize      = 5000
+	sqliteBatchFlushInterval    = time.Second
+	sqliteRecordTimeFormat      = "2006-01-02 15:04:05.000000000"
+	sqliteInsertRecordStatement = `INSERT INTO records ("when", when_unix_nano, source, line, who, severity, what, ctx, more)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+)
+
+// the records table holds log records in the normalized schema. "when" is UTC in a format sqlite date
+// functions understand (e.g. strftime('%Y-%m-%d %H:%M', "when")) and "more" is JSON (e.g. json_extract)
+var sqliteSchemaStatements = []string{
+	`CREATE TABLE IF NOT EXISTS records (
+		id INTEGER PRIMARY KEY,
+		"when" TEXT NOT NULL,
+		when_unix_nano INTEGER NOT NULL,
+		source TEXT,
+		line INTEGER,
+		who TEXT,
+		severity TEXT,
+		what TEXT,
+		ctx TEXT,
+		more TEXT
+	)`,
+	`CREATE INDEX IF NOT EXISTS records_when ON records ("when")`,
+	`CREATE INDEX IF NOT EXISTS records_who ON records (who)`,
+	`CREATE INDEX IF NOT EXISTS records_severity ON records (severity)`,
+	`CREATE INDEX IF NOT EXISTS records_ctx ON records (ctx)`,
+}
+
+// LogSQLiteWriter writes log records into a sqlite database, in batches
+type LogSQLiteWriter struct {
+	logger         logger.Logger
+	db             *sql.DB
+	ownsDB         bool
+	batchSize      int
+	lock           sync.Mutex
+	pendingRecords []*LogRecord
+	flushErr       error
+	stopFlushing   chan struct{}
+	flushingDone   chan struct{}
+}
+
+// NewLogSQLiteWriter creates a sqlite database at outputFilePath (replacing an existing one) and returns a
+// writer which inserts records into it. A batchSize of 0 means the default batch size
+func NewLogSQLiteWriter(logger logger.Logger, outputFilePath string, batchSize int) (*LogSQLiteWriter, error) {
+
+	// start from a clean database, like other outputs which truncate their files
+	if err := os.Remove(outputFilePath); err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "Failed to remove existing database")
+	}
+
+	db, err := openSQLiteDatabase(outputFilePath)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to open database")
+	}
+
+	lsw := newLogSQLiteWriterForDB(logger, db, batchSize)
+	lsw.ownsDB = true
+
+	lsw.logger.DebugWith("Created sqlite writer", "outputFilePath", outputFilePath)
+
+	return lsw, nil
+}
+
+// newLogSQLiteWriterForDB creates a writer which inserts into a database opened with openSQLiteDatabase.
+// closing the writer doesn't close the database
+func newLogSQLiteWriterForDB(logger logger.Logger, db *sql.DB, batchSize int) *LogSQLiteWriter {
+	if batchSize == 0 {
+		batchSize = sqliteDefaultBatchSize
+	}
+
+	lsw := &LogSQLiteWriter{
+		logger:       logger.GetChild("sqlite-writer"),
+		db:           db,
+		batchSize:    batchSize,
+		stopFlushing: make(chan struct{}),
+		flushingDone: make(chan struct{}),
+	}
+
+	// when following, records may trickle in slower than batches fill up - flush them periodically
+	go lsw.flushPeriodically()
+
+	return lsw
+}
+
+// Write adds the record to the pending batch, inserting the batch if it's full
+func (lsw *LogSQLiteWriter) Write(logRecord *LogRecord) error {
+	lsw.lock.Lock()
+	defer lsw.lock.Unlock()
+
+	// report a failure of a periodic flush once
+	if err := lsw.takeFlushErr(); err != nil {
+		return err
+	}
+
+	lsw.pendingRecords = append(lsw.pendingRecords, logRecord)
+
+	if len(lsw.pendingRecords) >= lsw.batchSize {
+		return lsw.flushPendingRecords()
+	}
+
+	return nil
+}
+
+// Close inserts pending records and closes the database (if the writer created it)
+func (lsw *LogSQLiteWriter) Close() error {
+	close(lsw.stopFlushing)
+	<-lsw.flushingDone
+
+	lsw.lock.Lock()
+	defer lsw.lock.Unlock()
+
+	flushErr := lsw.takeFlushErr()
+	if err := lsw.flushPendingRecords(); err != nil && flushErr == nil {
+		flushErr = errors.Wrap(err, "Failed to flush pending records")
+	}
+
+	if lsw.ownsDB {
+		if err := lsw.db.Close(); err != nil && flushErr == nil {
+			flushErr = errors.Wrap(err, "Failed to close database")
+		}
+	}
+
+	return flushErr
+}
+
+// takeFlushErr returns the error of a failed periodic flush, if there was one which wasn't returned yet. must be
+// called while locked
+func (lsw *LogSQLiteWriter) takeFlushErr() error {
+	flushErr := lsw.flushErr
+	lsw.flushErr = nil
+
+	if flushErr == nil {
+		return nil
+	}
+
+	return errors.Wrap(flushErr, "Failed to flush pending records")
+}
+
+func (lsw *LogSQLiteWriter) flushPeriodically() {
+	ticker := time.NewTicker(sqliteBatchFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			lsw.lock.Lock()
+			if err := lsw.flushPendingRecords(); err != nil {
+				lsw.logger.WarnWith("Failed to flush pending records", "err", err.Error())
+
+				if lsw.flushErr == nil {
+					lsw.flushErr = err
+				}
+			}
+			lsw.lock.Unlock()
+
+		case <-lsw.stopFlushing:
+			close(lsw.flushingDone)
+			return
+		}
+	}
+}
+
+// flushPendingRecords inserts the pending records in a single transaction. if that fails the batch is dropped
+// rather than retried, since it would likely fail again while growing. must be called while locked
+func (lsw *LogSQLiteWriter) flushPendingRecords() error {
+	if len(lsw.pendingRecords) == 0 {
+		return nil
+	}
+
+	pendingRecords := lsw.pendingRecords
+	lsw.pendingRecords = nil
+
+	if err := insertSQLiteRecords(lsw.db, pendingRecords); err != nil {
+		return errors.Wrapf(err, "Failed to insert records (dropped %d)", len(pendingRecords))
+	}
+
+	return nil
+}
+
+// openSQLiteDatabase opens (or creates) a sqlite database tuned for bulk loading and creates the schema.
+// dataSourceName is a file path or ":memory:"
+func openSQLiteDatabase(dataSourceName string) (*sql.DB, error) {
+	registerSQLiteFunctions()
+
+	db, err := sql.Open("sqlite", dataSourceName)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to open sqlite database")
+	}
+
+	// sqlite doesn't do concurrent writes anyway, and an in memory database exists per connection
+	db.SetMaxOpenConns(1)
+
+	for _, statement := range append([]string{
+		"PRAGMA journal_mode = WAL",
+		"PRAGMA synchronous = OFF",
+	}, sqliteSchemaStatements...) {
+		if _, err := db.Exec(statement); err != nil {
+			db.Close() // nolint: errcheck
+			return nil, errors.Wrapf(err, "Failed to execute: %s", statement)
+		}
+	}
+
+	return db, nil
+}
+
+func insertSQLiteRecords(db *sql.DB, logRecords []*LogRecord) error {
+	transaction, err := db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "Failed to begin transaction")
+	}
+
+	statement, err := transaction.Prepare(sqliteInsertRecordStatement)
+	if err != nil {
+		transaction.Rollback() // nolint: errcheck
+		return errors.Wrap(err, "Failed to prepare statement")
+	}
+
+	for _, logRecord := range logRecords {
+		marshalledMore, err := json.Marshal(logRecord.GetTypedMore())
+		if err != nil {
+			marshalledMore = []byte("{}")
+		}
+
+		if _, err := statement.Exec(logRecord.When.UTC().Format(sqliteRecordTimeFormat),
+			logRecord.WhenUnixNano,
+			logRecord.SourceFile,
+			logRecord.LineNumber,
+			logRecord.Who,
+			logRecord.Severity,
+			logRecord.What,
+			logRecord.Ctx,
+			string(marshalledMore)); err != nil {
+			transaction.Rollback() // nolint: errcheck
+			return errors.Wrap(err, "Failed to insert record")
+		}
+	}
+
+	if err := statement.Close(); err != nil {
+		transaction.Rollback() // nolint: errcheck
+		return errors.Wrap(err, "Failed to close statement")
+	}
+
+	return transaction.Commit()
+}
@@ -0,0 +1,36 @@
+package kibini
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestSQLiteWriterDropsFailedBatch(t *testing.T) {
+	lsw, err := NewLogSQLiteWriter(newTestLogger(t), filepath.Join(t.TempDir(), "records.sqlite"), 2)
+	if err != nil {
+		t.Fatalf("Failed to create writer: %s", err)
+	}
+
+	logRecord := NewLogRecord(`{"when":"2023-01-02T10:00:01.000","who":"api","severity":"info","what":"x"}`)
+
+	// make inserting fail
+	lsw.db.Close() // nolint: errcheck
+
+	if err := lsw.Write(logRecord); err != nil {
+		t.Fatalf("Expected the first record to be pending, got %s", err)
+	}
+
+	if err := lsw.Write(logRecord); err == nil {
+		t.Fatal("Expected inserting the full batch to fail")
+	}
+
+	if len(lsw.pendingRecords) != 0 {
+		t.Fatalf("Expected the failed batch to be dropped, %d records pending", len(lsw.pendingRecords))
+	}
+
+	if err := lsw.Write(logRecord); err != nil {
+		t.Fatalf("Expected a new batch to start, got %s", err)
+	}
+
+	lsw.Close() // nolint: errcheck
+}
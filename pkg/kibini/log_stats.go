@@ -0,0 +1,324 @@
+package kibini
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nuclio/errors"
+)
+
+// StatsFormat determines how statistics are written
+type StatsFormat int
+
+const (
+	StatsFormatTable StatsFormat = iota
+	StatsFormatJSON
+)
+
+// StatsOptions determine which files are summarized and how
+type StatsOptions struct {
+	InputOptions
+
+	// Format is either StatsFormatTable or StatsFormatJSON
+	Format StatsFormat
+
+	// Top is the number of most frequent "what" messages reported per file and who. Zero means all
+	Top int
+}
+
+// LogStats summarizes records (of a file, or of a who in a file)
+type LogStats struct {
+	Name             string         `json:"name"`
+	Records          int            `json:"records"`
+	Severities       map[string]int `json:"severities"`
+	First            string         `json:"first,omitempty"`
+	Last             string         `json:"last,omitempty"`
+	RecordsPerSecond float64        `json:"recordsPerSecond"`
+	UnparseableLines int            `json:"unparseableLines"`
+	TopWhats         []LogWhatCount `json:"topWhats"`
+}
+
+// LogWhatCount is the number of records with a "what" message
+type LogWhatCount struct {
+	What  string `json:"what"`
+	Count int    `json:"count"`
+}
+
+// LogFileStats summarizes the records of a file, and those of each who in it
+type LogFileStats struct {
+	LogStats
+	Whos []*LogStats `json:"whos"`
+}
+
+// Stats writes statistics of each log file (and each who in it) to stdout
+func (k *Kibini) Stats(options *StatsOptions) error {
+	fileStats, err := k.GetLogStats(&options.InputOptions, options.Top)
+	if err != nil {
+		return errors.Wrap(err, "Failed to get log stats")
+	}
+
+	if options.Format == StatsFormatJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+
+		return encoder.Encode(fileStats)
+	}
+
+	return writeLogStatsTables(os.Stdout, fileStats)
+}
+
+// GetLogStats reads the log files and returns statistics of each (and of each who in it), with the top most
+// frequent "what" messages (all of them if top is 0). Files are read without merging, since order doesn't matter.
+// Files are never followed, since the statistics are only returned once reading is done
+func (k *Kibini) GetLogStats(inputOptions *InputOptions, top int) ([]*LogFileStats, error) {
+	if top < 0 {
+		return nil, errors.New("Top must not be negative")
+	}
+
+	statsInputOptions := *inputOptions
+	statsInputOptions.InputFollow = false
+	inputOptions = &statsInputOptions
+
+	inputFileNames, err := k.getInputFileNames(inputOptions)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to get input file names")
+	}
+
+	logFilter, err := k.getLogFilter(inputOptions)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to get log filter")
+	}
+
+	// each file is read by its own reader, so each gets its own collector
+	logStatsCollectors := map[string]*logStatsCollector{}
+	logWritersByLogFileName := map[string][]LogWriter{}
+	for _, inputFileName := range inputFileNames {
+		logStatsCollectors[inputFileName] = newLogStatsCollector(inputFileName)
+		logWritersByLogFileName[inputFileName] = []LogWriter{logStatsCollectors[inputFileName]}
+	}
+
+	k.readLogFiles(inputOptions, inputFileNames, logFilter, logWritersByLogFileName)
+
+	var fileStats []*LogFileStats
+	for _, inputFileName := range inputFileNames {
+		fileStats = append(fileStats, logStatsCollectors[inputFileName].getFileStats(top))
+	}
+
+	return fileStats, nil
+}
+
+func writeLogStatsTables(writer io.Writer, fileStats []*LogFileStats) error {
+	severitiesByName := map[string]interface{}{}
+	for _, fileStat := range fileStats {
+		for severity := range fileStat.Severities {
+			severitiesByName[severity] = nil
+		}
+	}
+
+	severities := getSortedKeys(severitiesByName)
+	sortSeverities(severities)
+
+	header := []string{"FILE", "WHO", "RECORDS"}
+	header = append(header, severities...)
+	header = append(header, "FIRST", "LAST", "RECORDS/S", "UNPARSEABLE")
+
+	var rows [][]string
+	var whatRows [][]string
+
+	addRows := func(fileName string, who string, stats *LogStats) {
+		row := []string{fileName, who, strconv.Itoa(stats.Records)}
+
+		for _, severity := range severities {
+			row = append(row, strconv.Itoa(stats.Severities[severity]))
+		}
+
+		row = append(row,
+			stats.First,
+			stats.Last,
+			strconv.FormatFloat(stats.RecordsPerSecond, 'f', 2, 64),
+			strconv.Itoa(stats.UnparseableLines))
+
+		rows = append(rows, row)
+
+		for _, whatCount := range stats.TopWhats {
+			whatRows = append(whatRows, []string{fileName, who, strconv.Itoa(whatCount.Count), whatCount.What})
+		}
+	}
+
+	for _, fileStat := range fileStats {
+
+		// the file's own row summarizes all of its whos
+		addRows(fileStat.Name, "*", &fileStat.LogStats)
+
+		for _, whoStats := range fileStat.Whos {
+			addRows(fileStat.Name, whoStats.Name, whoStats)
+		}
+	}
+
+	if err := writeTable(writer, header, rows); err != nil {
+		return err
+	}
+
+	if len(whatRows) == 0 {
+		return nil
+	}
+
+	if _, err := fmt.Fprintln(writer); err != nil {
+		return err
+	}
+
+	return writeTable(writer, []string{"FILE", "WHO", "COUNT", "WHAT"}, whatRows)
+}
+
+// sortSeverities sorts known severities by level, followed by unknown ones
+func sortSeverities(severities []string) {
+	levels := map[string]int{
+		"DEBUG":   0,
+		"INFO":    1,
+		"WARN":    2,
+		"WARNING": 2,
+		"ERROR":   3,
+	}
+
+	getLevel := func(severity string) int {
+		if level, found := levels[strings.ToUpper(severity)]; found {
+			return level
+		}
+
+		return len(levels)
+	}
+
+	sort.SliceStable(severities, func(i, j int) bool {
+		return getLevel(severities[i]) < getLevel(severities[j])
+	})
+}
+
+// logStatsCounter counts the records of a file or a who
+type logStatsCounter struct {
+	name             string
+	records          int
+	severities       map[string]int
+	first            time.Time
+	last             time.Time
+	unparseableLines int
+	whats            map[string]int
+}
+
+func newLogStatsCounter(name string) *logStatsCounter {
+	return &logStatsCounter{
+		name:       name,
+		severities: map[string]int{},
+		whats:      map[string]int{},
+	}
+}
+
+func (lsc *logStatsCounter) count(logRecord *LogRecord) {
+	lsc.records++
+	lsc.severities[logRecord.Severity]++
+	lsc.whats[logRecord.What]++
+
+	if lsc.first.IsZero() || logRecord.When.Before(lsc.first) {
+		lsc.first = logRecord.When
+	}
+
+	if logRecord.When.After(lsc.last) {
+		lsc.last = logRecord.When
+	}
+}
+
+func (lsc *logStatsCounter) getStats(top int) *LogStats {
+	stats := LogStats{
+		Name:             lsc.name,
+		Records:          lsc.records,
+		Severities:       lsc.severities,
+		UnparseableLines: lsc.unparseableLines,
+		TopWhats:         []LogWhatCount{},
+	}
+
+	if lsc.records != 0 {
+		stats.First = lsc.first.Format(time.RFC3339Nano)
+		stats.Last = lsc.last.Format(time.RFC3339Nano)
+
+		if span := lsc.last.Sub(lsc.first).Seconds(); span > 0 {
+			stats.RecordsPerSecond = float64(lsc.records) / span
+		}
+	}
+
+	for what, count := range lsc.whats {
+		stats.TopWhats = append(stats.TopWhats, LogWhatCount{What: what, Count: count})
+	}
+
+	sort.Slice(stats.TopWhats, func(i, j int) bool {
+		if stats.TopWhats[i].Count != stats.TopWhats[j].Count {
+			return stats.TopWhats[i].Count > stats.TopWhats[j].Count
+		}
+
+		return stats.TopWhats[i].What < stats.TopWhats[j].What
+	})
+
+	if top != 0 && len(stats.TopWhats) > top {
+		stats.TopWhats = stats.TopWhats[:top]
+	}
+
+	return &stats
+}
+
+// logStatsCollector is a LogWriter which counts the records of a file, and those of each who in it
+type logStatsCollector struct {
+	file *logStatsCounter
+	whos map[string]*logStatsCounter
+}
+
+func newLogStatsCollector(fileName string) *logStatsCollector {
+	return &logStatsCollector{
+		file: newLogStatsCounter(fileName),
+		whos: map[string]*logStatsCounter{},
+	}
+}
+
+func (lsc *logStatsCollector) Write(logRecord *LogRecord) error {
+	lsc.file.count(logRecord)
+
+	whoCounter, found := lsc.whos[logRecord.Who]
+	if !found {
+		whoCounter = newLogStatsCounter(logRecord.Who)
+		lsc.whos[logRecord.Who] = whoCounter
+	}
+
+	whoCounter.count(logRecord)
+
+	return nil
+}
+
+// WriteUnparseableLine counts the line for the file. Since it has no who, it isn't counted for any
+func (lsc *logStatsCollector) WriteUnparseableLine(sourceFile string, lineNumber int, line string) error {
+	lsc.file.unparseableLines++
+
+	return nil
+}
+
+func (lsc *logStatsCollector) getFileStats(top int) *LogFileStats {
+	fileStats := LogFileStats{
+		LogStats: *lsc.file.getStats(top),
+		Whos:     []*LogStats{},
+	}
+
+	whos := make([]string, 0, len(lsc.whos))
+	for who := range lsc.whos {
+		whos = append(whos, who)
+	}
+
+	sort.Strings(whos)
+
+	for _, who := range whos {
+		fileStats.Whos = append(fileStats.Whos, lsc.whos[who].getStats(top))
+	}
+
+	return &fileStats
+}
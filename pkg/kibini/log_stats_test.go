@@ -0,0 +1,30 @@
+package kibini
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestLogStatsIgnoresFollow(t *testing.T) {
+	inputPath := writeTestLogFile(t, "input.log",
+		`{"when":"2023-01-02T10:00:01.000","who":"api","severity":"info","what":"started"}`,
+		`{"when":"2023-01-02T10:00:02.000","who":"api","severity":"error","what":"failed"}`)
+
+	var fileStats []*LogFileStats
+	var err error
+
+	requireReturns(t, func() {
+		fileStats, err = NewKibini(newTestLogger(t)).GetLogStats(&InputOptions{
+			InputPath:   filepath.Dir(inputPath),
+			InputFollow: true,
+		}, 0)
+	})
+
+	if err != nil {
+		t.Fatalf("Failed to get stats: %s", err)
+	}
+
+	if len(fileStats) != 1 || fileStats[0].Records != 2 || fileStats[0].Severities["error"] != 1 {
+		t.Fatalf("Expected the stats of 2 records, got %+v", fileStats)
+	}
+}
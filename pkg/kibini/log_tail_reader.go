@@ -1,38 +1,85 @@
-package core
+package kibini
 
 import (
+	"bufio"
+	"bytes"
 	"io"
+	"log"
+	"os"
 	"path/filepath"
+	"strings"
+	"sync/atomic"
 
 	"github.com/hpcloud/tail"
 	"github.com/nuclio/errors"
 	"github.com/nuclio/logger"
 )
 
-type logTailReader struct {
+// LogTailReader reads (and optionally tails) a log file
+type LogTailReader struct {
 	logger        logger.Logger
 	inputFilePath string
-	logWriters    []logWriter
+	sourceFile    string
+	logExtractor  *LogExtractor
+	logRedactor   *LogRedactor
+	logFilter     LogFilter
+	logWriters    []LogWriter
+	offset        int64
 }
 
-func newLogTailReader(logger logger.Logger,
+// NewLogTailReader creates a LogTailReader which writes records read from inputFilePath to logWriters. If
+// logExtractor isn't nil fields are extracted from each record before it is filtered. If logFilter isn't nil
+// only matching records are written, and if the file has a fresh index (see Kibini.IndexLogs) and nothing is
+// extracted, the index is used to skip records which can't match. If logRedactor isn't nil records are redacted after being filtered, before
+// any writer sees them
+func NewLogTailReader(logger logger.Logger,
 	inputFilePath string,
-	logWriters []logWriter) *logTailReader {
+	logExtractor *LogExtractor,
+	logRedactor *LogRedactor,
+	logFilter LogFilter,
+	logWriters []LogWriter) *LogTailReader {
 
-	r := &logTailReader{
+	r := &LogTailReader{
 		logger:        logger.GetChild("tail_reader").GetChild(filepath.Base(inputFilePath)),
 		inputFilePath: inputFilePath,
+		sourceFile:    filepath.Base(inputFilePath),
+		logExtractor:  logExtractor,
+		logRedactor:   logRedactor,
+		logFilter:     logFilter,
 		logWriters:    logWriters,
 	}
 
 	return r
 }
 
-func (ltr *logTailReader) read(follow bool) error {
+func (ltr *LogTailReader) Read(follow bool) error {
+	var offset int64
+	lineNumber := 0
+
+	// read the indexed part of the file using the index, and tail whatever comes after it. the index holds the
+	// records as they are in the file, so when fields are extracted before filtering it can't tell which buckets
+	// may match
+	if ltr.logFilter != nil && ltr.logExtractor == nil {
+		if index := loadFreshLogIndex(ltr.inputFilePath); index != nil {
+			if err := ltr.readIndexed(index); err != nil {
+				return errors.Wrap(err, "Failed to read using index")
+			}
+
+			if !follow {
+				return nil
+			}
+
+			offset = index.SourceSize
+			lineNumber = index.Lines
+		}
+	}
+
+	truncationDetector := tailTruncationDetector{}
+
 	tailConfig := tail.Config{}
-	tailConfig.Location = &tail.SeekInfo{Offset: 0, Whence: io.SeekStart}
+	tailConfig.Location = &tail.SeekInfo{Offset: offset, Whence: io.SeekStart}
 	tailConfig.Follow = follow
-	tailConfig.Logger = tail.DiscardingLogger
+	tailConfig.Logger = log.New(&truncationDetector, "", 0)
 
 	// start tailing the input file
 	t, err := tail.TailFile(ltr.inputFilePath, tailConfig)
@@ -42,21 +89,159 @@ func (ltr *logTailReader) read(follow bool) error {
 
 	ltr.logger.Debug("Tailing")
 
+	atomic.StoreInt64(&ltr.offset, offset)
+
 	// for each line in the file (both existing and newly added)
 	for line := range t.Lines {
 
-		// create a log record from the line
-		if logRecord := newLogRecord(line.Text); logRecord != nil {
+		// a truncated file is read again from its start
+		if atomic.CompareAndSwapInt32(&truncationDetector.truncated, 1, 0) {
+			ltr.logger.Debug("File was truncated, reading it from its start")
+
+			atomic.StoreInt64(&ltr.offset, 0)
+			lineNumber = 0
+		}
+
+		lineNumber++
+
+		// the line's text lacks its newline
+		atomic.AddInt64(&ltr.offset, int64(len(line.Text))+1)
+
+		if err := ltr.writeLine(line.Text, lineNumber); err != nil {
+			return err
+		}
+	}
+
+	ltr.logger.Debug("Successfully finished tailing")
+	return nil
+}
+
+// GetSourceFile returns the name of the file the reader reads
+func (ltr *LogTailReader) GetSourceFile() string {
+	return ltr.sourceFile
+}
+
+// GetLag returns the number of bytes in the file which weren't read yet. Safe to call from any go routine
+func (ltr *LogTailReader) GetLag() (int64, error) {
+	fileInfo, err := os.Stat(ltr.inputFilePath)
+	if err != nil {
+		return 0, errors.Wrap(err, "Failed to stat file")
+	}
+
+	lag := fileInfo.Size() - atomic.LoadInt64(&ltr.offset)
+
+	// the file was truncated (and will be read from its start)
+	if lag < 0 {
+		return 0, nil
+	}
+
+	return lag, nil
+}
+
+// readIndexed reads only the buckets which may hold records that match the filter. The buckets in between are
+// skipped, so they count as read
+func (ltr *LogTailReader) readIndexed(index *logIndex) error {
+	inputFile, err := os.Open(ltr.inputFilePath)
+	if err != nil {
+		return errors.Wrap(err, "Failed to open file")
+	}
+
+	defer inputFile.Close() // nolint: errcheck
+
+	candidateBuckets := index.getCandidateBuckets(ltr.logFilter)
+
+	ltr.logger.DebugWith("Reading using index",
+		"buckets", len(index.Buckets),
+		"candidateBuckets", len(candidateBuckets))
+
+	for _, bucket := range candidateBuckets {
+		reader := bufio.NewReader(io.NewSectionReader(inputFile, bucket.Offset, bucket.Length))
+		atomic.StoreInt64(&ltr.offset, bucket.Offset)
 
-			// iterate over all writers and write this record
-			for _, logWriter := range ltr.logWriters {
-				if err := logWriter.Write(logRecord); err != nil {
-					return errors.Wrap(err, "Failed to write record")
+		for lineNumber := bucket.FirstLine; ; lineNumber++ {
+			line, readErr := reader.ReadString('\n')
+			if len(line) == 0 && readErr != nil {
+				if readErr == io.EOF {
+					break
 				}
+
+				return errors.Wrap(readErr, "Failed to read file")
+			}
+
+			atomic.AddInt64(&ltr.offset, int64(len(line)))
+
+			if err := ltr.writeLine(strings.TrimRight(line, "\n"), lineNumber); err != nil {
+				return err
 			}
 		}
 	}
 
-	ltr.logger.Debug("Successfully finished tailing")
+	atomic.StoreInt64(&ltr.offset, index.SourceSize)
+
 	return nil
 }
+
+func (ltr *LogTailReader) writeLine(line string, lineNumber int) error {
+
+	// create a log record from the line
+	logRecord := NewLogRecord(line)
+	if logRecord == nil {
+		return ltr.writeUnparseableLine(line, lineNumber)
+	}
+
+	logRecord.SourceFile = ltr.sourceFile
+	logRecord.LineNumber = lineNumber
+
+	if ltr.logExtractor != nil {
+		ltr.logExtractor.Extract(logRecord)
+	}
+
+	if ltr.logFilter != nil && !ltr.logFilter.Match(logRecord) {
+		return nil
+	}
+
+	if ltr.logRedactor != nil {
+		ltr.logRedactor.Redact(logRecord)
+	}
+
+	// iterate over all writers and write this record
+	for _, logWriter := range ltr.logWriters {
+		if err := logWriter.Write(logRecord); err != nil {
+			return errors.Wrap(err, "Failed to write record")
+		}
+	}
+
+	return nil
+}
+
+// writeUnparseableLine lets the writers which are interested know about a line which isn't a record. Blank
+// lines don't count
+func (ltr *LogTailReader) writeUnparseableLine(line string, lineNumber int) error {
+	if len(strings.TrimSpace(line)) == 0 {
+		return nil
+	}
+
+	for _, logWriter := range ltr.logWriters {
+		if logUnparseableLineWriter, isUnparseableLineWriter := logWriter.(LogUnparseableLineWriter); isUnparseableLineWriter {
+			if err := logUnparseableLineWriter.WriteUnparseableLine(ltr.sourceFile, lineNumber, line); err != nil {
+				return errors.Wrap(err, "Failed to write unparseable line")
+			}
+		}
+	}
+
+	return nil
+}
+
+// tailTruncationDetector receives what the tail library logs. The library reopens truncated files (and reads
+// them from their start) without telling, other than logging it
+type tailTruncationDetector struct {
+	truncated int32
+}
+
+func (ttd *tailTruncationDetector) Write(message []byte) (int, error) {
+	if bytes.HasPrefix(message, []byte("Successfully reopened truncated")) {
+		atomic.StoreInt32(&ttd.truncated, 1)
+	}
+
+	return len(message), nil
+}
@@ -0,0 +1,302 @@
+package kibini
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nuclio/logger"
+	"github.com/sirupsen/logrus"
+	"github.com/v3io/kibini/pkg/loggerus"
+)
+
+func newTestLogger(t *testing.T) logger.Logger {
+	testLogger, err := loggerus.NewTextLoggerus("test", logrus.ErrorLevel, io.Discard)
+	if err != nil {
+		t.Fatalf("Failed to create logger: %s", err)
+	}
+
+	return testLogger
+}
+
+// writeTestLogFile writes lines to a file in a temporary directory and returns its path
+func writeTestLogFile(t *testing.T, name string, lines ...string) string {
+	path := filepath.Join(t.TempDir(), name)
+
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %s", path, err)
+	}
+
+	return path
+}
+
+// recordingLogWriter keeps the records written to it
+type recordingLogWriter struct {
+	lock       sync.Mutex
+	logRecords []*LogRecord
+}
+
+func (rlw *recordingLogWriter) Write(logRecord *LogRecord) error {
+	rlw.lock.Lock()
+	defer rlw.lock.Unlock()
+
+	rlw.logRecords = append(rlw.logRecords, logRecord)
+
+	return nil
+}
+
+func (rlw *recordingLogWriter) getWhats() []string {
+	rlw.lock.Lock()
+	defer rlw.lock.Unlock()
+
+	var whats []string
+	for _, logRecord := range rlw.logRecords {
+		whats = append(whats, logRecord.What)
+	}
+
+	return whats
+}
+
+// requireReturns fails the test if call doesn't return within a few seconds (e.g. because it follows the input)
+func requireReturns(t *testing.T, call func()) {
+	t.Helper()
+
+	returned := make(chan struct{})
+	go func() {
+		defer close(returned)
+		call()
+	}()
+
+	select {
+	case <-returned:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the call to return, but it's still reading")
+	}
+}
+
+// readAndMerge reads the files (without following) through a merger into writers, the way ProcessLogs does
+func readAndMerge(t *testing.T, logFilter LogFilter, writers []LogWriter, inputFilePaths ...string) {
+	testLogger := newTestLogger(t)
+	mergerWaitGroup := sync.WaitGroup{}
+
+	logMerger := NewLogMerger(testLogger, &mergerWaitGroup, false, false, 0, 0, writers)
+
+	readerWaitGroup := sync.WaitGroup{}
+	for _, inputFilePath := range inputFilePaths {
+		readerWaitGroup.Add(1)
+
+		go func(inputFilePath string) {
+			defer readerWaitGroup.Done()
+
+			logTailReader := NewLogTailReader(testLogger, inputFilePath, nil, nil, logFilter, []LogWriter{logMerger})
+			if err := logTailReader.Read(false); err != nil {
+				t.Errorf("Failed to read %s: %s", inputFilePath, err)
+			}
+		}(inputFilePath)
+	}
+
+	readerWaitGroup.Wait()
+	logMerger.Stop()
+	mergerWaitGroup.Wait()
+}
+
+func TestPipelineMergesFilesByTime(t *testing.T) {
+	firstPath := writeTestLogFile(t, "first.log",
+		`{"when":"2023-01-02T10:00:01.000","who":"api","severity":"info","what":"first 1"}`,
+		`{"when":"2023-01-02T10:00:03.000","who":"api","severity":"info","what":"first 3","more":{}}`)
+
+	secondPath := writeTestLogFile(t, "second.log",
+		`{"when":"2023-01-02T10:00:02.000","who":"db","severity":"warn","what":"second 2","more":{}}`,
+		`not a record`,
+		`{"when":"2023-01-02T10:00:04.000","who":"db","severity":"error","what":"second 4","more":{}}`)
+
+	var output bytes.Buffer
+	formattedWriter := NewLogFormattedWriter(newTestLogger(t), NewHumanReadableFormatter(false, 30), &output)
+	recordingWriter := &recordingLogWriter{}
+
+	readAndMerge(t, nil, []LogWriter{formattedWriter, recordingWriter}, firstPath, secondPath)
+
+	expectedWhats := []string{"first 1", "second 2", "first 3", "second 4"}
+	if whats := recordingWriter.getWhats(); strings.Join(whats, ",") != strings.Join(expectedWhats, ",") {
+		t.Fatalf("Expected records %v, got %v", expectedWhats, whats)
+	}
+
+	lines := strings.Split(strings.TrimRight(output.String(), "\n"), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("Expected 4 formatted lines, got %d:\n%s", len(lines), output.String())
+	}
+
+	// a record without more has none, rather than an empty one
+	for lineIndex, expectedLine := range map[int]string{
+		0: "02.01.23 10:00:01.000000                            api (i) first 1 null",
+		1: "02.01.23 10:00:02.000000                             db (w) second 2 {}",
+	} {
+		if lines[lineIndex] != expectedLine {
+			t.Fatalf("Expected line %q, got %q", expectedLine, lines[lineIndex])
+		}
+	}
+
+	if !strings.Contains(lines[3], "(e) second 4") {
+		t.Fatalf("Expected an error record, got %q", lines[3])
+	}
+}
+
+func TestPipelineFiltersRecords(t *testing.T) {
+	inputPath := writeTestLogFile(t, "input.log",
+		`{"when":"2023-01-02T10:00:01.000","who":"api","severity":"info","what":"request handled","more":{"status":200}}`,
+		`{"when":"2023-01-02T10:00:02.000","who":"api","severity":"error","what":"request failed","more":{"status":500}}`,
+		`{"when":"2023-01-02T10:00:03.000","who":"api","severity":"error","what":"request failed","more":{"status":503}}`)
+
+	logFilter, err := ParseLogFilter("more.status>=503")
+	if err != nil {
+		t.Fatalf("Failed to parse filter: %s", err)
+	}
+
+	recordingWriter := &recordingLogWriter{}
+	readAndMerge(t, logFilter, []LogWriter{recordingWriter}, inputPath)
+
+	if len(recordingWriter.logRecords) != 1 || recordingWriter.logRecords[0].LineNumber != 3 {
+		t.Fatalf("Expected only the record of line 3, got %v", recordingWriter.getWhats())
+	}
+}
+
+func TestHumanReadableFormatterWithoutSeverity(t *testing.T) {
+	logRecord := NewLogRecord(`{"when":"2023-01-02T10:00:01.000","who":"api","what":"no severity"}`)
+	if logRecord == nil {
+		t.Fatal("Failed to parse record")
+	}
+
+	for _, color := range []bool{false, true} {
+		formatted := NewHumanReadableFormatter(color, 30).Format(logRecord)
+		if !strings.Contains(formatted, "?") || !strings.Contains(formatted, "no severity") {
+			t.Fatalf("Expected an unknown severity, got %q", formatted)
+		}
+	}
+}
+
+func TestLogTailReaderLagWhenReadingIndexed(t *testing.T) {
+	inputPath := writeTestLogFile(t, "input.log",
+		`{"when":"2023-01-02T10:00:01.000","who":"api","severity":"info","what":"request handled"}`,
+		`{"when":"2023-01-02T10:00:02.000","who":"api","severity":"error","what":"request failed"}`,
+		`{"when":"2023-01-02T10:00:03.000","who":"api","severity":"info","what":"request handled"}`)
+
+	index, err := buildLogIndex(inputPath, &IndexOptions{BucketInterval: time.Minute, BucketMaxRecords: 1})
+	if err != nil {
+		t.Fatalf("Failed to build index: %s", err)
+	}
+
+	if err := index.save(inputPath + LogIndexFileExtension); err != nil {
+		t.Fatalf("Failed to save index: %s", err)
+	}
+
+	logFilter, err := ParseLogFilter("severity=error")
+	if err != nil {
+		t.Fatalf("Failed to parse filter: %s", err)
+	}
+
+	recordingWriter := &recordingLogWriter{}
+	logTailReader := NewLogTailReader(newTestLogger(t), inputPath, nil, nil, logFilter, []LogWriter{recordingWriter})
+
+	if err := logTailReader.Read(false); err != nil {
+		t.Fatalf("Failed to read: %s", err)
+	}
+
+	if len(recordingWriter.logRecords) != 1 || recordingWriter.logRecords[0].LineNumber != 2 {
+		t.Fatalf("Expected only the record of line 2, got %v", recordingWriter.getWhats())
+	}
+
+	if lag, err := logTailReader.GetLag(); err != nil || lag != 0 {
+		t.Fatalf("Expected no lag once read, got %d (%v)", lag, err)
+	}
+}
+
+func TestLogTailReaderIgnoresIndexWhenExtracting(t *testing.T) {
+	inputPath := writeTestLogFile(t, "input.log",
+		`{"when":"2023-01-02T10:00:01.000","who":"api","severity":"info","what":"request handled in 5ms"}`,
+		`{"when":"2023-01-02T10:00:02.000","who":"api","severity":"info","what":"request failed"}`)
+
+	index, err := buildLogIndex(inputPath, &IndexOptions{BucketInterval: time.Minute, BucketMaxRecords: 1, Tokens: true})
+	if err != nil {
+		t.Fatalf("Failed to build index: %s", err)
+	}
+
+	if err := index.save(inputPath + LogIndexFileExtension); err != nil {
+		t.Fatalf("Failed to save index: %s", err)
+	}
+
+	logExtractor, err := NewLogExtractor(&ExtractionConfig{
+		Rules: []ExtractionRuleConfig{{Pattern: `handled in (?P<latency>\S+)`}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create extractor: %s", err)
+	}
+
+	// the extracted key isn't in the file, so the index has no bucket holding it
+	logFilter, err := ParseLogFilter("latency")
+	if err != nil {
+		t.Fatalf("Failed to parse filter: %s", err)
+	}
+
+	recordingWriter := &recordingLogWriter{}
+	logTailReader := NewLogTailReader(newTestLogger(t), inputPath, logExtractor, nil, logFilter, []LogWriter{recordingWriter})
+
+	if err := logTailReader.Read(false); err != nil {
+		t.Fatalf("Failed to read: %s", err)
+	}
+
+	if whats := recordingWriter.getWhats(); len(whats) != 1 || whats[0] != "request handled in 5ms" {
+		t.Fatalf("Expected the record with the extracted key, got %v", whats)
+	}
+}
+
+func TestLogTailReaderFollowsTruncatedFile(t *testing.T) {
+	inputPath := writeTestLogFile(t, "input.log",
+		`{"when":"2023-01-02T10:00:01.000","who":"api","severity":"info","what":"before 1"}`,
+		`{"when":"2023-01-02T10:00:02.000","who":"api","severity":"info","what":"before 2"}`,
+		`{"when":"2023-01-02T10:00:03.000","who":"api","severity":"info","what":"before 3"}`)
+
+	recordingWriter := &recordingLogWriter{}
+	logTailReader := NewLogTailReader(newTestLogger(t), inputPath, nil, nil, nil, []LogWriter{recordingWriter})
+
+	// the reader stops following once the file is removed, along with the test's directory
+	go logTailReader.Read(true) // nolint: errcheck
+
+	waitForRecords := func(count int) {
+		for deadline := time.Now().Add(5 * time.Second); len(recordingWriter.getWhats()) < count; {
+			if time.Now().After(deadline) {
+				t.Fatalf("Timed out waiting for %d records, got %v", count, recordingWriter.getWhats())
+			}
+
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	waitForRecords(3)
+
+	if err := os.WriteFile(inputPath,
+		[]byte(`{"when":"2023-01-02T10:01:00.000","who":"api","severity":"info","what":"after 1"}`+"\n"),
+		0644); err != nil {
+		t.Fatalf("Failed to truncate %s: %s", inputPath, err)
+	}
+
+	waitForRecords(4)
+
+	recordingWriter.lock.Lock()
+	truncatedLogRecord := recordingWriter.logRecords[3]
+	recordingWriter.lock.Unlock()
+
+	if truncatedLogRecord.What != "after 1" || truncatedLogRecord.LineNumber != 1 {
+		t.Fatalf("Expected the first line of the truncated file, got %q at line %d",
+			truncatedLogRecord.What,
+			truncatedLogRecord.LineNumber)
+	}
+
+	if lag, err := logTailReader.GetLag(); err != nil || lag != 0 {
+		t.Fatalf("Expected no lag once read, got %d (%v)", lag, err)
+	}
+}
@@ -0,0 +1,467 @@
+package kibini
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/nuclio/errors"
+)
+
+// logTemplateWildcard replaces the parameters of a message in its template
+const logTemplateWildcard = "<*>"
+
+// TemplateMinerOptions determine how messages are grouped into templates. Mining the same records with the
+// same options yields the same templates (and IDs)
+type TemplateMinerOptions struct {
+
+	// Similarity is the minimal fraction of its tokens a message must share with a template in order to join it
+	Similarity float64
+
+	// Depth is the depth of the prefix tree: messages are grouped by their number of tokens and then by their
+	// first Depth-2 tokens, and only then compared with templates
+	Depth int
+}
+
+// TemplatesFormat determines how templates are written
+type TemplatesFormat int
+
+const (
+	TemplatesFormatTable TemplatesFormat = iota
+	TemplatesFormatJSON
+)
+
+// TemplatesOptions determine which templates are written and how
+type TemplatesOptions struct {
+	InputOptions
+
+	// Format is either TemplatesFormatTable or TemplatesFormatJSON
+	Format TemplatesFormat
+
+	// Top is the number of templates written, the most frequent first. Zero means all
+	Top int
+
+	// Examples is the number of example records written per template
+	Examples int
+}
+
+// LogTemplate is a message template (with parameters replaced by <*>) and the records that fit it
+type LogTemplate struct {
+	ID         string                 `json:"id"`
+	Template   string                 `json:"template"`
+	Count      int                    `json:"count"`
+	Severities map[string]int         `json:"severities"`
+	First      string                 `json:"first"`
+	Last       string                 `json:"last"`
+	Examples   []*NormalizedLogRecord `json:"examples"`
+}
+
+// Templates mines the "what" messages of the records into templates and writes them to stdout
+func (k *Kibini) Templates(options *TemplatesOptions) error {
+	if options.Top < 0 {
+		return errors.New("Top must not be negative")
+	}
+
+	logTemplates, err := k.MineTemplates(&options.InputOptions, options.Examples)
+	if err != nil {
+		return errors.Wrap(err, "Failed to mine templates")
+	}
+
+	if options.Top != 0 && len(logTemplates) > options.Top {
+		logTemplates = logTemplates[:options.Top]
+	}
+
+	if options.Format == TemplatesFormatJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.SetEscapeHTML(false)
+
+		return encoder.Encode(logTemplates)
+	}
+
+	return writeLogTemplatesTables(os.Stdout, logTemplates)
+}
+
+// MineTemplates reads the records (merged) and mines their "what" messages into templates, using the miner
+// options of the input options. Templates are sorted by count, the most frequent first. Files are never
+// followed, since the templates are only returned once reading is done
+func (k *Kibini) MineTemplates(inputOptions *InputOptions, examples int) ([]*LogTemplate, error) {
+	miningInputOptions := *inputOptions
+	miningInputOptions.InputFollow = false
+
+	logTemplateMiner := NewLogTemplateMiner(&inputOptions.TemplateOptions, examples)
+
+	if err := k.ReadLogs(&miningInputOptions, []LogWriter{logTemplateMiner}); err != nil {
+		return nil, errors.Wrap(err, "Failed to read logs")
+	}
+
+	return logTemplateMiner.GetTemplates(), nil
+}
+
+// getLogTemplateFilter mines the templates of the input and returns a filter which matches the records fitting
+// the templates of the input options' template IDs. For the IDs to be those "kibini templates" prints, the
+// records are mined the way it mines them: from the selected files as they are (without following), with
+// neither the filter nor the other template IDs applied. Extraction doesn't affect messages so it's skipped,
+// and when redacting, messages are mined (and fitted) redacted without counting what's redacted again
+func (k *Kibini) getLogTemplateFilter(inputOptions *InputOptions) (LogFilter, error) {
+	miningInputOptions := *inputOptions
+	miningInputOptions.InputFollow = false
+	miningInputOptions.Filter = ""
+	miningInputOptions.TemplateIDs = nil
+	miningInputOptions.Extractor = nil
+	miningInputOptions.Redactor = nil
+
+	logTemplateMiner := NewLogTemplateMiner(&inputOptions.TemplateOptions, 0)
+
+	var miningLogWriter LogWriter = logTemplateMiner
+	if inputOptions.Redactor != nil {
+		miningLogWriter = &logWhatRedactingWriter{
+			logRedactor: inputOptions.Redactor,
+			logWriter:   logTemplateMiner,
+		}
+	}
+
+	if err := k.ReadLogs(&miningInputOptions, []LogWriter{miningLogWriter}); err != nil {
+		return nil, errors.Wrap(err, "Failed to mine templates")
+	}
+
+	logTemplatesByID := map[string]*LogTemplate{}
+	for _, logTemplate := range logTemplateMiner.GetTemplates() {
+		logTemplatesByID[logTemplate.ID] = logTemplate
+	}
+
+	logFilterTemplate := logFilterTemplate{
+		logRedactor: inputOptions.Redactor,
+	}
+
+	for _, templateID := range inputOptions.TemplateIDs {
+		logTemplate, found := logTemplatesByID[templateID]
+		if !found {
+			return nil, errors.New(fmt.Sprintf("Unknown template ID: %s (see kibini templates, without --filter)", templateID))
+		}
+
+		logFilterTemplate.templatesTokens = append(logFilterTemplate.templatesTokens, getTemplateTokens(logTemplate.Template))
+	}
+
+	return &logFilterTemplate, nil
+}
+
+func writeLogTemplatesTables(writer io.Writer, logTemplates []*LogTemplate) error {
+	var rows [][]string
+	var exampleRows [][]string
+
+	for _, logTemplate := range logTemplates {
+		severities := make([]string, 0, len(logTemplate.Severities))
+		for severity := range logTemplate.Severities {
+			severities = append(severities, severity)
+		}
+
+		sort.Strings(severities)
+		sortSeverities(severities)
+
+		var severityCounts []string
+		for _, severity := range severities {
+			severityCounts = append(severityCounts, fmt.Sprintf("%s:%d", severity, logTemplate.Severities[severity]))
+		}
+
+		rows = append(rows, []string{
+			logTemplate.ID,
+			strconv.Itoa(logTemplate.Count),
+			strings.Join(severityCounts, " "),
+			logTemplate.First,
+			logTemplate.Last,
+			logTemplate.Template,
+		})
+
+		for _, example := range logTemplate.Examples {
+			exampleRows = append(exampleRows, []string{
+				logTemplate.ID,
+				fmt.Sprintf("%s:%d", example.Source, example.Line),
+				example.Who,
+				example.What,
+			})
+		}
+	}
+
+	if err := writeTable(writer, []string{"ID", "COUNT", "SEVERITIES", "FIRST", "LAST", "TEMPLATE"}, rows); err != nil {
+		return err
+	}
+
+	if len(exampleRows) == 0 {
+		return nil
+	}
+
+	if _, err := fmt.Fprintln(writer); err != nil {
+		return err
+	}
+
+	return writeTable(writer, []string{"ID", "SOURCE", "WHO", "EXAMPLE"}, exampleRows)
+}
+
+// LogTemplateMiner is a LogWriter which mines the "what" messages of records into templates, Drain style:
+// messages are tokenized (tokens holding digits are taken as parameters), grouped by their number of tokens
+// and first tokens, and then join the most similar template in their group - whose tokens which differ from
+// the message's become parameters - or start a new one
+type LogTemplateMiner struct {
+	options  TemplateMinerOptions
+	examples int
+	groups   map[string][]*logTemplateCluster
+	clusters []*logTemplateCluster
+}
+
+type logTemplateCluster struct {
+	tokens       []string
+	count        int
+	severities   map[string]int
+	first        time.Time
+	last         time.Time
+	examples     []*LogRecord
+	exampleWhats map[string]bool
+}
+
+// NewLogTemplateMiner creates a LogTemplateMiner which keeps up to examples records of each template
+func NewLogTemplateMiner(options *TemplateMinerOptions, examples int) *LogTemplateMiner {
+	return &LogTemplateMiner{
+		options:  *options,
+		examples: examples,
+		groups:   map[string][]*logTemplateCluster{},
+	}
+}
+
+func (ltm *LogTemplateMiner) Write(logRecord *LogRecord) error {
+	tokens := getTemplateTokens(logRecord.What)
+	groupKey := ltm.getGroupKey(tokens)
+
+	cluster := ltm.getMostSimilarCluster(ltm.groups[groupKey], tokens)
+	if cluster == nil {
+		cluster = &logTemplateCluster{
+			tokens:       tokens,
+			severities:   map[string]int{},
+			first:        logRecord.When,
+			exampleWhats: map[string]bool{},
+		}
+
+		ltm.groups[groupKey] = append(ltm.groups[groupKey], cluster)
+		ltm.clusters = append(ltm.clusters, cluster)
+	} else {
+
+		// tokens that differ are parameters
+		for tokenIndex, token := range tokens {
+			if cluster.tokens[tokenIndex] != token {
+				cluster.tokens[tokenIndex] = logTemplateWildcard
+			}
+		}
+	}
+
+	cluster.count++
+	cluster.severities[logRecord.Severity]++
+
+	if logRecord.When.Before(cluster.first) {
+		cluster.first = logRecord.When
+	}
+
+	if logRecord.When.After(cluster.last) {
+		cluster.last = logRecord.When
+	}
+
+	// examples with distinct messages are more telling
+	if len(cluster.examples) < ltm.examples && !cluster.exampleWhats[logRecord.What] {
+		cluster.examples = append(cluster.examples, logRecord)
+		cluster.exampleWhats[logRecord.What] = true
+	}
+
+	return nil
+}
+
+// GetTemplates returns the templates mined so far, the most frequent first. Clusters which ended up with the same
+// template are reported as one
+func (ltm *LogTemplateMiner) GetTemplates() []*LogTemplate {
+	var logTemplates []*LogTemplate
+	logTemplatesByID := map[string]*LogTemplate{}
+	firstByID := map[string]time.Time{}
+	lastByID := map[string]time.Time{}
+
+	for _, cluster := range ltm.clusters {
+		template := strings.Join(cluster.tokens, " ")
+		templateID := getTemplateID(template)
+
+		logTemplate, found := logTemplatesByID[templateID]
+		if !found {
+			logTemplate = &LogTemplate{
+				ID:         templateID,
+				Template:   template,
+				Severities: map[string]int{},
+				Examples:   []*NormalizedLogRecord{},
+			}
+
+			logTemplatesByID[templateID] = logTemplate
+			logTemplates = append(logTemplates, logTemplate)
+			firstByID[templateID] = cluster.first
+		}
+
+		logTemplate.Count += cluster.count
+
+		for severity, count := range cluster.severities {
+			logTemplate.Severities[severity] += count
+		}
+
+		if cluster.first.Before(firstByID[templateID]) {
+			firstByID[templateID] = cluster.first
+		}
+
+		if cluster.last.After(lastByID[templateID]) {
+			lastByID[templateID] = cluster.last
+		}
+
+		for _, example := range cluster.examples {
+			if len(logTemplate.Examples) < ltm.examples {
+				logTemplate.Examples = append(logTemplate.Examples, example.Normalize())
+			}
+		}
+	}
+
+	for _, logTemplate := range logTemplates {
+		logTemplate.First = firstByID[logTemplate.ID].Format(time.RFC3339Nano)
+		logTemplate.Last = lastByID[logTemplate.ID].Format(time.RFC3339Nano)
+	}
+
+	sort.SliceStable(logTemplates, func(i, j int) bool {
+		return logTemplates[i].Count > logTemplates[j].Count
+	})
+
+	return logTemplates
+}
+
+// getGroupKey returns the key of the group of messages with the same number of tokens and first tokens
+func (ltm *LogTemplateMiner) getGroupKey(tokens []string) string {
+	prefixLength := ltm.options.Depth - 2
+	if prefixLength < 0 {
+		prefixLength = 0
+	}
+
+	if prefixLength > len(tokens) {
+		prefixLength = len(tokens)
+	}
+
+	return strconv.Itoa(len(tokens)) + "\x00" + strings.Join(tokens[:prefixLength], "\x00")
+}
+
+// getMostSimilarCluster returns the cluster whose template shares the most tokens with the message, if it shares
+// enough of them. Ties go to the oldest cluster
+func (ltm *LogTemplateMiner) getMostSimilarCluster(clusters []*logTemplateCluster, tokens []string) *logTemplateCluster {
+	var mostSimilarCluster *logTemplateCluster
+	highestSimilarity := -1.0
+
+	for _, cluster := range clusters {
+		similarity := getTemplateSimilarity(cluster.tokens, tokens)
+
+		if similarity > highestSimilarity {
+			highestSimilarity = similarity
+			mostSimilarCluster = cluster
+		}
+	}
+
+	if mostSimilarCluster == nil || highestSimilarity < ltm.options.Similarity {
+		return nil
+	}
+
+	return mostSimilarCluster
+}
+
+// getTemplateSimilarity returns the fraction of the tokens (of the same number) which are equal, ignoring
+// parameters of the template
+func getTemplateSimilarity(templateTokens []string, tokens []string) float64 {
+	if len(tokens) == 0 {
+		return 1
+	}
+
+	equalTokens := 0
+	for tokenIndex, token := range tokens {
+		if templateTokens[tokenIndex] == token && token != logTemplateWildcard {
+			equalTokens++
+		}
+	}
+
+	return float64(equalTokens) / float64(len(tokens))
+}
+
+// getTemplateTokens splits a message into tokens by whitespace, replacing tokens which hold digits (numbers, IDs,
+// addresses, durations) with the wildcard
+func getTemplateTokens(message string) []string {
+	tokens := strings.Fields(message)
+
+	for tokenIndex, token := range tokens {
+		if strings.IndexFunc(token, unicode.IsDigit) != -1 {
+			tokens[tokenIndex] = logTemplateWildcard
+		}
+	}
+
+	return tokens
+}
+
+// getTemplateID returns a short ID of a template, derived from its text
+func getTemplateID(template string) string {
+	hash := fnv.New32a()
+	hash.Write([]byte(template)) // nolint: errcheck
+
+	return fmt.Sprintf("%08x", hash.Sum32())
+}
+
+// logFilterTemplate matches records whose "what" fits one of the templates (given as tokens). If the templates
+// were mined from redacted messages, messages are redacted before being fitted
+type logFilterTemplate struct {
+	templatesTokens [][]string
+	logRedactor     *LogRedactor
+}
+
+func (lft *logFilterTemplate) Match(logRecord *LogRecord) bool {
+	what := logRecord.What
+	if lft.logRedactor != nil {
+		what = lft.logRedactor.redactText(what)
+	}
+
+	tokens := getTemplateTokens(what)
+
+	for _, templateTokens := range lft.templatesTokens {
+		if fitsTemplate(templateTokens, tokens) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// logWhatRedactingWriter writes copies of records whose "what" is redacted, without counting what's redacted
+type logWhatRedactingWriter struct {
+	logRedactor *LogRedactor
+	logWriter   LogWriter
+}
+
+func (lwrw *logWhatRedactingWriter) Write(logRecord *LogRecord) error {
+	redactedLogRecord := *logRecord
+	redactedLogRecord.What = lwrw.logRedactor.redactText(logRecord.What)
+
+	return lwrw.logWriter.Write(&redactedLogRecord)
+}
+
+// fitsTemplate returns whether the tokens are those of the template, where the template has no parameters
+func fitsTemplate(templateTokens []string, tokens []string) bool {
+	if len(templateTokens) != len(tokens) {
+		return false
+	}
+
+	for tokenIndex, templateToken := range templateTokens {
+		if templateToken != logTemplateWildcard && templateToken != tokens[tokenIndex] {
+			return false
+		}
+	}
+
+	return true
+}
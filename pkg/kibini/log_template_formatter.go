@@ -0,0 +1,178 @@
+package kibini
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/mgutz/ansi"
+	"github.com/nuclio/errors"
+)
+
+// FormatTemplatePresets are named templates which can be passed instead of a template
+var FormatTemplatePresets = map[string]string{
+
+	// the layout of the human readable formatter, which differs when coloring
+	"default": `{{if .Color}}{{print (time "020106 15:04:05.000000" .When) " " (rtrunc .WhoWidth .Who | pad 30) | color "black+h"}}: ` +
+		`{{else}}{{time "02.01.06 15:04:05.000000" .When}} {{rtrunc .WhoWidth .Who | pad 30}} {{end}}` +
+		`({{sevcode .Severity | sevcolor .Severity}}) {{color "cyan" .What}} {{.FormattedMore}}`,
+
+	// time, severity and message only
+	"short": `{{time "15:04:05.000" .When}} {{sevcode .Severity | sevcolor .Severity}} {{.What}}`,
+
+	// everything on one line, more as compact JSON
+	"compact": `{{time "15:04:05.000000" .When}} {{rtrunc 20 .Who | pad 20}} ` +
+		`{{sevcode .Severity | sevcolor .Severity}} {{color "cyan" .What}}{{if .Ctx}} [{{.Ctx}}]{{end}} {{json .More}}`,
+
+	// full timestamp and severity, more pretty printed on the following lines
+	"verbose": `{{time "2006-01-02T15:04:05.000000000" .When}} {{.Who}} {{padright 7 .Severity | sevcolor .Severity}} ` +
+		`{{color "cyan" .What}}{{if .Ctx}} (ctx: {{.Ctx}}){{end}}{{if .More}}
+{{prettyjson .More}}{{end}}`,
+}
+
+// templateRecord is what templates are executed against. it exposes all the log record fields, with More
+// typed so that its keys can be accessed (e.g. {{.More.RequestID}})
+type templateRecord struct {
+	*LogRecord
+	More          map[string]interface{}
+	WhoWidth      int
+	Color         bool
+	FormattedMore string
+}
+
+// TemplateFormatter formats log records using a text/template
+type TemplateFormatter struct {
+	color                  bool
+	whoWidth               int
+	template               *template.Template
+	humanReadableFormatter *HumanReadableFormatter
+}
+
+// NewTemplateFormatter creates a TemplateFormatter. templateText is either a go text/template or the name
+// of one of FormatTemplatePresets
+func NewTemplateFormatter(templateText string, color bool, whoWidth int) (*TemplateFormatter, error) {
+	if presetTemplateText, found := FormatTemplatePresets[templateText]; found {
+		templateText = presetTemplateText
+	}
+
+	tf := &TemplateFormatter{
+		color:                  color,
+		whoWidth:               whoWidth,
+		humanReadableFormatter: NewHumanReadableFormatter(color, whoWidth),
+	}
+
+	parsedTemplate, err := template.New("record").Funcs(tf.getTemplateFuncs()).Parse(templateText)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to parse format template")
+	}
+
+	tf.template = parsedTemplate
+
+	return tf, nil
+}
+
+// Format executes the template against the log record
+func (tf *TemplateFormatter) Format(logRecord *LogRecord) string {
+	var formatted bytes.Buffer
+
+	if err := tf.template.Execute(&formatted, tf.createTemplateRecord(logRecord)); err != nil {
+		return fmt.Sprintf("<Error formatting record: %s>\n", err)
+	}
+
+	// each record is on its own line
+	if formatted.Len() == 0 || formatted.Bytes()[formatted.Len()-1] != '\n' {
+		formatted.WriteByte('\n')
+	}
+
+	return formatted.String()
+}
+
+func (tf *TemplateFormatter) createTemplateRecord(logRecord *LogRecord) *templateRecord {
+	return &templateRecord{
+		LogRecord:     logRecord,
+		More:          logRecord.GetTypedMore(),
+		WhoWidth:      tf.whoWidth,
+		Color:         tf.color,
+		FormattedMore: tf.humanReadableFormatter.formatRecordMore(logRecord),
+	}
+}
+
+func (tf *TemplateFormatter) getTemplateFuncs() template.FuncMap {
+	return template.FuncMap{
+
+		// {{color "red" .What}} - colors the string (see github.com/mgutz/ansi for styles) if color is enabled
+		"color": func(style string, s string) string {
+			if !tf.color {
+				return s
+			}
+
+			return ansi.Color(s, style)
+		},
+
+		// {{sevcolor .Severity "text"}} - colors the string according to the severity, if color is enabled
+		"sevcolor": func(severity string, s string) string {
+			if !tf.color {
+				return s
+			}
+
+			severityCode := byte('?')
+			if len(severity) != 0 {
+				severityCode = severity[0]
+			}
+
+			return tf.humanReadableFormatter.getSeverityColor(severityCode) + s + ansi.Reset
+		},
+
+		// {{sevcode .Severity}} - the first letter of the severity
+		"sevcode": func(severity string) string {
+			if len(severity) == 0 {
+				return "?"
+			}
+
+			return severity[:1]
+		},
+
+		// {{rtrunc 20 .Who}} - keeps the last n characters
+		"rtrunc": func(length int, s string) string {
+			return rtruncateString(s, length)
+		},
+
+		// {{trunc 20 .What}} - keeps the first n characters
+		"trunc": func(length int, s string) string {
+			return truncateString(s, length)
+		},
+
+		// {{pad 30 .Who}} - pads to the left (right aligns)
+		"pad": func(width int, s string) string {
+			return fmt.Sprintf("%*s", width, s)
+		},
+
+		// {{padright 30 .Who}} - pads to the right (left aligns)
+		"padright": func(width int, s string) string {
+			return fmt.Sprintf("%-*s", width, s)
+		},
+
+		// {{time "15:04:05" .When}} - formats a time using a go layout
+		"time": func(layout string, t time.Time) string {
+			return t.Format(layout)
+		},
+
+		// {{json .More}} - compact JSON
+		"json": func(value interface{}) (string, error) {
+			marshalledValue, err := json.Marshal(value)
+			return string(marshalledValue), err
+		},
+
+		// {{prettyjson .More}} - indented JSON
+		"prettyjson": func(value interface{}) (string, error) {
+			marshalledValue, err := json.MarshalIndent(value, "", "    ")
+			return string(marshalledValue), err
+		},
+
+		"upper": strings.ToUpper,
+		"lower": strings.ToLower,
+	}
+}
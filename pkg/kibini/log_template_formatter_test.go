@@ -0,0 +1,44 @@
+package kibini
+
+import (
+	"testing"
+)
+
+func TestDefaultPresetMatchesHumanReadableFormatter(t *testing.T) {
+	logRecords := []*LogRecord{
+		NewLogRecord(`{"when":"2023-01-02T10:00:01.123","who":"api","severity":"warn","what":"slow","more":{"ms":1200}}`),
+		NewLogRecord(`{"when":"2023-01-02T10:00:02.000","who":"some-very-long-service-name-worker","severity":"error","what":"failed","ctx":"a \"quoted\" ctx"}`),
+		NewLogRecord(`{"when":"2023-01-02T10:00:03.000","who":"api","what":"no severity","more":{}}`),
+		NewLogRecord(`{"when":"2023-01-02T10:00:04.000","who":"api","severity":"info","what":"no more"}`),
+	}
+
+	for _, color := range []bool{false, true} {
+		templateFormatter, err := NewTemplateFormatter("default", color, 20)
+		if err != nil {
+			t.Fatalf("Failed to create template formatter: %s", err)
+		}
+
+		humanReadableFormatter := NewHumanReadableFormatter(color, 20)
+
+		for _, logRecord := range logRecords {
+			expected := humanReadableFormatter.Format(logRecord)
+
+			if formatted := templateFormatter.Format(logRecord); formatted != expected {
+				t.Fatalf("Expected (color: %v)\n%q, got\n%q", color, expected, formatted)
+			}
+		}
+	}
+}
+
+func TestTruncateByRunes(t *testing.T) {
+	templateFormatter, err := NewTemplateFormatter(`{{trunc 3 .What}}|{{rtrunc 3 .Who}}`, false, 30)
+	if err != nil {
+		t.Fatalf("Failed to create template formatter: %s", err)
+	}
+
+	logRecord := NewLogRecord(`{"when":"2023-01-02T10:00:01.000","who":"сервис-日本","severity":"info","what":"día ñandú"}`)
+
+	if formatted := templateFormatter.Format(logRecord); formatted != "día|-日本\n" {
+		t.Fatalf("Expected runes to be kept whole, got %q", formatted)
+	}
+}
@@ -0,0 +1,32 @@
+package kibini
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestMineTemplatesIgnoresFollow(t *testing.T) {
+	inputPath := writeTestLogFile(t, "input.log",
+		`{"when":"2023-01-02T10:00:01.000","who":"api","severity":"info","what":"took 10ms to connect"}`,
+		`{"when":"2023-01-02T10:00:02.000","who":"api","severity":"info","what":"took 12ms to connect"}`,
+		`{"when":"2023-01-02T10:00:03.000","who":"api","severity":"error","what":"connection refused"}`)
+
+	var logTemplates []*LogTemplate
+	var err error
+
+	requireReturns(t, func() {
+		logTemplates, err = NewKibini(newTestLogger(t)).MineTemplates(&InputOptions{
+			InputPath:       filepath.Dir(inputPath),
+			InputFollow:     true,
+			TemplateOptions: TemplateMinerOptions{Similarity: 0.4, Depth: 4},
+		}, 0)
+	})
+
+	if err != nil {
+		t.Fatalf("Failed to mine templates: %s", err)
+	}
+
+	if len(logTemplates) != 2 || logTemplates[0].Template != "took <*> to connect" || logTemplates[0].Count != 2 {
+		t.Fatalf("Expected 2 templates, the most frequent first, got %+v", logTemplates)
+	}
+}
@@ -0,0 +1,395 @@
+package kibini
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mgutz/ansi"
+	"github.com/nuclio/errors"
+)
+
+// TraceLayout determines how the records of a trace are written
+type TraceLayout int
+
+const (
+	TraceLayoutTimeline TraceLayout = iota
+	TraceLayoutSwimlanes
+)
+
+// TraceOptions determine which trace is written and how
+type TraceOptions struct {
+	InputOptions
+
+	// ID is a ctx or a RequestID. Empty means listing the IDs with the most errors
+	ID string
+
+	// Layout is either TraceLayoutTimeline (indented by service) or TraceLayoutSwimlanes (a column per service)
+	Layout TraceLayout
+
+	// ColorSetting is one of "on" (color when outputting to a tty), "off" or "always"
+	ColorSetting string
+
+	// WhoWidth is the truncate width of the "who" field
+	WhoWidth int
+
+	// LaneWidth is the width of each service's column in TraceLayoutSwimlanes
+	LaneWidth int
+
+	// Top is the number of IDs listed when no ID is given (0 for all)
+	Top int
+}
+
+// TraceIDSummary summarizes the records of a ctx or RequestID
+type TraceIDSummary struct {
+	ID      string
+	Records int
+	Errors  int
+	Whos    []string
+	First   time.Time
+	Last    time.Time
+}
+
+// Trace writes the records sharing a ctx or RequestID across all log files to stdout, merged (sorted by time),
+// along with the time between hops from service to service. Without an ID, the IDs with the most errors are
+// listed instead
+func (k *Kibini) Trace(options *TraceOptions) error {
+	if len(options.ID) == 0 {
+		return k.writeTraceIDSummaries(options)
+	}
+
+	traceWriter := traceWriter{
+		writer:                 os.Stdout,
+		color:                  k.determineColorSetting(options.ColorSetting, true),
+		whoWidth:               options.WhoWidth,
+		laneWidth:              options.LaneWidth,
+		humanReadableFormatter: NewHumanReadableFormatter(true, options.WhoWidth),
+	}
+
+	logRecords, err := k.GetTraceRecords(&options.InputOptions, options.ID)
+	if err != nil {
+		return errors.Wrap(err, "Failed to get trace records")
+	}
+
+	if len(logRecords) == 0 {
+		return errors.New(fmt.Sprintf("No records found for %s", options.ID))
+	}
+
+	if options.Layout == TraceLayoutSwimlanes {
+		return traceWriter.writeSwimlanes(options.ID, logRecords)
+	}
+
+	return traceWriter.writeTimeline(options.ID, logRecords)
+}
+
+// GetTraceRecords returns the records whose ctx or RequestID is id, sorted by time. Files are never followed,
+// since the records are only returned once reading is done
+func (k *Kibini) GetTraceRecords(inputOptions *InputOptions, id string) ([]*LogRecord, error) {
+	traceInputOptions := *inputOptions
+	traceInputOptions.InputFollow = false
+	traceInputOptions.Filter = fmt.Sprintf("(ctx=%s or more.RequestID=%s)", strconv.Quote(id), strconv.Quote(id))
+
+	if len(inputOptions.Filter) != 0 {
+		traceInputOptions.Filter = fmt.Sprintf("(%s) %s", inputOptions.Filter, traceInputOptions.Filter)
+	}
+
+	logRecordStore := NewLogRecordStore()
+
+	if err := k.ReadLogs(&traceInputOptions, []LogWriter{logRecordStore}); err != nil {
+		return nil, errors.Wrap(err, "Failed to read logs")
+	}
+
+	logRecords, _ := logRecordStore.Query(nil, 0, 0)
+
+	return logRecords, nil
+}
+
+// GetTraceIDSummaries returns a summary of every ctx and RequestID, those with the most errors first. Like
+// GetTraceRecords, files are never followed
+func (k *Kibini) GetTraceIDSummaries(inputOptions *InputOptions) ([]*TraceIDSummary, error) {
+	traceInputOptions := *inputOptions
+	traceInputOptions.InputFollow = false
+
+	traceIDSummarizer := traceIDSummarizer{
+		summaries: map[string]*TraceIDSummary{},
+		whos:      map[string]map[string]interface{}{},
+	}
+
+	if err := k.ReadLogs(&traceInputOptions, []LogWriter{&traceIDSummarizer}); err != nil {
+		return nil, errors.Wrap(err, "Failed to read logs")
+	}
+
+	summaries := make([]*TraceIDSummary, 0, len(traceIDSummarizer.summaries))
+	for id, summary := range traceIDSummarizer.summaries {
+		summary.Whos = getSortedKeys(traceIDSummarizer.whos[id])
+		summaries = append(summaries, summary)
+	}
+
+	sort.Slice(summaries, func(i, j int) bool {
+		if summaries[i].Errors != summaries[j].Errors {
+			return summaries[i].Errors > summaries[j].Errors
+		}
+
+		if summaries[i].Records != summaries[j].Records {
+			return summaries[i].Records > summaries[j].Records
+		}
+
+		return summaries[i].ID < summaries[j].ID
+	})
+
+	return summaries, nil
+}
+
+func (k *Kibini) writeTraceIDSummaries(options *TraceOptions) error {
+	if options.Top < 0 {
+		return errors.New("Top must not be negative")
+	}
+
+	summaries, err := k.GetTraceIDSummaries(&options.InputOptions)
+	if err != nil {
+		return errors.Wrap(err, "Failed to get trace ID summaries")
+	}
+
+	if options.Top != 0 && len(summaries) > options.Top {
+		summaries = summaries[:options.Top]
+	}
+
+	var rows [][]string
+	for _, summary := range summaries {
+		rows = append(rows, []string{
+			summary.ID,
+			strconv.Itoa(summary.Errors),
+			strconv.Itoa(summary.Records),
+			strings.Join(summary.Whos, ","),
+			summary.First.Format("2006-01-02T15:04:05.000"),
+			formatTraceDuration(summary.Last.Sub(summary.First)),
+		})
+	}
+
+	return writeTable(os.Stdout, []string{"ID", "ERRORS", "RECORDS", "WHOS", "FIRST", "DURATION"}, rows)
+}
+
+// getTraceIDs returns the ctx and RequestID of the record, if it has them
+func getTraceIDs(logRecord *LogRecord) []string {
+	var ids []string
+
+	if len(logRecord.Ctx) != 0 {
+		ids = append(ids, logRecord.Ctx)
+	}
+
+	if requestID, found := logRecord.GetField("more.RequestID"); found {
+		if requestIDString, isString := requestID.(string); isString &&
+			len(requestIDString) != 0 &&
+			requestIDString != logRecord.Ctx {
+			ids = append(ids, requestIDString)
+		}
+	}
+
+	return ids
+}
+
+// traceIDSummarizer is a LogWriter which summarizes the records of each ctx and RequestID
+type traceIDSummarizer struct {
+	summaries map[string]*TraceIDSummary
+	whos      map[string]map[string]interface{}
+}
+
+func (tis *traceIDSummarizer) Write(logRecord *LogRecord) error {
+	for _, id := range getTraceIDs(logRecord) {
+		summary, found := tis.summaries[id]
+		if !found {
+			summary = &TraceIDSummary{
+				ID:    id,
+				First: logRecord.When,
+			}
+
+			tis.summaries[id] = summary
+			tis.whos[id] = map[string]interface{}{}
+		}
+
+		summary.Records++
+		summary.Last = logRecord.When
+		tis.whos[id][logRecord.Who] = nil
+
+		if strings.EqualFold(logRecord.Severity, "error") {
+			summary.Errors++
+		}
+	}
+
+	return nil
+}
+
+// traceWriter writes the records of a trace
+type traceWriter struct {
+	writer                 io.Writer
+	color                  bool
+	whoWidth               int
+	laneWidth              int
+	humanReadableFormatter *HumanReadableFormatter
+}
+
+// writeTimeline writes a line per record, indented by service (in order of appearance), with the time since the
+// trace started and, when the service changes, the time since the previous record
+func (tw *traceWriter) writeTimeline(id string, logRecords []*LogRecord) error {
+	whos := tw.getWhos(logRecords)
+
+	if err := tw.writeSummary(id, logRecords, whos); err != nil {
+		return err
+	}
+
+	for logRecordIndex, logRecord := range logRecords {
+		line := fmt.Sprintf("%10s  %s  %s%s %s %s",
+			"+"+formatTraceDuration(logRecord.When.Sub(logRecords[0].When)),
+			logRecord.When.Format("15:04:05.000000"),
+			strings.Repeat("  ", whos[logRecord.Who]),
+			tw.colorize(rtruncateString(logRecord.Who, tw.whoWidth), ansi.LightBlack),
+			tw.formatSeverity(logRecord),
+			tw.colorize(logRecord.What, ansi.Cyan))
+
+		if hop := tw.getHop(logRecords, logRecordIndex); len(hop) != 0 {
+			line += "  " + tw.colorize(hop, ansi.Yellow)
+		}
+
+		if _, err := fmt.Fprintln(tw.writer, line); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeSwimlanes writes a line per record, with the record in its service's column
+func (tw *traceWriter) writeSwimlanes(id string, logRecords []*LogRecord) error {
+	whos := tw.getWhos(logRecords)
+
+	if err := tw.writeSummary(id, logRecords, whos); err != nil {
+		return err
+	}
+
+	lanes := make([]string, len(whos))
+	for who, lane := range whos {
+		lanes[lane] = fitString(rtruncateString(who, tw.laneWidth), tw.laneWidth)
+	}
+
+	if _, err := fmt.Fprintf(tw.writer, "%10s  %s | %s |\n", "", fitString("", 15), strings.Join(lanes, " | ")); err != nil {
+		return err
+	}
+
+	for logRecordIndex, logRecord := range logRecords {
+		for lane := range lanes {
+			lanes[lane] = strings.Repeat(" ", tw.laneWidth)
+		}
+
+		// the severity takes 4 columns ("(E) ")
+		lanes[whos[logRecord.Who]] = tw.formatSeverity(logRecord) + " " +
+			tw.colorize(fitString(logRecord.What, tw.laneWidth-4), ansi.Cyan)
+
+		line := fmt.Sprintf("%10s  %s | %s |",
+			"+"+formatTraceDuration(logRecord.When.Sub(logRecords[0].When)),
+			logRecord.When.Format("15:04:05.000000"),
+			strings.Join(lanes, " | "))
+
+		if hop := tw.getHop(logRecords, logRecordIndex); len(hop) != 0 {
+			line += " " + tw.colorize(hop, ansi.Yellow)
+		}
+
+		if _, err := fmt.Fprintln(tw.writer, line); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (tw *traceWriter) writeSummary(id string, logRecords []*LogRecord, whos map[string]int) error {
+	errorCount := 0
+	for _, logRecord := range logRecords {
+		if strings.EqualFold(logRecord.Severity, "error") {
+			errorCount++
+		}
+	}
+
+	_, err := fmt.Fprintf(tw.writer, "%s: %d records, %d services, %d errors, %s (%s - %s)\n",
+		id,
+		len(logRecords),
+		len(whos),
+		errorCount,
+		formatTraceDuration(logRecords[len(logRecords)-1].When.Sub(logRecords[0].When)),
+		logRecords[0].When.Format("2006-01-02T15:04:05.000000"),
+		logRecords[len(logRecords)-1].When.Format("2006-01-02T15:04:05.000000"))
+
+	return err
+}
+
+// getWhos returns the lane of each who, in order of appearance
+func (tw *traceWriter) getWhos(logRecords []*LogRecord) map[string]int {
+	whos := map[string]int{}
+
+	for _, logRecord := range logRecords {
+		if _, found := whos[logRecord.Who]; !found {
+			whos[logRecord.Who] = len(whos)
+		}
+	}
+
+	return whos
+}
+
+// getHop describes the hop to the record from the previous one, if the service changed
+func (tw *traceWriter) getHop(logRecords []*LogRecord, logRecordIndex int) string {
+	if logRecordIndex == 0 || logRecords[logRecordIndex-1].Who == logRecords[logRecordIndex].Who {
+		return ""
+	}
+
+	previousLogRecord := logRecords[logRecordIndex-1]
+
+	return fmt.Sprintf("<- %s from %s",
+		formatTraceDuration(logRecords[logRecordIndex].When.Sub(previousLogRecord.When)),
+		rtruncateString(previousLogRecord.Who, tw.whoWidth))
+}
+
+func (tw *traceWriter) formatSeverity(logRecord *LogRecord) string {
+	severityCode := byte('?')
+	if len(logRecord.Severity) != 0 {
+		severityCode = logRecord.Severity[0]
+	}
+
+	return fmt.Sprintf("(%s)", tw.colorize(string(severityCode), tw.humanReadableFormatter.getSeverityColor(severityCode)))
+}
+
+func (tw *traceWriter) colorize(s string, color string) string {
+	if !tw.color {
+		return s
+	}
+
+	return color + s + ansi.Reset
+}
+
+// formatTraceDuration formats a duration with a precision which fits its magnitude
+func formatTraceDuration(duration time.Duration) string {
+	switch {
+	case duration >= time.Second:
+		return duration.Round(time.Millisecond).String()
+	default:
+		return duration.Round(time.Microsecond).String()
+	}
+}
+
+// fitString truncates (with an ellipsis) or pads s to exactly width characters
+func fitString(s string, width int) string {
+	runeCount := utf8.RuneCountInString(s)
+
+	if runeCount > width {
+		if width <= 0 {
+			return ""
+		}
+
+		return string([]rune(s)[:width-1]) + "…"
+	}
+
+	return s + strings.Repeat(" ", width-runeCount)
+}
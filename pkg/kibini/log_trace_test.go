@@ -0,0 +1,43 @@
+package kibini
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestTraceIgnoresFollow(t *testing.T) {
+	inputPath := writeTestLogFile(t, "input.log",
+		`{"when":"2023-01-02T10:00:01.000","who":"api","severity":"info","what":"received","ctx":"abc"}`,
+		`{"when":"2023-01-02T10:00:02.000","who":"db","severity":"error","what":"failed","ctx":"abc"}`,
+		`{"when":"2023-01-02T10:00:03.000","who":"api","severity":"info","what":"unrelated","ctx":"def"}`)
+
+	kibiniInstance := NewKibini(newTestLogger(t))
+	inputOptions := InputOptions{
+		InputPath:   filepath.Dir(inputPath),
+		InputFollow: true,
+	}
+
+	var logRecords []*LogRecord
+	var summaries []*TraceIDSummary
+	var recordsErr, summariesErr error
+
+	requireReturns(t, func() {
+		logRecords, recordsErr = kibiniInstance.GetTraceRecords(&inputOptions, "abc")
+	})
+
+	requireReturns(t, func() {
+		summaries, summariesErr = kibiniInstance.GetTraceIDSummaries(&inputOptions)
+	})
+
+	if recordsErr != nil || summariesErr != nil {
+		t.Fatalf("Failed to trace: %v, %v", recordsErr, summariesErr)
+	}
+
+	if len(logRecords) != 2 || logRecords[1].Who != "db" {
+		t.Fatalf("Expected the 2 records of abc, got %d", len(logRecords))
+	}
+
+	if len(summaries) != 2 || summaries[0].ID != "abc" || summaries[0].Errors != 1 {
+		t.Fatalf("Expected abc to be listed first, got %+v", summaries)
+	}
+}
@@ -0,0 +1,772 @@
+package kibini
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/mattn/go-runewidth"
+	"github.com/nuclio/logger"
+)
+
+const logViewerHelp = "q quit | j/k/pgup/pgdn/g/G move | enter expand | X collapse all | / search, n/N next/prev | " +
+	"t jump to time | d/i/w/e/v toggle severity | s select who | f follow"
+
+// the formats accepted when jumping to a timestamp. formats without a date use the date of the first record
+var logViewerJumpTimeFormats = []string{
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+	"15:04:05.999999999",
+	"15:04",
+}
+
+type logViewerMode int
+
+const (
+	logViewerModeNormal logViewerMode = iota
+	logViewerModeSearch
+	logViewerModeJump
+	logViewerModeWhoPicker
+)
+
+// logViewerUpdateEvent is posted to the screen when records are written, so that the viewer redraws
+type logViewerUpdateEvent struct {
+	tcell.EventTime
+}
+
+// LogViewer is an interactive, full screen terminal viewer of log records. Records are written to it
+// (it's a LogWriter) while Run handles the keyboard and draws the screen. It works against any tcell.Screen,
+// so it can be driven by a tcell.SimulationScreen
+type LogViewer struct {
+	logger             logger.Logger
+	screen             tcell.Screen
+	whoWidth           int
+	lock               sync.Mutex
+	records            []*LogRecord
+	visibleRecords     []*LogRecord
+	whos               map[string]bool
+	hiddenWhos         map[string]bool
+	hiddenSeverities   map[string]bool
+	expandedRecords    map[*LogRecord]bool
+	cursor             int
+	top                int
+	follow             bool
+	mode               logViewerMode
+	input              string
+	search             string
+	searchStartCursor  int
+	whoPickerCursor    int
+	statusMessage      string
+	running            int32
+	updateEventPending int32
+}
+
+// NewLogViewer creates a LogViewer which draws on the given screen. If follow is set, the viewer scrolls
+// to new records as they arrive
+func NewLogViewer(logger logger.Logger, screen tcell.Screen, whoWidth int, follow bool) *LogViewer {
+	return &LogViewer{
+		logger:           logger.GetChild("viewer"),
+		screen:           screen,
+		whoWidth:         whoWidth,
+		whos:             map[string]bool{},
+		hiddenWhos:       map[string]bool{},
+		hiddenSeverities: map[string]bool{},
+		expandedRecords:  map[*LogRecord]bool{},
+		follow:           follow,
+		statusMessage:    "? for help",
+	}
+}
+
+// Write adds a record to the viewer, keeping records sorted by time
+func (lv *LogViewer) Write(logRecord *LogRecord) error {
+	lv.lock.Lock()
+
+	lv.records = lv.insertSorted(lv.records, logRecord)
+	lv.whos[logRecord.Who] = true
+
+	if lv.isRecordVisible(logRecord) {
+		insertIndex := lv.findInsertIndex(lv.visibleRecords, logRecord)
+		lv.visibleRecords = lv.insertSorted(lv.visibleRecords, logRecord)
+
+		// keep the cursor on the same record
+		if insertIndex <= lv.cursor && len(lv.visibleRecords) > 1 {
+			lv.cursor++
+		}
+
+		if lv.follow {
+			lv.cursor = len(lv.visibleRecords) - 1
+		}
+	}
+
+	lv.lock.Unlock()
+
+	lv.postUpdateEvent()
+
+	return nil
+}
+
+// SetStatusMessage shows a message in the status line
+func (lv *LogViewer) SetStatusMessage(statusMessage string) {
+	lv.lock.Lock()
+	lv.statusMessage = statusMessage
+	lv.lock.Unlock()
+
+	lv.postUpdateEvent()
+}
+
+// Run initializes the screen, handles events until the user quits and restores the screen. Records may be
+// written before Run is called
+func (lv *LogViewer) Run() error {
+	if err := lv.screen.Init(); err != nil {
+		return err
+	}
+
+	defer lv.screen.Fini()
+
+	// events may only be posted to an initialized screen. whatever was written until now is drawn below
+	atomic.StoreInt32(&lv.running, 1)
+	defer atomic.StoreInt32(&lv.running, 0)
+
+	lv.draw()
+
+	for {
+		event := lv.screen.PollEvent()
+
+		switch typedEvent := event.(type) {
+		case nil:
+			return nil
+
+		case *tcell.EventResize:
+			lv.screen.Sync()
+
+		case *logViewerUpdateEvent:
+			atomic.StoreInt32(&lv.updateEventPending, 0)
+
+		case *tcell.EventKey:
+			if quit := lv.handleKey(typedEvent); quit {
+				return nil
+			}
+		}
+
+		lv.draw()
+	}
+}
+
+// postUpdateEvent asks Run for a redraw, unless one is already pending or Run isn't running
+func (lv *LogViewer) postUpdateEvent() {
+	if atomic.LoadInt32(&lv.running) == 0 || !atomic.CompareAndSwapInt32(&lv.updateEventPending, 0, 1) {
+		return
+	}
+
+	updateEvent := &logViewerUpdateEvent{}
+	updateEvent.SetEventNow()
+
+	if err := lv.screen.PostEvent(updateEvent); err != nil {
+		atomic.StoreInt32(&lv.updateEventPending, 0)
+	}
+}
+
+func (lv *LogViewer) handleKey(keyEvent *tcell.EventKey) bool {
+	lv.lock.Lock()
+	defer lv.lock.Unlock()
+
+	switch lv.mode {
+	case logViewerModeSearch, logViewerModeJump:
+		lv.handleInputKey(keyEvent)
+	case logViewerModeWhoPicker:
+		lv.handleWhoPickerKey(keyEvent)
+	default:
+		return lv.handleNormalKey(keyEvent)
+	}
+
+	return false
+}
+
+func (lv *LogViewer) handleNormalKey(keyEvent *tcell.EventKey) bool {
+	_, contentHeight := lv.getContentSize()
+
+	switch keyEvent.Key() {
+	case tcell.KeyCtrlC, tcell.KeyEscape:
+		return true
+	case tcell.KeyDown:
+		lv.moveCursor(1)
+	case tcell.KeyUp:
+		lv.moveCursor(-1)
+	case tcell.KeyPgDn, tcell.KeyCtrlF:
+		lv.moveCursor(contentHeight)
+	case tcell.KeyPgUp, tcell.KeyCtrlB:
+		lv.moveCursor(-contentHeight)
+	case tcell.KeyHome:
+		lv.moveCursor(-len(lv.visibleRecords))
+	case tcell.KeyEnd:
+		lv.moveCursor(len(lv.visibleRecords))
+	case tcell.KeyEnter:
+		lv.toggleExpanded()
+	case tcell.KeyRune:
+		switch keyRune := keyEvent.Rune(); keyRune {
+		case 'q':
+			return true
+		case 'j':
+			lv.moveCursor(1)
+		case 'k':
+			lv.moveCursor(-1)
+		case 'g':
+			lv.moveCursor(-len(lv.visibleRecords))
+		case 'G':
+			lv.moveCursor(len(lv.visibleRecords))
+		case ' ':
+			lv.toggleExpanded()
+		case 'X':
+			lv.expandedRecords = map[*LogRecord]bool{}
+		case '/':
+			lv.mode = logViewerModeSearch
+			lv.input = ""
+			lv.searchStartCursor = lv.cursor
+		case 'n':
+			lv.findMatch(lv.cursor+1, 1)
+		case 'N':
+			lv.findMatch(lv.cursor-1, -1)
+		case 't':
+			lv.mode = logViewerModeJump
+			lv.input = ""
+		case 's':
+			lv.mode = logViewerModeWhoPicker
+		case 'f':
+			lv.follow = !lv.follow
+			if lv.follow {
+				lv.cursor = len(lv.visibleRecords) - 1
+			}
+		case 'd', 'i', 'w', 'e', 'v':
+			lv.toggleSeverity(keyRune)
+		case '?':
+			lv.statusMessage = logViewerHelp
+		}
+	}
+
+	return false
+}
+
+func (lv *LogViewer) handleInputKey(keyEvent *tcell.EventKey) {
+	switch keyEvent.Key() {
+	case tcell.KeyEscape:
+		if lv.mode == logViewerModeSearch {
+			lv.search = ""
+			lv.cursor = lv.searchStartCursor
+		}
+
+		lv.mode = logViewerModeNormal
+		return
+
+	case tcell.KeyEnter:
+		if lv.mode == logViewerModeJump {
+			lv.jumpToTime(lv.input)
+		}
+
+		lv.mode = logViewerModeNormal
+		return
+
+	case tcell.KeyBackspace, tcell.KeyBackspace2:
+		if len(lv.input) != 0 {
+			inputRunes := []rune(lv.input)
+			lv.input = string(inputRunes[:len(inputRunes)-1])
+		}
+
+	case tcell.KeyRune:
+		lv.input += string(keyEvent.Rune())
+	}
+
+	// search is incremental - look for the first match from where the search started
+	if lv.mode == logViewerModeSearch {
+		lv.search = lv.input
+		lv.cursor = lv.searchStartCursor
+		lv.findMatch(lv.searchStartCursor, 1)
+	}
+}
+
+func (lv *LogViewer) handleWhoPickerKey(keyEvent *tcell.EventKey) {
+	whos := lv.getSortedWhos()
+
+	switch keyEvent.Key() {
+	case tcell.KeyEscape, tcell.KeyEnter:
+		lv.mode = logViewerModeNormal
+	case tcell.KeyDown:
+		lv.whoPickerCursor = lv.clamp(lv.whoPickerCursor+1, len(whos))
+	case tcell.KeyUp:
+		lv.whoPickerCursor = lv.clamp(lv.whoPickerCursor-1, len(whos))
+	case tcell.KeyRune:
+		switch keyEvent.Rune() {
+		case 's', 'q':
+			lv.mode = logViewerModeNormal
+		case 'j':
+			lv.whoPickerCursor = lv.clamp(lv.whoPickerCursor+1, len(whos))
+		case 'k':
+			lv.whoPickerCursor = lv.clamp(lv.whoPickerCursor-1, len(whos))
+		case ' ':
+			if lv.whoPickerCursor < len(whos) {
+				who := whos[lv.whoPickerCursor]
+				lv.hiddenWhos[who] = !lv.hiddenWhos[who]
+				lv.updateVisibleRecords()
+			}
+		case 'a':
+
+			// if anything is hidden show all, otherwise hide all
+			hide := len(lv.getHiddenWhos()) == 0
+			for _, who := range whos {
+				lv.hiddenWhos[who] = hide
+			}
+
+			lv.updateVisibleRecords()
+		}
+	}
+}
+
+func (lv *LogViewer) moveCursor(delta int) {
+	lv.cursor = lv.clamp(lv.cursor+delta, len(lv.visibleRecords))
+
+	// moving away from the end stops following
+	lv.follow = lv.follow && lv.cursor == len(lv.visibleRecords)-1
+}
+
+func (lv *LogViewer) toggleExpanded() {
+	if lv.cursor < len(lv.visibleRecords) {
+		logRecord := lv.visibleRecords[lv.cursor]
+		lv.expandedRecords[logRecord] = !lv.expandedRecords[logRecord]
+	}
+}
+
+func (lv *LogViewer) toggleSeverity(severityRune rune) {
+	severityCode := strings.ToUpper(string(severityRune))
+	lv.hiddenSeverities[severityCode] = !lv.hiddenSeverities[severityCode]
+	lv.updateVisibleRecords()
+}
+
+// findMatch moves the cursor to the next record (from start, in the given direction) matching the search
+func (lv *LogViewer) findMatch(start int, direction int) {
+	if len(lv.search) == 0 {
+		return
+	}
+
+	search := strings.ToLower(lv.search)
+
+	for recordIndex := start; recordIndex >= 0 && recordIndex < len(lv.visibleRecords); recordIndex += direction {
+		if strings.Contains(strings.ToLower(lv.formatRecordLine(lv.visibleRecords[recordIndex])), search) {
+			lv.cursor = recordIndex
+			lv.follow = false
+			lv.statusMessage = ""
+			return
+		}
+	}
+
+	lv.statusMessage = fmt.Sprintf("Not found: %s", lv.search)
+}
+
+func (lv *LogViewer) jumpToTime(timeString string) {
+	var jumpTime time.Time
+	var err error
+
+	for _, timeFormat := range logViewerJumpTimeFormats {
+		if jumpTime, err = time.Parse(timeFormat, strings.TrimSpace(timeString)); err == nil {
+
+			// formats without a date are relative to the first record's date
+			if jumpTime.Year() == 0 && len(lv.records) != 0 {
+				year, month, day := lv.records[0].When.Date()
+				jumpTime = jumpTime.AddDate(year, int(month)-1, day-1)
+			}
+
+			break
+		}
+	}
+
+	if err != nil {
+		lv.statusMessage = fmt.Sprintf("Invalid time: %s", timeString)
+		return
+	}
+
+	lv.cursor = lv.clamp(sort.Search(len(lv.visibleRecords), func(recordIndex int) bool {
+		return !lv.visibleRecords[recordIndex].When.Before(jumpTime)
+	}), len(lv.visibleRecords))
+	lv.follow = false
+	lv.statusMessage = ""
+}
+
+// updateVisibleRecords applies the filters, keeping the cursor at the same point in time
+func (lv *LogViewer) updateVisibleRecords() {
+	var cursorRecord *LogRecord
+	if lv.cursor < len(lv.visibleRecords) {
+		cursorRecord = lv.visibleRecords[lv.cursor]
+	}
+
+	lv.visibleRecords = nil
+	for _, logRecord := range lv.records {
+		if lv.isRecordVisible(logRecord) {
+			lv.visibleRecords = append(lv.visibleRecords, logRecord)
+		}
+	}
+
+	if cursorRecord != nil {
+		lv.cursor = lv.clamp(lv.findInsertIndex(lv.visibleRecords, cursorRecord)-1, len(lv.visibleRecords))
+	}
+
+	if lv.follow {
+		lv.cursor = len(lv.visibleRecords) - 1
+	}
+}
+
+func (lv *LogViewer) isRecordVisible(logRecord *LogRecord) bool {
+	return !lv.hiddenSeverities[lv.getSeverityCode(logRecord)] && !lv.hiddenWhos[logRecord.Who]
+}
+
+// findInsertIndex returns the index after all records that are not newer than the record
+func (lv *LogViewer) findInsertIndex(logRecords []*LogRecord, logRecord *LogRecord) int {
+	return sort.Search(len(logRecords), func(recordIndex int) bool {
+		return logRecords[recordIndex].WhenUnixNano > logRecord.WhenUnixNano
+	})
+}
+
+func (lv *LogViewer) insertSorted(logRecords []*LogRecord, logRecord *LogRecord) []*LogRecord {
+	insertIndex := lv.findInsertIndex(logRecords, logRecord)
+
+	logRecords = append(logRecords, nil)
+	copy(logRecords[insertIndex+1:], logRecords[insertIndex:])
+	logRecords[insertIndex] = logRecord
+
+	return logRecords
+}
+
+func (lv *LogViewer) draw() {
+	lv.lock.Lock()
+	defer lv.lock.Unlock()
+
+	lv.screen.Clear()
+
+	width, contentHeight := lv.getContentSize()
+
+	if lv.mode == logViewerModeWhoPicker {
+		lv.drawWhoPicker(width, contentHeight)
+	} else {
+		lv.drawRecords(width, contentHeight)
+	}
+
+	lv.drawStatusLine(width, contentHeight)
+	lv.screen.Show()
+}
+
+func (lv *LogViewer) drawRecords(width int, contentHeight int) {
+	if len(lv.visibleRecords) == 0 {
+		return
+	}
+
+	lv.cursor = lv.clamp(lv.cursor, len(lv.visibleRecords))
+
+	// scroll so that the cursor record is entirely visible
+	if lv.cursor < lv.top {
+		lv.top = lv.cursor
+	}
+
+	for lv.top < lv.cursor && lv.getLinesHeight(lv.top, lv.cursor) > contentHeight {
+		lv.top++
+	}
+
+	y := 0
+	for recordIndex := lv.top; recordIndex < len(lv.visibleRecords) && y < contentHeight; recordIndex++ {
+		logRecord := lv.visibleRecords[recordIndex]
+		selected := recordIndex == lv.cursor
+
+		lv.drawRecordLine(y, width, logRecord, selected)
+		y++
+
+		for _, moreLine := range lv.getExpandedLines(logRecord) {
+			if y >= contentHeight {
+				break
+			}
+
+			lv.drawText(0, y, width, "    "+moreLine, tcell.StyleDefault.Foreground(tcell.ColorSilver))
+			y++
+		}
+	}
+}
+
+func (lv *LogViewer) drawRecordLine(y int, width int, logRecord *LogRecord, selected bool) {
+	baseStyle := tcell.StyleDefault
+	if selected {
+		baseStyle = baseStyle.Reverse(true)
+	}
+
+	x := 0
+	for _, segment := range []struct {
+		text  string
+		style tcell.Style
+	}{
+		{logRecord.When.Format("02.01.06 15:04:05.000000") + " ", baseStyle.Foreground(tcell.ColorGray)},
+		{fmt.Sprintf("%*s ", lv.whoWidth, rtruncateString(logRecord.Who, lv.whoWidth)), baseStyle.Foreground(tcell.ColorGray)},
+		{"(" + lv.getSeverityCode(logRecord) + ") ", baseStyle.Foreground(lv.getSeverityColor(logRecord))},
+		{logRecord.What + " ", baseStyle.Foreground(tcell.ColorTeal)},
+		{lv.formatCompactMore(logRecord), baseStyle},
+	} {
+		x = lv.drawText(x, y, width, segment.text, segment.style)
+	}
+
+	// highlight search matches
+	if len(lv.search) != 0 {
+		lv.highlightMatches(y, width, lv.formatRecordLine(logRecord))
+	}
+}
+
+func (lv *LogViewer) drawWhoPicker(width int, contentHeight int) {
+	whos := lv.getSortedWhos()
+	lv.whoPickerCursor = lv.clamp(lv.whoPickerCursor, len(whos))
+
+	lv.drawText(0, 0, width, "Select who (space: toggle, a: all/none, enter: done)", tcell.StyleDefault.Bold(true))
+
+	// scroll the list so the cursor is visible
+	firstWho := 0
+	if lv.whoPickerCursor >= contentHeight-1 {
+		firstWho = lv.whoPickerCursor - contentHeight + 2
+	}
+
+	for whoIndex := firstWho; whoIndex < len(whos) && whoIndex-firstWho+1 < contentHeight; whoIndex++ {
+		mark := "[x] "
+		if lv.hiddenWhos[whos[whoIndex]] {
+			mark = "[ ] "
+		}
+
+		style := tcell.StyleDefault
+		if whoIndex == lv.whoPickerCursor {
+			style = style.Reverse(true)
+		}
+
+		lv.drawText(0, whoIndex-firstWho+1, width, mark+whos[whoIndex], style)
+	}
+}
+
+func (lv *LogViewer) drawStatusLine(width int, y int) {
+	var status string
+	style := tcell.StyleDefault.Reverse(true)
+
+	switch lv.mode {
+	case logViewerModeSearch:
+		status = "/" + lv.input
+	case logViewerModeJump:
+		status = "jump to time: " + lv.input
+	default:
+		follow := ""
+		if lv.follow {
+			follow = " | follow"
+		}
+
+		hiddenSeverities := ""
+		for _, severityCode := range []string{"D", "I", "W", "E", "V"} {
+			if lv.hiddenSeverities[severityCode] {
+				hiddenSeverities += severityCode
+			}
+		}
+
+		if len(hiddenSeverities) != 0 {
+			hiddenSeverities = " | hidden: " + hiddenSeverities
+		}
+
+		if hiddenWhos := lv.getHiddenWhos(); len(hiddenWhos) != 0 {
+			hiddenSeverities += fmt.Sprintf(" | %d who hidden", len(hiddenWhos))
+		}
+
+		status = fmt.Sprintf("%d/%d/%d%s%s | %s",
+			lv.clamp(lv.cursor, len(lv.visibleRecords))+1,
+			len(lv.visibleRecords),
+			len(lv.records),
+			follow,
+			hiddenSeverities,
+			lv.statusMessage)
+	}
+
+	x := lv.drawText(0, y, width, status, style)
+	lv.drawText(x, y, width, strings.Repeat(" ", width), style)
+}
+
+// drawText draws text on a line, clipped to width, and returns the x after it
+func (lv *LogViewer) drawText(x int, y int, width int, text string, style tcell.Style) int {
+	for _, textRune := range text {
+		if x >= width {
+			break
+		}
+
+		lv.screen.SetContent(x, y, textRune, nil, style)
+		x += runewidth.RuneWidth(textRune)
+	}
+
+	return x
+}
+
+// highlightMatches highlights matches of the search in a line drawn with drawText. runes may be wider than a
+// single cell, so matches are placed by the display width of what precedes them
+func (lv *LogViewer) highlightMatches(y int, width int, line string) {
+	lineRunes := []rune(line)
+	searchRunes := []rune(lv.search)
+
+	// lower case rune by rune, so that indices into the line are kept
+	lowerLineRunes := make([]rune, len(lineRunes))
+	for runeIndex, lineRune := range lineRunes {
+		lowerLineRunes[runeIndex] = unicode.ToLower(lineRune)
+	}
+
+	for runeIndex, searchRune := range searchRunes {
+		searchRunes[runeIndex] = unicode.ToLower(searchRune)
+	}
+
+	// the x at which each rune is drawn
+	runeXs := make([]int, len(lineRunes))
+	x := 0
+	for runeIndex, lineRune := range lineRunes {
+		runeXs[runeIndex] = x
+		x += runewidth.RuneWidth(lineRune)
+	}
+
+	for runeIndex := 0; runeIndex+len(searchRunes) <= len(lowerLineRunes) && runeXs[runeIndex] < width; runeIndex++ {
+		if string(lowerLineRunes[runeIndex:runeIndex+len(searchRunes)]) != string(searchRunes) {
+			continue
+		}
+
+		for matchIndex := runeIndex; matchIndex < runeIndex+len(searchRunes) && runeXs[matchIndex] < width; matchIndex++ {
+			mainRune, combiningRunes, _, _ := lv.screen.GetContent(runeXs[matchIndex], y)
+			lv.screen.SetContent(runeXs[matchIndex], y, mainRune, combiningRunes,
+				tcell.StyleDefault.Background(tcell.ColorYellow).Foreground(tcell.ColorBlack))
+		}
+	}
+}
+
+// formatRecordLine returns the text of the record's line, as drawn
+func (lv *LogViewer) formatRecordLine(logRecord *LogRecord) string {
+	return fmt.Sprintf("%s %*s (%s) %s %s",
+		logRecord.When.Format("02.01.06 15:04:05.000000"),
+		lv.whoWidth,
+		rtruncateString(logRecord.Who, lv.whoWidth),
+		lv.getSeverityCode(logRecord),
+		logRecord.What,
+		lv.formatCompactMore(logRecord))
+}
+
+func (lv *LogViewer) formatCompactMore(logRecord *LogRecord) string {
+	if len(logRecord.More) == 0 && len(logRecord.Ctx) == 0 {
+		return ""
+	}
+
+	marshalledMore, err := json.Marshal(lv.getMoreWithCtx(logRecord))
+	if err != nil {
+		return ""
+	}
+
+	return string(marshalledMore)
+}
+
+// getExpandedLines returns the pretty printed "more" of an expanded record
+func (lv *LogViewer) getExpandedLines(logRecord *LogRecord) []string {
+	if !lv.expandedRecords[logRecord] {
+		return nil
+	}
+
+	marshalledMore, err := json.MarshalIndent(lv.getMoreWithCtx(logRecord), "", "    ")
+	if err != nil {
+		return []string{err.Error()}
+	}
+
+	var expandedLines []string
+	for _, line := range strings.Split(string(marshalledMore), "\n") {
+
+		// multi line values (e.g. stack traces) are more readable unescaped
+		expandedLines = append(expandedLines, strings.Split(strings.Replace(line, "\\n", "\n", -1), "\n")...)
+	}
+
+	return expandedLines
+}
+
+func (lv *LogViewer) getMoreWithCtx(logRecord *LogRecord) map[string]interface{} {
+	more := logRecord.GetTypedMore()
+	if len(logRecord.Ctx) != 0 {
+		more["ctx"] = logRecord.Ctx
+	}
+
+	return more
+}
+
+// getLinesHeight returns the number of lines records take, from first to last (inclusive)
+func (lv *LogViewer) getLinesHeight(first int, last int) int {
+	height := 0
+	for recordIndex := first; recordIndex <= last; recordIndex++ {
+		height += 1 + len(lv.getExpandedLines(lv.visibleRecords[recordIndex]))
+	}
+
+	return height
+}
+
+// getContentSize returns the size of the area records are drawn in (all but the status line)
+func (lv *LogViewer) getContentSize() (int, int) {
+	width, height := lv.screen.Size()
+	if height < 2 {
+		return width, 1
+	}
+
+	return width, height - 1
+}
+
+func (lv *LogViewer) getSortedWhos() []string {
+	whos := make([]string, 0, len(lv.whos))
+	for who := range lv.whos {
+		whos = append(whos, who)
+	}
+
+	sort.Strings(whos)
+
+	return whos
+}
+
+func (lv *LogViewer) getHiddenWhos() []string {
+	var hiddenWhos []string
+	for who, hidden := range lv.hiddenWhos {
+		if hidden {
+			hiddenWhos = append(hiddenWhos, who)
+		}
+	}
+
+	return hiddenWhos
+}
+
+func (lv *LogViewer) getSeverityCode(logRecord *LogRecord) string {
+	if len(logRecord.Severity) == 0 {
+		return "?"
+	}
+
+	return strings.ToUpper(logRecord.Severity[:1])
+}
+
+func (lv *LogViewer) getSeverityColor(logRecord *LogRecord) tcell.Color {
+	switch lv.getSeverityCode(logRecord) {
+	case "V":
+		return tcell.ColorLightBlue
+	case "W":
+		return tcell.ColorYellow
+	case "E":
+		return tcell.ColorRed
+	}
+
+	return tcell.ColorDefault
+}
+
+// clamp returns value within [0, length)
+func (lv *LogViewer) clamp(value int, length int) int {
+	if value >= length {
+		value = length - 1
+	}
+
+	if value < 0 {
+		value = 0
+	}
+
+	return value
+}
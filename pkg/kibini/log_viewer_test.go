@@ -0,0 +1,331 @@
+package kibini
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// logViewerTest runs a viewer against a simulation screen (80x25, so 24 lines of records and a status line)
+type logViewerTest struct {
+	t          *testing.T
+	screen     tcell.SimulationScreen
+	logViewer  *LogViewer
+	runErrChan chan error
+}
+
+func newLogViewerTest(t *testing.T, follow bool, logRecords []*LogRecord) *logViewerTest {
+	screen := tcell.NewSimulationScreen("UTF-8")
+	logViewer := NewLogViewer(newTestLogger(t), screen, 10, follow)
+
+	// records written before the screen is initialized must be drawn once it is
+	for _, logRecord := range logRecords {
+		logViewer.Write(logRecord) // nolint: errcheck
+	}
+
+	lvt := &logViewerTest{
+		t:          t,
+		screen:     screen,
+		logViewer:  logViewer,
+		runErrChan: make(chan error, 1),
+	}
+
+	go func() {
+		lvt.runErrChan <- logViewer.Run()
+	}()
+
+	t.Cleanup(lvt.quit)
+
+	// the screen can't be read while it's initialized
+	for deadline := time.Now().Add(2 * time.Second); atomic.LoadInt32(&logViewer.running) == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the viewer to run")
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	lvt.waitForStatus(fmt.Sprintf("/%d/%d", len(logRecords), len(logRecords)))
+
+	return lvt
+}
+
+func (lvt *logViewerTest) quit() {
+	lvt.screen.InjectKey(tcell.KeyCtrlC, 0, tcell.ModNone)
+
+	select {
+	case err := <-lvt.runErrChan:
+		if err != nil {
+			lvt.t.Errorf("Viewer failed: %s", err)
+		}
+	case <-time.After(2 * time.Second):
+		lvt.t.Error("Viewer didn't quit")
+	}
+}
+
+func (lvt *logViewerTest) pressRunes(runes string) {
+	for _, keyRune := range runes {
+		lvt.screen.InjectKey(tcell.KeyRune, keyRune, tcell.ModNone)
+
+		// the simulation screen's event queue is small
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func (lvt *logViewerTest) pressKey(key tcell.Key) {
+	lvt.screen.InjectKey(key, 0, tcell.ModNone)
+}
+
+// getContents returns the screen's cells. they're drawn while the viewer is locked, and aren't copied by the
+// simulation screen
+func (lvt *logViewerTest) getContents() ([]tcell.SimCell, int, int) {
+	lvt.logViewer.lock.Lock()
+	defer lvt.logViewer.lock.Unlock()
+
+	cells, width, height := lvt.screen.GetContents()
+
+	return append([]tcell.SimCell{}, cells...), width, height
+}
+
+// getLines returns the text on the screen, by line
+func (lvt *logViewerTest) getLines() []string {
+	cells, width, height := lvt.getContents()
+
+	lines := make([]string, height)
+	for y := 0; y < height; y++ {
+		var line strings.Builder
+
+		for x := 0; x < width; x++ {
+			if runes := cells[y*width+x].Runes; len(runes) != 0 {
+				line.WriteRune(runes[0])
+			} else {
+				line.WriteRune(' ')
+			}
+		}
+
+		lines[y] = line.String()
+	}
+
+	return lines
+}
+
+// getHighlightedText returns the text of the cells highlighted as search matches
+func (lvt *logViewerTest) getHighlightedText() string {
+	cells, _, _ := lvt.getContents()
+
+	var highlightedText strings.Builder
+	for _, cell := range cells {
+		if _, background, _ := cell.Style.Decompose(); background == tcell.ColorYellow && len(cell.Runes) != 0 {
+			highlightedText.WriteRune(cell.Runes[0])
+		}
+	}
+
+	return highlightedText.String()
+}
+
+// waitFor waits until the screen satisfies the condition
+func (lvt *logViewerTest) waitFor(description string, condition func(lines []string) bool) []string {
+	lvt.t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+
+	for {
+		lines := lvt.getLines()
+		if condition(lines) {
+			return lines
+		}
+
+		if time.Now().After(deadline) {
+			lvt.t.Fatalf("Timed out waiting for %s. Screen:\n%s", description, strings.Join(lines, "\n"))
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func (lvt *logViewerTest) waitForStatus(status string) []string {
+	lvt.t.Helper()
+
+	return lvt.waitFor("status "+status, func(lines []string) bool {
+		return len(lines) != 0 && strings.Contains(lines[len(lines)-1], status)
+	})
+}
+
+func createLogViewerTestRecords(count int) []*LogRecord {
+	var logRecords []*LogRecord
+
+	for recordIndex := 0; recordIndex < count; recordIndex++ {
+		who, severity := "api", "info"
+		if recordIndex%2 == 1 {
+			who = "db"
+		}
+
+		if recordIndex%5 == 4 {
+			severity = "error"
+		}
+
+		logRecords = append(logRecords, NewLogRecord(fmt.Sprintf(
+			`{"when":"2023-01-02T10:00:%02d.000","who":"%s","severity":"%s","what":"record %02d","more":{"index":%d}}`,
+			recordIndex, who, severity, recordIndex, recordIndex)))
+	}
+
+	return logRecords
+}
+
+func TestLogViewerScrolling(t *testing.T) {
+	lvt := newLogViewerTest(t, false, createLogViewerTestRecords(40))
+
+	lines := lvt.waitForStatus("1/40/40")
+	if !strings.Contains(lines[0], "record 00") || !strings.Contains(lines[23], "record 23") {
+		t.Fatalf("Expected the first 24 records, got:\n%s", strings.Join(lines, "\n"))
+	}
+
+	// to the end, which scrolls so that the last record is on the last line
+	lvt.pressRunes("G")
+	lines = lvt.waitForStatus("40/40/40")
+	if !strings.Contains(lines[23], "record 39") || !strings.Contains(lines[0], "record 16") {
+		t.Fatalf("Expected records 16 to 39, got:\n%s", strings.Join(lines, "\n"))
+	}
+
+	lvt.pressKey(tcell.KeyPgUp)
+	lvt.waitForStatus("16/40/40")
+
+	lvt.pressRunes("k")
+	lines = lvt.waitForStatus("15/40/40")
+	if !strings.Contains(lines[0], "record 14") {
+		t.Fatalf("Expected scrolling up to the cursor, got:\n%s", strings.Join(lines, "\n"))
+	}
+
+	lvt.pressRunes("g")
+	lvt.waitForStatus("1/40/40")
+}
+
+func TestLogViewerSearch(t *testing.T) {
+	logRecords := append(createLogViewerTestRecords(10),
+		NewLogRecord(`{"when":"2023-01-02T10:01:00.000","who":"api","severity":"info","what":"日本語 needle here"}`),
+		NewLogRecord(`{"when":"2023-01-02T10:02:00.000","who":"api","severity":"info","what":"another needle"}`))
+
+	lvt := newLogViewerTest(t, false, logRecords)
+
+	// incremental - the cursor moves while typing
+	lvt.pressRunes("/rec")
+	lvt.waitForStatus("/rec")
+	lvt.pressRunes("ord 07")
+	lvt.pressKey(tcell.KeyEnter)
+	lvt.waitForStatus("8/12/12")
+
+	// matches are highlighted where they're drawn, after wide runes as well
+	lvt.pressRunes("/needle")
+	lvt.pressKey(tcell.KeyEnter)
+	lvt.waitForStatus("11/12/12")
+	lvt.waitFor("highlighted matches", func(lines []string) bool {
+		return lvt.getHighlightedText() == "needleneedle"
+	})
+
+	lvt.pressRunes("n")
+	lvt.waitForStatus("12/12/12")
+
+	lvt.pressRunes("N")
+	lvt.waitForStatus("11/12/12")
+
+	lvt.pressRunes("/missing")
+	lvt.pressKey(tcell.KeyEnter)
+	lvt.waitForStatus("Not found: missing")
+}
+
+func TestLogViewerToggles(t *testing.T) {
+	lvt := newLogViewerTest(t, false, createLogViewerTestRecords(20))
+
+	// errors are every 5th record
+	lvt.pressRunes("e")
+	lines := lvt.waitForStatus("1/16/20 | hidden: E")
+	for _, line := range lines[:23] {
+		if strings.Contains(line, "(E)") {
+			t.Fatalf("Expected errors to be hidden, got:\n%s", strings.Join(lines, "\n"))
+		}
+	}
+
+	lvt.pressRunes("e")
+	lvt.waitForStatus("1/20/20 |")
+
+	// hide the first who (api)
+	lvt.pressRunes("s")
+	lvt.waitFor("the who picker", func(lines []string) bool {
+		return strings.HasPrefix(lines[1], "[x] api")
+	})
+
+	lvt.pressRunes(" ")
+	lvt.waitFor("api to be hidden", func(lines []string) bool {
+		return strings.HasPrefix(lines[1], "[ ] api")
+	})
+
+	// the status line is shown under the picker as well
+	lvt.pressKey(tcell.KeyEnter)
+	lines = lvt.waitFor("the picker to close", func(lines []string) bool {
+		return !strings.HasPrefix(lines[0], "Select who")
+	})
+
+	if !strings.Contains(lines[24], "1/10/20 | 1 who hidden") ||
+		!strings.Contains(lines[0], "db") ||
+		strings.Contains(strings.Join(lines, "\n"), " api ") {
+		t.Fatalf("Expected only db records, got:\n%s", strings.Join(lines, "\n"))
+	}
+}
+
+func TestLogViewerExpandAndJump(t *testing.T) {
+	lvt := newLogViewerTest(t, false, createLogViewerTestRecords(30))
+
+	lvt.pressKey(tcell.KeyEnter)
+	lines := lvt.waitFor("the record to expand", func(lines []string) bool {
+		return strings.HasPrefix(lines[1], "    {")
+	})
+
+	if !strings.Contains(lines[2], `"index": 0`) || !strings.Contains(lines[4], "record 01") {
+		t.Fatalf("Expected more to be shown under the record, got:\n%s", strings.Join(lines, "\n"))
+	}
+
+	lvt.pressRunes("X")
+	lvt.waitFor("the record to collapse", func(lines []string) bool {
+		return strings.Contains(lines[1], "record 01")
+	})
+
+	// times without a date are on the first record's date
+	lvt.pressRunes("t10:00:17")
+	lvt.waitForStatus("jump to time: 10:00:17")
+	lvt.pressKey(tcell.KeyEnter)
+	lvt.waitForStatus("18/30/30")
+
+	lvt.pressRunes("tnonsense")
+	lvt.pressKey(tcell.KeyEnter)
+	lvt.waitForStatus("Invalid time: nonsense")
+}
+
+func TestLogViewerFollow(t *testing.T) {
+	logRecords := createLogViewerTestRecords(30)
+	lvt := newLogViewerTest(t, true, logRecords[:20])
+
+	lvt.waitForStatus("20/20/20 | follow")
+
+	for _, logRecord := range logRecords[20:] {
+		lvt.logViewer.Write(logRecord) // nolint: errcheck
+	}
+
+	lines := lvt.waitForStatus("30/30/30 | follow")
+	if !strings.Contains(lines[23], "record 29") {
+		t.Fatalf("Expected to scroll to the newest record, got:\n%s", strings.Join(lines, "\n"))
+	}
+
+	// moving away from the end stops following
+	lvt.pressRunes("k")
+	lvt.waitForStatus("29/30/30 |")
+
+	lvt.logViewer.Write(NewLogRecord(`{"when":"2023-01-02T10:05:00.000","who":"api","severity":"info","what":"late"}`)) // nolint: errcheck
+	lvt.waitForStatus("29/31/31 |")
+
+	lvt.pressRunes("f")
+	lvt.waitForStatus("31/31/31 | follow")
+}
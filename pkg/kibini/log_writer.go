@@ -1,5 +1,51 @@
-package core
+package kibini
 
-type logWriter interface {
-	Write(logRecord *logRecord) error
+import (
+	"github.com/nuclio/errors"
+)
+
+// LogWriter receives log records
+type LogWriter interface {
+	Write(logRecord *LogRecord) error
+}
+
+// LogCloser is implemented by log writers which need to finalize their output once all records were written
+type LogCloser interface {
+	Close() error
+}
+
+// LogUnparseableLineWriter is implemented by log writers which are interested in the lines readers couldn't
+// parse as records (e.g. to count them)
+type LogUnparseableLineWriter interface {
+	WriteUnparseableLine(sourceFile string, lineNumber int, line string) error
+}
+
+// logReaderWatcher is implemented by log writers which need to know the readers writing to them
+type logReaderWatcher interface {
+	watchLogReader(logReader *LogTailReader)
+}
+
+// logMergerWatcher is implemented by log writers which need to know the mergers records are read into
+type logMergerWatcher interface {
+	watchLogMerger(logMerger *LogMerger)
+}
+
+// closeLogWriters closes each of the writers which is a LogCloser, once
+func closeLogWriters(logWriters []LogWriter) error {
+	closedLogWriters := map[LogWriter]bool{}
+
+	for _, logWriter := range logWriters {
+		logCloser, isCloser := logWriter.(LogCloser)
+		if !isCloser || closedLogWriters[logWriter] {
+			continue
+		}
+
+		if err := logCloser.Close(); err != nil {
+			return errors.Wrap(err, "Failed to close log writer")
+		}
+
+		closedLogWriters[logWriter] = true
+	}
+
+	return nil
 }
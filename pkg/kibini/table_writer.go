@@ -0,0 +1,57 @@
+package kibini
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// writeTable writes rows as an aligned text table with a header
+func writeTable(writer io.Writer, header []string, rows [][]string) error {
+	separators := make([]string, len(header))
+	columnWidths := make([]int, len(header))
+
+	// the rows belong to the caller, so cells are escaped into copies
+	tableRows := [][]string{escapeTableRow(header), separators}
+	for _, row := range rows {
+		tableRows = append(tableRows, escapeTableRow(row))
+	}
+
+	for _, row := range tableRows {
+		for columnIndex, cell := range row {
+			if cellWidth := utf8.RuneCountInString(cell); cellWidth > columnWidths[columnIndex] {
+				columnWidths[columnIndex] = cellWidth
+			}
+		}
+	}
+
+	for columnIndex, columnWidth := range columnWidths {
+		separators[columnIndex] = strings.Repeat("-", columnWidth)
+	}
+
+	for _, row := range tableRows {
+		cells := make([]string, len(row))
+
+		for columnIndex, cell := range row {
+			cells[columnIndex] = cell + strings.Repeat(" ", columnWidths[columnIndex]-utf8.RuneCountInString(cell))
+		}
+
+		if _, err := fmt.Fprintln(writer, strings.TrimRight(strings.Join(cells, "  "), " ")); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// escapeTableRow returns a copy of the row whose cells don't span multiple lines, which would break the table
+func escapeTableRow(row []string) []string {
+	escapedRow := make([]string, len(row))
+
+	for columnIndex, cell := range row {
+		escapedRow[columnIndex] = strings.Replace(cell, "\n", "\\n", -1)
+	}
+
+	return escapedRow
+}
@@ -0,0 +1,29 @@
+package kibini
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWriteTableKeepsRows(t *testing.T) {
+	header := []string{"name", "value"}
+	rows := [][]string{{"a", "multi\nline"}, {"longer name", "1"}}
+
+	var output bytes.Buffer
+	if err := writeTable(&output, header, rows); err != nil {
+		t.Fatalf("Failed to write table: %s", err)
+	}
+
+	expected := "name         value\n" +
+		"-----------  -----------\n" +
+		"a            multi\\nline\n" +
+		"longer name  1\n"
+
+	if output.String() != expected {
+		t.Fatalf("Expected\n%s\ngot\n%s", expected, output.String())
+	}
+
+	if rows[0][1] != "multi\nline" || header[0] != "name" {
+		t.Fatalf("Expected the rows to be left as they were, got %q", rows)
+	}
+}